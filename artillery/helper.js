@@ -11,7 +11,8 @@ function setBookingRequest (context, events, done) {
     // create dummy booking request that will fail
     context.vars.bookingRequest = {
       flightId: context.vars.flightId,
-      passengers: [{}]
+      passengers: [{}],
+      paymentToken: 'tok_artillery'
     }
     return done()
   }
@@ -31,12 +32,17 @@ function setBookingRequest (context, events, done) {
 
   const passengers = Array.from(Array(2))
     .map((_v, i) => ({
-      name: `Passenger ${i}`,
+      firstName: `Passenger${i}`,
+      lastName: 'Test',
+      dateOfBirth: '1990-01-01',
+      type: 'adult',
+      email: `passenger${i}@example.com`,
       seat: pickRandomSeat()
     }))
   context.vars.bookingRequest = {
     flightId: context.vars.flightId,
-    passengers
+    passengers,
+    paymentToken: 'tok_artillery'
   }
   return done()
 }
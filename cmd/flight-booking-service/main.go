@@ -2,93 +2,300 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/christophwitzko/flight-booking-service/pkg/certs"
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/christophwitzko/flight-booking-service/pkg/config"
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/seeder"
 	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
 	"github.com/christophwitzko/flight-booking-service/pkg/service"
+	"github.com/christophwitzko/flight-booking-service/pkg/simulator"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/christophwitzko/flight-booking-service/pkg/webhook"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"gopkg.in/yaml.v3"
 )
 
+const name = "flight-booking-service"
+
 func main() {
-	level := logger.DebugLevel
-	levelName := os.Getenv("LOG_LEVEL")
-	switch levelName {
-	case "debug":
-		level = logger.DebugLevel
-	case "info":
-		level = logger.InfoLevel
-	case "warn":
-		level = logger.WarnLevel
-	case "error":
-		level = logger.ErrorLevel
-	}
-	log := logger.New(level)
-	if levelName != "" {
-		log.Infof("log level: %s", levelName)
-	}
-	if err := run(log); err != nil {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		var command func(args []string) error
+		switch args[0] {
+		case "config":
+			command = runConfigCommand
+		case "cert":
+			command = runCertCommand
+		}
+		if command != nil {
+			if err := command(args[1:]); err != nil {
+				exitWithError(err)
+			}
+			return
+		}
+	}
+	cfg, err := config.Load(name, args, os.Getenv)
+	if err != nil {
+		exitWithError(err)
+	}
+	log := logger.New(cfg.LogLevel)
+	log.Infof("log level: %s", cfg.LogLevel)
+	stopToggle := toggleDebugOnHangup(log, cfg.LogLevel)
+	defer stopToggle()
+	if err := run(log, cfg); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func getBindAddress() string {
-	if bindAddress := os.Getenv("BIND_ADDRESS"); bindAddress != "" {
-		return bindAddress
+func exitWithError(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-	if port := os.Getenv("PORT"); port != "" {
-		return ":" + port
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(2)
+}
+
+// runConfigCommand runs the config subcommands, "config print" writes the effective configuration as YAML.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("unknown config command, usage: %s config print [flags]", name)
 	}
-	return "127.0.0.1:3000"
+	cfg, err := config.Load(name+" config print", args[1:], os.Getenv)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
 }
 
-func run(log *logger.Logger) error {
-	db, err := database.New()
+// runCertCommand runs the cert subcommands, "cert generate" writes a self-signed certificate for development.
+func runCertCommand(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return fmt.Errorf("unknown cert command, usage: %s cert generate [flags]", name)
+	}
+	fs := flag.NewFlagSet(name+" cert generate", flag.ContinueOnError)
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma separated host names and IP addresses of the certificate")
+	certFile := fs.String("cert", "cert.pem", "certificate output `file`")
+	keyFile := fs.String("key", "key.pem", "key output `file`")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "validity `duration` of the certificate")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := certs.GenerateSelfSigned(strings.Split(*hosts, ","), time.Now(), *validFor)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("wrote self-signed certificate %s and key %s for %s\n", *certFile, *keyFile, *hosts)
+	return nil
+}
+
+// newTLSConfig returns the TLS configuration of the server with a certificate that is reloaded when its files
+// change. With a client CA file, client certificates are verified if they are presented.
+func newTLSConfig(log *logger.Logger, cfg config.TLS) (*tls.Config, *certs.Reloader, error) {
+	reloader, err := certs.NewReloader(log, cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		if tlsConfig.ClientCAs, err = certs.LoadCertPool(cfg.ClientCAFile); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, reloader, nil
+}
+
+// toggleDebugOnHangup switches the log level between debug and the configured level on every SIGHUP.
+func toggleDebugOnHangup(log *logger.Logger, level logger.LogLevel) func() {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			log.Infof("received SIGHUP, log level: %s", log.ToggleDebug(level))
+		}
+	}()
+	return func() {
+		signal.Stop(hupCh)
+		close(hupCh)
+	}
+}
+
+// newTracer returns a tracer for the configured OTLP endpoint or traces file and a function that flushes the
+// exporter. Without an exporter trace IDs are only propagated.
+func newTracer(cfg config.Tracing) (*tracing.Tracer, func(ctx context.Context) error, error) {
+	if cfg.OTLPEndpoint != "" {
+		tracer := tracing.NewTracer(tracing.NewOTLPExporter(cfg.OTLPEndpoint, cfg.ServiceName))
+		return tracer, tracer.Shutdown, nil
+	}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		tracer := tracing.NewTracer(tracing.NewFileExporter(f))
+		return tracer, func(ctx context.Context) error {
+			if err := tracer.Shutdown(ctx); err != nil {
+				_ = f.Close()
+				return err
+			}
+			return f.Close()
+		}, nil
+	}
+	tracer := tracing.NewTracer(nil)
+	return tracer, tracer.Shutdown, nil
+}
+
+func run(log *logger.Logger, cfg *config.Config) error {
+	tracer, shutdownTracer, err := newTracer(cfg.Tracing)
 	if err != nil {
 		return err
 	}
-	err = seeder.Seed(db, 1000)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracer(ctx); err != nil {
+			log.Errorf("could not export traces: %v", err)
+		}
+	}()
+
+	db, err := database.New()
 	if err != nil {
 		return err
 	}
 
 	s := service.New(log, db)
-	s.Auth["user"] = "pw"
+	for user, password := range cfg.Users {
+		s.Auth[user] = password
+	}
+	for user, password := range cfg.Admins {
+		s.AdminAuth[user] = password
+	}
+	s.Tracer = tracer
+	s.AccessLogSampleRate = cfg.AccessLogSampleRate
+	s.PaymentTimeout = time.Duration(cfg.Timeouts.Payment)
+	s.EventStreamTimeout = time.Duration(cfg.Timeouts.EventStream)
+	s.SearchLimiter.SetRate(cfg.RateLimits.Search)
+	s.BookingLimiter.SetRate(cfg.RateLimits.Booking)
+	s.TrustedProxies = cfg.TrustedProxies
+	if cfg.ExchangeRatesFile != "" {
+		rates, err := money.LoadRates(cfg.ExchangeRatesFile)
+		if err != nil {
+			return err
+		}
+		s.Rates = rates
+	}
 
+	var handler http.Handler = s
+	if cfg.H2C {
+		handler = h2c.NewHandler(s, &http2.Server{})
+	}
 	srv := &http.Server{
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		Addr:         getBindAddress(),
-		Handler:      s,
+		ReadTimeout:  time.Duration(cfg.Timeouts.Read),
+		WriteTimeout: time.Duration(cfg.Timeouts.Write),
+		IdleTimeout:  time.Duration(cfg.Timeouts.Idle),
+		Addr:         cfg.BindAddress,
+		Handler:      handler,
+	}
+	srv.RegisterOnShutdown(s.CloseStreams)
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, reloader, err := newTLSConfig(log, cfg.TLS)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+		s.RequireAdminClientCert = tlsConfig.ClientCAs != nil
+		go reloader.Run(jobsCtx, 10*time.Second)
 	}
 
+	// the server answers the health checks while the database is seeded, the service is ready afterwards
+	seedErrCh := make(chan error, 1)
+	go func() {
+		log.Infof("seeding %d flights...", cfg.Seed.Flights)
+		if sErr := seeder.SeedWithSize(db, cfg.Seed.Flights, cfg.Seed.SeatRows); sErr != nil {
+			seedErrCh <- sErr
+			return
+		}
+		s.SetSeeded()
+		log.Info("seeding finished")
+
+		go s.Run(jobsCtx)
+		go simulator.New(log, db, clock.Real(), cfg.SimulationSeed).Run(jobsCtx, 10*time.Second)
+		webhookWorker := webhook.NewWorker(log, db, clock.Real())
+		webhookWorker.Tracer = tracer
+		go webhookWorker.Run(jobsCtx, time.Second)
+	}()
+
 	listenErrCh := make(chan error)
 	go func() {
-		log.Infof("listening on %s", srv.Addr)
-		sErr := srv.ListenAndServe()
+		var sErr error
+		if srv.TLSConfig != nil {
+			log.Infof("listening on %s with TLS", srv.Addr)
+			// the certificate is served by the TLS config
+			sErr = srv.ListenAndServeTLS("", "")
+		} else {
+			log.Infof("listening on %s", srv.Addr)
+			sErr = srv.ListenAndServe()
+		}
 		if !errors.Is(sErr, http.ErrServerClosed) {
 			listenErrCh <- sErr
 		}
 		close(listenErrCh)
 	}()
 
+	// the seed and listen errors are returned after the shutdown, so that the process exits with an error
+	var runErr error
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	select {
 	case <-ctx.Done():
 		log.Info("shutting down...")
-	case err = <-listenErrCh:
-		log.Errorf("error listening on %s: %s", srv.Addr, err)
+	case runErr = <-listenErrCh:
+		log.Errorf("error listening on %s: %s", srv.Addr, runErr)
+	case runErr = <-seedErrCh:
+		log.Errorf("could not seed database: %s", runErr)
 	}
 	stop()
 
+	// readiness fails before the server stops, so that no new requests are routed to it
+	s.StartShutdown()
+	if delay := time.Duration(cfg.Timeouts.ShutdownDelay); delay > 0 {
+		log.Infof("waiting %s for traffic to drain...", delay)
+		<-time.After(delay)
+	}
+
 	log.Info("stopping server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
 	defer cancel()
 
 	if err = srv.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
@@ -103,5 +310,8 @@ func run(log *logger.Logger) error {
 	}
 
 	log.Info("closing database...")
-	return db.Close()
+	if err = db.Close(); runErr != nil {
+		return runErr
+	}
+	return err
 }
@@ -38,6 +38,16 @@ function selectRandomUniqueElements (data, count) {
   return []
 }
 
+function passenger (i) {
+  return {
+    firstName: `Passenger${i}`,
+    lastName: 'Test',
+    dateOfBirth: '1990-01-01',
+    type: 'adult',
+    email: `passenger${i}@example.com`
+  }
+}
+
 export function searchFlights () {
   const endpoint = `http://${__ENV.target}`
   const destinationRes = http.get(http.url`${endpoint}/destinations`)
@@ -62,24 +72,33 @@ export function searchAndBookFlight () {
 
   const bookingRequest = {
     flightId: randomFlight.id,
-    passengers: []
+    passengers: [],
+    paymentToken: 'tok_k6'
   }
   const seatsRes = http.get(http.url`${endpoint}/flights/${randomFlight.id}/seats`)
   if (seatsRes.status === 200) {
     const seats = JSON.parse(seatsRes.body)
     bookingRequest.passengers = selectRandomUniqueElements(seats, 2)
-      .map((v, i) => ({ name: `Passenger ${i}`, seat: v.seat }))
+      .map((v, i) => ({ ...passenger(i), seat: v.seat }))
   } else {
     // no seats available, create a booking request that will fail
-    bookingRequest.passengers = [{ name: 'Passenger', seat: 'XX' }]
+    bookingRequest.passengers = [{ ...passenger(0), seat: 'XX' }]
   }
 
   sleep(Math.floor(Math.random() * 3))
-  const res = http.post(http.url`${endpoint}/bookings`, JSON.stringify(bookingRequest), {
-    headers: { Authorization: `Basic ${b64encode('user:pw')}` },
+  const headers = { Authorization: `Basic ${b64encode('user:pw')}` }
+  const res = http.post(http.url`${endpoint}/bookings`, JSON.stringify(bookingRequest), { headers })
+  const booked = check(res, {
+    'successful booking': (r) => r.status === 200
+  })
+  if (!booked) return
+
+  const booking = JSON.parse(res.body)
+  const confirmRes = http.post(http.url`${endpoint}/bookings/${booking.id}/confirm`, null, {
+    headers,
     responseType: 'none'
   })
-  check(res, {
-    'successful booking': (r) => r.status === 200
+  check(confirmRes, {
+    'successful payment': (r) => r.status === 200
   })
 }
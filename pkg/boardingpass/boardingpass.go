@@ -0,0 +1,144 @@
+package boardingpass
+
+import (
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode"
+)
+
+// Carrier is the IATA designator of the operating carrier of all flights.
+const Carrier = "FB"
+
+type BoardingPass struct {
+	FirstName    string    `json:"firstName"`
+	LastName     string    `json:"lastName"`
+	Reference    string    `json:"reference"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Carrier      string    `json:"carrier"`
+	FlightNumber string    `json:"flightNumber"`
+	Departure    time.Time `json:"departure"`
+	Compartment  string    `json:"compartment"`
+	Seat         string    `json:"seat"`
+	Sequence     int       `json:"sequence"`
+	Group        string    `json:"group"`
+	Barcode      string    `json:"barcode"`
+}
+
+// FlightNumber derives a stable four digit flight number from the flight id.
+func FlightNumber(flightID string) string {
+	return fmt.Sprintf("%04d", crc32.ChecksumIEEE([]byte(flightID))%9000+1000)
+}
+
+func fixed(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s + strings.Repeat(" ", length-len(s))
+}
+
+// asciiUpper removes all characters that cannot be encoded in a BCBP name field.
+func asciiUpper(s string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if (r >= 'A' && r <= 'Z') || r == ' ' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// bcbpSeat formats a seat like 7C as 007C.
+func bcbpSeat(seat string) string {
+	i := strings.IndexFunc(seat, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i <= 0 {
+		return fixed(seat, 4)
+	}
+	return fmt.Sprintf("%03s%s", seat[:i], fixed(seat[i:], 1))
+}
+
+// BCBP encodes the mandatory items of a single leg IATA Bar Coded Boarding Pass (Resolution 792, format M).
+func (bp *BoardingPass) BCBP() string {
+	var sb strings.Builder
+	sb.WriteString("M1")
+	sb.WriteString(fixed(asciiUpper(bp.LastName)+"/"+asciiUpper(bp.FirstName), 20))
+	sb.WriteString("E")
+	sb.WriteString(fixed(bp.Reference, 7))
+	sb.WriteString(fixed(bp.From, 3))
+	sb.WriteString(fixed(bp.To, 3))
+	sb.WriteString(fixed(bp.Carrier, 3))
+	sb.WriteString(fixed(bp.FlightNumber, 5))
+	sb.WriteString(fmt.Sprintf("%03d", bp.Departure.UTC().YearDay()))
+	sb.WriteString(fixed(bp.Compartment, 1))
+	sb.WriteString(bcbpSeat(bp.Seat))
+	sb.WriteString(fixed(fmt.Sprintf("%04d", bp.Sequence), 5))
+	// passenger status 1: checked in
+	sb.WriteString("1")
+	// no conditional items
+	sb.WriteString("00")
+	return sb.String()
+}
+
+const textTemplate = `BOARDING PASS
+Passenger:  {{.LastName}}/{{.FirstName}}
+Booking:    {{.Reference}}
+Flight:     {{.Carrier}}{{.FlightNumber}}
+From:       {{.From}}
+To:         {{.To}}
+Departure:  {{.Departure.UTC.Format "02 Jan 2006 15:04"}} UTC
+Seat:       {{.Seat}}
+Group:      {{.Group}}
+Sequence:   {{printf "%03d" .Sequence}}
+{{.Barcode}}
+`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Boarding Pass {{.Carrier}}{{.FlightNumber}}</title>
+<style>
+body { font-family: monospace; }
+.pass { border: 2px solid #000; padding: 1em; width: 40em; }
+.barcode { font-size: 0.8em; word-break: break-all; border-top: 1px dashed #000; padding-top: 1em; }
+</style>
+</head>
+<body>
+<div class="pass">
+<h1>Boarding Pass</h1>
+<table>
+<tr><th>Passenger</th><td>{{.LastName}}/{{.FirstName}}</td></tr>
+<tr><th>Booking</th><td>{{.Reference}}</td></tr>
+<tr><th>Flight</th><td>{{.Carrier}}{{.FlightNumber}}</td></tr>
+<tr><th>From</th><td>{{.From}}</td></tr>
+<tr><th>To</th><td>{{.To}}</td></tr>
+<tr><th>Departure</th><td>{{.Departure.UTC.Format "02 Jan 2006 15:04"}} UTC</td></tr>
+<tr><th>Seat</th><td>{{.Seat}}</td></tr>
+<tr><th>Group</th><td>{{.Group}}</td></tr>
+<tr><th>Sequence</th><td>{{printf "%03d" .Sequence}}</td></tr>
+</table>
+<pre class="barcode">{{.Barcode}}</pre>
+</div>
+</body>
+</html>
+`
+
+var (
+	textTmpl = texttemplate.Must(texttemplate.New("text").Parse(textTemplate))
+	htmlTmpl = template.Must(template.New("html").Parse(htmlTemplate))
+)
+
+// WriteText writes a plain text rendering of the boarding pass.
+func (bp *BoardingPass) WriteText(w io.Writer) error {
+	return textTmpl.Execute(w, bp)
+}
+
+// WriteHTML writes a printable HTML rendering of the boarding pass.
+func (bp *BoardingPass) WriteHTML(w io.Writer) error {
+	return htmlTmpl.Execute(w, bp)
+}
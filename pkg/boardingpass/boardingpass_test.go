@@ -0,0 +1,56 @@
+package boardingpass
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBoardingPass() *BoardingPass {
+	bp := &BoardingPass{
+		FirstName:    "John",
+		LastName:     "Doe",
+		Reference:    "K7QW2M",
+		From:         "TXL",
+		To:           "JFK",
+		Carrier:      Carrier,
+		FlightNumber: "1234",
+		Departure:    time.Date(2022, 7, 5, 10, 30, 0, 0, time.UTC),
+		Compartment:  "Y",
+		Seat:         "7C",
+		Sequence:     25,
+		Group:        "3",
+	}
+	bp.Barcode = bp.BCBP()
+	return bp
+}
+
+func TestBCBP(t *testing.T) {
+	bp := testBoardingPass()
+	require.Equal(t, "M1DOE/JOHN            EK7QW2M TXLJFKFB 1234 186Y007C0025 100", bp.Barcode)
+	require.Len(t, bp.Barcode, 60)
+
+	bp.LastName = "Müller-Lüdenscheidt"
+	bp.Seat = "12A"
+	barcode := bp.BCBP()
+	require.Len(t, barcode, 60)
+	require.Equal(t, "M1MLLER-LDENSCHEIDT/JOE", barcode[:23])
+	require.Equal(t, "012A", barcode[48:52])
+}
+
+func TestRender(t *testing.T) {
+	bp := testBoardingPass()
+	bp.FirstName = "<b>John</b>"
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, bp.WriteText(buf))
+	require.Contains(t, buf.String(), "Flight:     FB1234")
+	require.Contains(t, buf.String(), "<b>John</b>")
+
+	buf.Reset()
+	require.NoError(t, bp.WriteHTML(buf))
+	require.Contains(t, buf.String(), "&lt;b&gt;John&lt;/b&gt;")
+	require.Contains(t, buf.String(), bp.Barcode)
+}
@@ -0,0 +1,124 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+)
+
+// Reloader serves the certificate of a certificate and key file and reloads it when one of the files changes.
+type Reloader struct {
+	log      *logger.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate of the files, it fails if they cannot be loaded.
+func NewReloader(log *logger.Logger, certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		log:      log,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the latest modification time of the certificate and key file.
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload loads the certificate of the files. The previous certificate is kept if the files are invalid.
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// Check reloads the certificate if one of the files was modified since it was loaded.
+func (r *Reloader) Check() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	if err := r.Reload(); err != nil {
+		return err
+	}
+	r.log.Infof("reloaded certificate %s", r.certFile)
+	return nil
+}
+
+// GetCertificate returns the current certificate, it is used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Run checks the files for changes in every interval until the context is cancelled.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Check(); err != nil {
+				r.log.Errorf("could not reload certificate %s: %v", r.certFile, err)
+			}
+		}
+	}
+}
+
+// LoadCertPool returns a pool of the PEM encoded certificates of the file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + file)
+	}
+	return pool, nil
+}
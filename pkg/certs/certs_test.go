@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, certFile, keyFile string, modTime time.Time, hosts ...string) {
+	certPEM, keyPEM, err := GenerateSelfSigned(hosts, time.Now(), time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func leaf(t *testing.T, cert *tls.Certificate) *x509.Certificate {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestGenerateSelfSigned(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM, err := GenerateSelfSigned([]string{"localhost", "127.0.0.1"}, now, 24*time.Hour)
+	require.NoError(t, err)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	parsed := leaf(t, &cert)
+	require.Equal(t, "localhost", parsed.Subject.CommonName)
+	require.Equal(t, []string{"localhost"}, parsed.DNSNames)
+	require.True(t, parsed.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+	require.WithinDuration(t, now.Add(24*time.Hour), parsed.NotAfter, time.Second)
+
+	// the certificate is its own CA for server and client authentication
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err = parsed.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}})
+		require.NoError(t, err)
+	}
+
+	_, _, err = GenerateSelfSigned(nil, now, time.Hour)
+	require.Error(t, err)
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	_, err := NewReloader(logger.NewNop(), certFile, keyFile)
+	require.Error(t, err)
+
+	modTime := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, modTime, "first.localhost")
+	r, err := NewReloader(logger.NewNop(), certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "first.localhost", leaf(t, cert).Subject.CommonName)
+
+	// unchanged files are not reloaded
+	require.NoError(t, r.Check())
+	unchanged, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Same(t, cert, unchanged)
+
+	writeCert(t, certFile, keyFile, modTime.Add(time.Minute), "second.localhost")
+	require.NoError(t, r.Check())
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "second.localhost", leaf(t, cert).Subject.CommonName)
+
+	// an invalid key keeps the previous certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0o600))
+	require.Error(t, r.Check())
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, "second.localhost", leaf(t, cert).Subject.CommonName)
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	writeCert(t, certFile, filepath.Join(dir, "key.pem"), time.Now(), "localhost")
+	pool, err := LoadCertPool(certFile)
+	require.NoError(t, err)
+	require.NotNil(t, pool)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0o644))
+	_, err = LoadCertPool(certFile)
+	require.ErrorContains(t, err, "no certificates found")
+}
@@ -0,0 +1,24 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2022, time.July, 5, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	require.Equal(t, start, c.Now())
+	require.Equal(t, start.Add(time.Hour), c.Advance(time.Hour))
+	require.Equal(t, start.Add(time.Hour), c.Now())
+	c.Set(start)
+	require.Equal(t, start, c.Now())
+}
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	now := Real().Now()
+	require.False(t, now.Before(before))
+}
@@ -0,0 +1,490 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that is written as a string like "10s" in configuration files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Credentials map basic auth user names to their passwords. On the command-line and in environment variables
+// they are written as comma separated user:password pairs.
+type Credentials map[string]string
+
+func parseCredentials(value string) (Credentials, error) {
+	credentials := make(Credentials)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		user, password, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("missing password of user %q, expected user:password", user)
+		}
+		credentials[user] = password
+	}
+	return credentials, nil
+}
+
+// credentialsValue is the flag.Value of credentials, setting it replaces all credentials.
+type credentialsValue struct {
+	credentials *Credentials
+}
+
+// String only returns the user names, so the passwords are not shown in the usage.
+func (v credentialsValue) String() string {
+	if v.credentials == nil {
+		return ""
+	}
+	users := make([]string, 0, len(*v.credentials))
+	for user := range *v.credentials {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return strings.Join(users, ",")
+}
+
+func (v credentialsValue) Set(value string) error {
+	credentials, err := parseCredentials(value)
+	if err != nil {
+		return err
+	}
+	*v.credentials = credentials
+	return nil
+}
+
+// parsePrefixes parses comma separated IP address ranges in CIDR notation, single addresses are accepted as well.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(part); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesValue is the flag.Value of IP address ranges, setting it replaces all ranges.
+type prefixesValue struct {
+	prefixes *[]netip.Prefix
+}
+
+func (v prefixesValue) String() string {
+	if v.prefixes == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*v.prefixes))
+	for _, prefix := range *v.prefixes {
+		parts = append(parts, prefix.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v prefixesValue) Set(value string) error {
+	prefixes, err := parsePrefixes(value)
+	if err != nil {
+		return err
+	}
+	*v.prefixes = prefixes
+	return nil
+}
+
+type Seed struct {
+	// Flights is the number of generated flights, SeatRows the number of seat rows per flight.
+	Flights  int `json:"flights" yaml:"flights"`
+	SeatRows int `json:"seatRows" yaml:"seatRows"`
+}
+
+type Timeouts struct {
+	// Read, Write and Idle are the timeouts of the HTTP server.
+	Read  Duration `json:"read" yaml:"read"`
+	Write Duration `json:"write" yaml:"write"`
+	Idle  Duration `json:"idle" yaml:"idle"`
+	// EventStream is the maximum duration of an event stream, it must be below the write timeout.
+	EventStream Duration `json:"eventStream" yaml:"eventStream"`
+	// Payment is the time a booking waits for payment before its seats are released.
+	Payment Duration `json:"payment" yaml:"payment"`
+	// ShutdownDelay is the time between failing the readiness check and stopping the server, so that load
+	// balancers stop routing new requests to it. Shutdown is the time pending requests have to finish.
+	ShutdownDelay Duration `json:"shutdownDelay" yaml:"shutdownDelay"`
+	Shutdown      Duration `json:"shutdown" yaml:"shutdown"`
+}
+
+type Tracing struct {
+	// OTLPEndpoint is the OTLP/HTTP endpoint the spans are exported to, File the file the spans are
+	// appended to. Without both trace IDs are only propagated.
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty" yaml:"otlpEndpoint,omitempty"`
+	ServiceName  string `json:"serviceName" yaml:"serviceName"`
+	File         string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+type TLS struct {
+	// CertFile and KeyFile enable HTTPS, the certificate is reloaded when one of the files changes.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// ClientCAFile enables mutual TLS for the admin routes, admins have to present a client certificate
+	// signed by one of the CAs of the file.
+	ClientCAFile string `json:"clientCAFile,omitempty" yaml:"clientCAFile,omitempty"`
+}
+
+type RateLimits struct {
+	// Search limits the flight and destination searches per client IP address, Booking the authenticated
+	// booking routes per user. A rate of 0 disables the limit.
+	Search  ratelimit.Rate `json:"search" yaml:"search"`
+	Booking ratelimit.Rate `json:"booking" yaml:"booking"`
+}
+
+// Config is the configuration of the server. It is built from the defaults, a YAML or JSON file, environment
+// variables and command-line flags, every source overrides the values of the previous ones.
+type Config struct {
+	LogLevel    logger.LogLevel `json:"logLevel" yaml:"logLevel"`
+	BindAddress string          `json:"bindAddress" yaml:"bindAddress"`
+	Seed        Seed            `json:"seed" yaml:"seed"`
+	Timeouts    Timeouts        `json:"timeouts" yaml:"timeouts"`
+	// Users can book flights, Admins can use the admin routes.
+	Users  Credentials `json:"users" yaml:"users"`
+	Admins Credentials `json:"admins" yaml:"admins"`
+	// AccessLogSampleRate is the fraction of successful requests that are logged.
+	AccessLogSampleRate float64 `json:"accessLogSampleRate" yaml:"accessLogSampleRate"`
+	ExchangeRatesFile   string  `json:"exchangeRatesFile,omitempty" yaml:"exchangeRatesFile,omitempty"`
+	SimulationSeed      int64   `json:"simulationSeed" yaml:"simulationSeed"`
+	Tracing             Tracing `json:"tracing" yaml:"tracing"`
+	TLS                 TLS     `json:"tls" yaml:"tls"`
+	// H2C enables HTTP/2 without TLS, e.g. behind a proxy in an internal network.
+	H2C        bool       `json:"h2c" yaml:"h2c"`
+	RateLimits RateLimits `json:"rateLimits" yaml:"rateLimits"`
+	// TrustedProxies are the IP address ranges of the proxies whose forwarded headers set the client IP address.
+	TrustedProxies []netip.Prefix `json:"trustedProxies,omitempty" yaml:"trustedProxies,omitempty"`
+}
+
+func Default() *Config {
+	return &Config{
+		LogLevel:    logger.DebugLevel,
+		BindAddress: "127.0.0.1:3000",
+		Seed: Seed{
+			Flights:  1000,
+			SeatRows: 29,
+		},
+		Timeouts: Timeouts{
+			Read:        Duration(10 * time.Second),
+			Write:       Duration(60 * time.Second),
+			Idle:        Duration(60 * time.Second),
+			EventStream: Duration(50 * time.Second),
+			Payment:     Duration(15 * time.Minute),
+			Shutdown:    Duration(5 * time.Second),
+		},
+		Users:               Credentials{"user": "pw"},
+		Admins:              Credentials{"admin": "admin-pw"},
+		AccessLogSampleRate: 1,
+		SimulationSeed:      1,
+		Tracing: Tracing{
+			ServiceName: "flight-booking-service",
+		},
+		RateLimits: RateLimits{
+			Search:  ratelimit.Rate{Limit: 600, Window: time.Minute},
+			Booking: ratelimit.Rate{Limit: 120, Window: time.Minute},
+		},
+	}
+}
+
+// envVars maps the environment variables to the flags they set.
+var envVars = []struct {
+	env  string
+	flag string
+}{
+	{"CONFIG_FILE", "config"},
+	{"LOG_LEVEL", "log-level"},
+	{"BIND_ADDRESS", "bind-address"},
+	{"TLS_CERT_FILE", "tls-cert-file"},
+	{"TLS_KEY_FILE", "tls-key-file"},
+	{"TLS_CLIENT_CA_FILE", "tls-client-ca-file"},
+	{"H2C", "h2c"},
+	{"SEARCH_RATE_LIMIT", "search-rate-limit"},
+	{"BOOKING_RATE_LIMIT", "booking-rate-limit"},
+	{"TRUSTED_PROXIES", "trusted-proxies"},
+	{"SEED_FLIGHTS", "seed-flights"},
+	{"SEED_SEAT_ROWS", "seed-seat-rows"},
+	{"READ_TIMEOUT", "read-timeout"},
+	{"WRITE_TIMEOUT", "write-timeout"},
+	{"IDLE_TIMEOUT", "idle-timeout"},
+	{"EVENT_STREAM_TIMEOUT", "event-stream-timeout"},
+	{"PAYMENT_TIMEOUT", "payment-timeout"},
+	{"SHUTDOWN_DELAY", "shutdown-delay"},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout"},
+	{"AUTH_USERS", "users"},
+	{"ADMIN_USERS", "admins"},
+	{"ACCESS_LOG_SAMPLE_RATE", "access-log-sample-rate"},
+	{"EXCHANGE_RATES_FILE", "exchange-rates-file"},
+	{"SIMULATION_SEED", "simulation-seed"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "otlp-endpoint"},
+	{"OTEL_SERVICE_NAME", "service-name"},
+	{"TRACES_FILE", "traces-file"},
+}
+
+// FlagSet returns the flags of the configuration, parsing them sets the fields of c.
+func (c *Config) FlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("config", "", "YAML or JSON configuration `file`")
+	fs.TextVar(&c.LogLevel, "log-level", c.LogLevel, "log `level`: debug, info, warn or error")
+	fs.StringVar(&c.BindAddress, "bind-address", c.BindAddress, "`address` the server listens on")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert-file", c.TLS.CertFile, "PEM encoded TLS certificate `file`")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key-file", c.TLS.KeyFile, "PEM encoded TLS key `file`")
+	fs.StringVar(&c.TLS.ClientCAFile, "tls-client-ca-file", c.TLS.ClientCAFile, "PEM encoded CA `file` of the client certificates required for the admin routes")
+	fs.BoolVar(&c.H2C, "h2c", c.H2C, "serve HTTP/2 without TLS")
+	fs.TextVar(&c.RateLimits.Search, "search-rate-limit", c.RateLimits.Search, "`rate` of the searches per client IP address, e.g. 600/1m, 0 disables the limit")
+	fs.TextVar(&c.RateLimits.Booking, "booking-rate-limit", c.RateLimits.Booking, "`rate` of the booking requests per user, e.g. 120/1m, 0 disables the limit")
+	fs.Var(prefixesValue{&c.TrustedProxies}, "trusted-proxies", "comma separated IP address `ranges` of the proxies whose forwarded headers are trusted")
+	fs.IntVar(&c.Seed.Flights, "seed-flights", c.Seed.Flights, "number of generated flights")
+	fs.IntVar(&c.Seed.SeatRows, "seed-seat-rows", c.Seed.SeatRows, "number of seat `rows` per generated flight")
+	fs.TextVar(&c.Timeouts.Read, "read-timeout", c.Timeouts.Read, "read `timeout` of the server")
+	fs.TextVar(&c.Timeouts.Write, "write-timeout", c.Timeouts.Write, "write `timeout` of the server")
+	fs.TextVar(&c.Timeouts.Idle, "idle-timeout", c.Timeouts.Idle, "idle `timeout` of the server")
+	fs.TextVar(&c.Timeouts.EventStream, "event-stream-timeout", c.Timeouts.EventStream, "maximum `duration` of an event stream")
+	fs.TextVar(&c.Timeouts.Payment, "payment-timeout", c.Timeouts.Payment, "`duration` a booking waits for payment")
+	fs.TextVar(&c.Timeouts.ShutdownDelay, "shutdown-delay", c.Timeouts.ShutdownDelay, "`duration` between failing the readiness check and stopping the server")
+	fs.TextVar(&c.Timeouts.Shutdown, "shutdown-timeout", c.Timeouts.Shutdown, "`duration` pending requests have to finish on shutdown")
+	fs.Var(credentialsValue{&c.Users}, "users", "comma separated `user:password` pairs of the users")
+	fs.Var(credentialsValue{&c.Admins}, "admins", "comma separated `user:password` pairs of the admins")
+	fs.Float64Var(&c.AccessLogSampleRate, "access-log-sample-rate", c.AccessLogSampleRate, "fraction of successful requests that are logged")
+	fs.StringVar(&c.ExchangeRatesFile, "exchange-rates-file", c.ExchangeRatesFile, "JSON `file` with the exchange rates")
+	fs.Int64Var(&c.SimulationSeed, "simulation-seed", c.SimulationSeed, "seed of the flight status simulation")
+	fs.StringVar(&c.Tracing.OTLPEndpoint, "otlp-endpoint", c.Tracing.OTLPEndpoint, "OTLP/HTTP `endpoint` the spans are exported to")
+	fs.StringVar(&c.Tracing.ServiceName, "service-name", c.Tracing.ServiceName, "service `name` of the exported spans")
+	fs.StringVar(&c.Tracing.File, "traces-file", c.Tracing.File, "`file` the spans are appended to as JSON lines")
+	for _, v := range envVars {
+		f := fs.Lookup(v.flag)
+		f.Usage = fmt.Sprintf("%s (env %s)", f.Usage, v.env)
+	}
+	return fs
+}
+
+// applyEnv sets the flags of the environment variables.
+func applyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	// PORT is used by many hosting platforms and listens on all interfaces
+	if port := getenv("PORT"); port != "" && getenv("BIND_ADDRESS") == "" {
+		if err := fs.Set("bind-address", ":"+port); err != nil {
+			return err
+		}
+	}
+	for _, v := range envVars {
+		value := getenv(v.env)
+		if value == "" {
+			continue
+		}
+		if err := fs.Set(v.flag, value); err != nil {
+			return fmt.Errorf("invalid value %q for environment variable %s: %w", value, v.env, err)
+		}
+	}
+	return nil
+}
+
+// Load returns the validated configuration of the arguments and environment variables. The configuration
+// file is read from the flag -config or the environment variable CONFIG_FILE.
+func Load(name string, args []string, getenv func(string) string) (*Config, error) {
+	// the first pass only finds the configuration file, the flags are applied again on top of the file
+	fs := Default().FlagSet(name)
+	if err := applyEnv(fs, getenv); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := Default()
+	if file := fs.Lookup("config").Value.String(); file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	fs = cfg.FlagSet(name)
+	if err := applyEnv(fs, getenv); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overrides the configuration with the values of the YAML or JSON file. Unknown fields are rejected.
+func (c *Config) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// credentials of the file replace the defaults instead of being merged
+	users, admins := c.Users, c.Admins
+	c.Users, c.Admins = nil, nil
+	switch ext := filepath.Ext(file); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("unsupported configuration file extension %q, expected .yaml, .yml or .json", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read configuration file %s: %w", file, err)
+	}
+	if c.Users == nil {
+		c.Users = users
+	}
+	if c.Admins == nil {
+		c.Admins = admins
+	}
+	return nil
+}
+
+func validateCredentials(v *validation, field string, credentials Credentials) {
+	for user, password := range credentials {
+		if user == "" || strings.ContainsAny(user, ":,") {
+			v.add(field, "invalid user name %q", user)
+		} else if password == "" {
+			v.add(field, "missing password of user %q", user)
+		}
+	}
+}
+
+// Validate checks all fields and reports every invalid one.
+func (c *Config) Validate() error {
+	v := &validation{}
+	if _, _, err := net.SplitHostPort(c.BindAddress); err != nil {
+		v.add("bindAddress", "%v", err)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		v.add("tls", "certFile and keyFile must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		v.add("tls.clientCAFile", "requires certFile and keyFile")
+	}
+	if c.H2C && c.TLS.CertFile != "" {
+		v.add("h2c", "cannot be used with TLS, HTTP/2 is negotiated over TLS")
+	}
+	if c.Seed.Flights < 0 {
+		v.add("seed.flights", "must not be negative")
+	}
+	if c.Seed.SeatRows < 1 {
+		v.add("seed.seatRows", "must be at least 1")
+	}
+	timeouts := []struct {
+		field string
+		value Duration
+	}{
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.eventStream", c.Timeouts.EventStream},
+		{"timeouts.payment", c.Timeouts.Payment},
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+	}
+	for _, t := range timeouts {
+		if t.value <= 0 {
+			v.add(t.field, "must be positive")
+		}
+	}
+	if c.Timeouts.ShutdownDelay < 0 {
+		v.add("timeouts.shutdownDelay", "must not be negative")
+	}
+	if c.Timeouts.EventStream >= c.Timeouts.Write {
+		v.add("timeouts.eventStream", "must be below the write timeout %s", c.Timeouts.Write)
+	}
+	validateCredentials(v, "users", c.Users)
+	validateCredentials(v, "admins", c.Admins)
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 1 {
+		v.add("accessLogSampleRate", "must be between 0 and 1")
+	}
+	if c.Tracing.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("tracing.otlpEndpoint", "must be an http or https URL")
+		}
+		if c.Tracing.File != "" {
+			v.add("tracing.file", "cannot be used together with tracing.otlpEndpoint")
+		}
+	}
+	if c.Tracing.ServiceName == "" {
+		v.add("tracing.serviceName", "must not be empty")
+	}
+	return v.err()
+}
+
+// Redacted returns a copy of the configuration without the passwords.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redact := func(credentials Credentials) Credentials {
+		res := make(Credentials, len(credentials))
+		for user := range credentials {
+			res[user] = "REDACTED"
+		}
+		return res
+	}
+	redacted.Users = redact(c.Users)
+	redacted.Admins = redact(c.Admins)
+	return &redacted
+}
+
+type validation struct {
+	errors []string
+}
+
+func (v *validation) add(field, format string, args ...any) {
+	v.errors = append(v.errors, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (v *validation) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	sort.Strings(v.errors)
+	return fmt.Errorf("invalid configuration: %s", strings.Join(v.errors, "; "))
+}
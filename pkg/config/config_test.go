@@ -0,0 +1,194 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	return file
+}
+
+func TestDefault(t *testing.T) {
+	cfg, err := Load("test", nil, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, Default(), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+logLevel: warn
+bindAddress: 0.0.0.0:4000
+seed:
+  flights: 10
+timeouts:
+  payment: 5m
+users:
+  alice: secret
+`)
+	cfg, err := Load("test", []string{"-config", file}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, logger.WarnLevel, cfg.LogLevel)
+	require.Equal(t, "0.0.0.0:4000", cfg.BindAddress)
+	require.Equal(t, Seed{Flights: 10, SeatRows: 29}, cfg.Seed)
+	require.Equal(t, Duration(5*time.Minute), cfg.Timeouts.Payment)
+	require.Equal(t, Duration(10*time.Second), cfg.Timeouts.Read)
+	// the users of the file replace the default user
+	require.Equal(t, Credentials{"alice": "secret"}, cfg.Users)
+	require.Equal(t, Credentials{"admin": "admin-pw"}, cfg.Admins)
+
+	// environment variables override the file, flags override environment variables
+	cfg, err = Load("test", []string{"-seed-flights", "30"}, env(map[string]string{
+		"CONFIG_FILE":  file,
+		"LOG_LEVEL":    "error",
+		"SEED_FLIGHTS": "20",
+		"AUTH_USERS":   "bob:pw1, carol:pw2",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, logger.ErrorLevel, cfg.LogLevel)
+	require.Equal(t, "0.0.0.0:4000", cfg.BindAddress)
+	require.Equal(t, 30, cfg.Seed.Flights)
+	require.Equal(t, Credentials{"bob": "pw1", "carol": "pw2"}, cfg.Users)
+}
+
+func TestLoadJSON(t *testing.T) {
+	file := writeFile(t, "config.json", `{"timeouts": {"write": "2m", "eventStream": "90s"}, "tracing": {"file": "traces.jsonl"}}`)
+	cfg, err := Load("test", []string{"-config", file}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, Duration(2*time.Minute), cfg.Timeouts.Write)
+	require.Equal(t, Duration(90*time.Second), cfg.Timeouts.EventStream)
+	require.Equal(t, "traces.jsonl", cfg.Tracing.File)
+}
+
+func TestLoadPort(t *testing.T) {
+	cfg, err := Load("test", nil, env(map[string]string{"PORT": "8080"}))
+	require.NoError(t, err)
+	require.Equal(t, ":8080", cfg.BindAddress)
+
+	cfg, err = Load("test", nil, env(map[string]string{"PORT": "8080", "BIND_ADDRESS": "127.0.0.1:9000"}))
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:9000", cfg.BindAddress)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load("test", nil, env(map[string]string{"LOG_LEVEL": "verbose"}))
+	require.ErrorContains(t, err, "environment variable LOG_LEVEL")
+
+	_, err = Load("test", []string{"-users", "alice"}, env(nil))
+	require.ErrorContains(t, err, `missing password of user "alice"`)
+
+	_, err = Load("test", []string{"serve"}, env(nil))
+	require.ErrorContains(t, err, "unexpected arguments: serve")
+
+	file := writeFile(t, "config.yaml", "seed:\n  flight: 10\n")
+	_, err = Load("test", []string{"-config", file}, env(nil))
+	require.ErrorContains(t, err, "field flight not found")
+
+	file = writeFile(t, "config.toml", "")
+	_, err = Load("test", []string{"-config", file}, env(nil))
+	require.ErrorContains(t, err, "unsupported configuration file extension")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.BindAddress = "localhost"
+	cfg.Seed.SeatRows = 0
+	cfg.Timeouts.EventStream = cfg.Timeouts.Write
+	cfg.Timeouts.Shutdown = 0
+	cfg.Users["bob"] = ""
+	cfg.AccessLogSampleRate = 2
+	cfg.Tracing.OTLPEndpoint = "localhost:4318"
+	cfg.Tracing.File = "traces.jsonl"
+	cfg.TLS.KeyFile = "key.pem"
+	cfg.TLS.ClientCAFile = "ca.pem"
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, msg := range []string{
+		"bindAddress: address localhost: missing port in address",
+		"seed.seatRows: must be at least 1",
+		"timeouts.eventStream: must be below the write timeout 1m0s",
+		"timeouts.shutdown: must be positive",
+		`users: missing password of user "bob"`,
+		"accessLogSampleRate: must be between 0 and 1",
+		"tracing.otlpEndpoint: must be an http or https URL",
+		"tracing.file: cannot be used together with tracing.otlpEndpoint",
+		"tls: certFile and keyFile must be set together",
+		"tls.clientCAFile: requires certFile and keyFile",
+	} {
+		require.ErrorContains(t, err, msg)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	redacted := cfg.Redacted()
+	require.Equal(t, Credentials{"user": "REDACTED"}, redacted.Users)
+	require.Equal(t, Credentials{"admin": "REDACTED"}, redacted.Admins)
+	require.Equal(t, Credentials{"user": "pw"}, cfg.Users)
+}
+
+func TestValidateTLS(t *testing.T) {
+	cfg, err := Load("test", []string{"-tls-cert-file", "cert.pem", "-tls-key-file", "key.pem", "-tls-client-ca-file", "ca.pem"}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, TLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}, cfg.TLS)
+
+	_, err = Load("test", []string{"-h2c"}, env(map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem"}))
+	require.ErrorContains(t, err, "h2c: cannot be used with TLS")
+
+	cfg, err = Load("test", nil, env(map[string]string{"H2C": "true"}))
+	require.NoError(t, err)
+	require.True(t, cfg.H2C)
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	cfg, err := Load("test", []string{"-booking-rate-limit", "10/1s"}, env(map[string]string{"SEARCH_RATE_LIMIT": "0"}))
+	require.NoError(t, err)
+	require.False(t, cfg.RateLimits.Search.Enabled())
+	require.Equal(t, ratelimit.Rate{Limit: 10, Window: time.Second}, cfg.RateLimits.Booking)
+
+	file := writeFile(t, "config.yaml", "rateLimits:\n  search: 100/10s\n")
+	cfg, err = Load("test", []string{"-config", file}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, ratelimit.Rate{Limit: 100, Window: 10 * time.Second}, cfg.RateLimits.Search)
+	require.Equal(t, Default().RateLimits.Booking, cfg.RateLimits.Booking)
+
+	_, err = Load("test", []string{"-search-rate-limit", "100"}, env(nil))
+	require.ErrorContains(t, err, "invalid rate")
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	cfg, err := Load("test", nil, env(nil))
+	require.NoError(t, err)
+	require.Empty(t, cfg.TrustedProxies)
+
+	cfg, err = Load("test", nil, env(map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, 192.168.1.1,fd00::1/64"}))
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("fd00::/64"),
+	}, cfg.TrustedProxies)
+
+	file := writeFile(t, "config.yaml", "trustedProxies:\n  - 172.16.0.0/12\n")
+	cfg, err = Load("test", []string{"-config", file}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")}, cfg.TrustedProxies)
+
+	_, err = Load("test", []string{"-trusted-proxies", "proxy"}, env(nil))
+	require.Error(t, err)
+}
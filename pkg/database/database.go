@@ -1,12 +1,15 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
 	"github.com/dgraph-io/badger/v3"
 )
 
@@ -20,8 +23,16 @@ type Model interface {
 	Key() string
 }
 
+// Observer is called after every operation of the database with its duration and result,
+// e.g. to record metrics.
+type Observer func(operation string, duration time.Duration, err error)
+
 type Database struct {
-	db *badger.DB
+	db       *badger.DB
+	feed     *feed
+	observer Observer
+	// ctx contains the span that the operations are traced in
+	ctx context.Context
 }
 
 func New() (*Database, error) {
@@ -34,10 +45,63 @@ func New() (*Database, error) {
 	}
 
 	return &Database{
-		db: db,
+		db:   db,
+		feed: newFeed(),
 	}, nil
 }
 
+// SetObserver sets the observer of all operations. It must be called before the database is used.
+func (db *Database) SetObserver(observer Observer) {
+	db.observer = observer
+}
+
+// WithContext returns a copy of the database whose operations are traced as children of the span in ctx.
+func (db *Database) WithContext(ctx context.Context) *Database {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+// Context returns the context of the database, e.g. to retrieve the logger of a request.
+func (db *Database) Context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// begin starts an operation and returns the database to run it with and the function that ends it
+// with its result. An empty collection is not recorded.
+func (db *Database) begin(operation, collection string) (*Database, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.StartChild(db.Context(), "database."+operation)
+	span.SetAttribute("db.operation", operation)
+	if collection != "" {
+		span.SetAttribute("db.collection", collection)
+	}
+	opDB := db
+	if span != nil {
+		opDB = db.WithContext(ctx)
+	}
+	return opDB, func(err error) {
+		span.SetError(err)
+		span.End()
+		if db.observer != nil {
+			db.observer(operation, time.Since(start), err)
+		}
+	}
+}
+
+// view runs fn inside a read-only Badger transaction.
+func (db *Database) view(fn func(txn *badger.Txn) error) error {
+	_, span := tracing.StartChild(db.Context(), "badger.txn")
+	defer span.End()
+	span.SetAttribute("db.txn.update", false)
+	err := db.db.View(fn)
+	span.SetError(err)
+	return err
+}
+
 func (db *Database) getPrefixedKey(collection, key string) []byte {
 	return []byte(fmt.Sprintf("%s/%s", collection, key))
 }
@@ -52,41 +116,52 @@ func (db *Database) toEntry(m Model) (*badger.Entry, error) {
 
 // Put one ore more models into the database.
 func (db *Database) Put(models ...Model) error {
-	return db.db.Update(func(txn *badger.Txn) error {
-		for _, m := range models {
-			e, err := db.toEntry(m)
-			if err != nil {
-				return err
-			}
-			if err := txn.SetEntry(e); err != nil {
-				return err
-			}
-		}
-		return nil
+	opDB, end := db.begin("put", "")
+	err := opDB.update(func(txn *Txn) error {
+		return txn.Put(models...)
+	})
+	end(err)
+	return err
+}
+
+// Delete removes one or more models from the database.
+func (db *Database) Delete(models ...Model) error {
+	opDB, end := db.begin("delete", "")
+	err := opDB.update(func(txn *Txn) error {
+		return txn.Delete(models...)
 	})
+	end(err)
+	return err
 }
 
 // Get retrieves a model from the database. If the model is not found, a bader.ErrKeyNotFound error is returned.
 func (db *Database) Get(key string, val Model) error {
-	err := db.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(db.getPrefixedKey(val.Collection(), key))
-		if err != nil {
-			return err
-		}
-		return item.Value(func(value []byte) error {
-			return json.Unmarshal(value, val)
-		})
+	opDB, end := db.begin("get", val.Collection())
+	err := opDB.view(func(txn *badger.Txn) error {
+		return db.get(txn, key, val)
 	})
+	end(err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (db *Database) get(txn *badger.Txn, key string, val Model) error {
+	item, err := txn.Get(db.getPrefixedKey(val.Collection(), key))
+	if err != nil {
+		return err
+	}
+	return item.Value(func(value []byte) error {
+		return json.Unmarshal(value, val)
+	})
+}
+
 // Get is the generic equivalent of Database.Get.
 func Get[T Model](db *Database, key string) (T, error) {
 	var val T
-	err := db.db.View(func(txn *badger.Txn) error {
+	opDB, end := db.begin("get", val.Collection())
+	err := opDB.view(func(txn *badger.Txn) error {
 		item, err := txn.Get(db.getPrefixedKey(val.Collection(), key))
 		if err != nil {
 			return err
@@ -95,6 +170,7 @@ func Get[T Model](db *Database, key string) (T, error) {
 			return json.Unmarshal(value, &val)
 		})
 	})
+	end(err)
 	if err != nil {
 		return val, err
 	}
@@ -104,7 +180,8 @@ func Get[T Model](db *Database, key string) (T, error) {
 // RawGet return the raw value of a key in JSON format.
 func (db *Database) RawGet(collection, key string) ([]byte, error) {
 	var val []byte
-	err := db.db.View(func(txn *badger.Txn) error {
+	opDB, end := db.begin("raw_get", collection)
+	err := opDB.view(func(txn *badger.Txn) error {
 		item, err := txn.Get(db.getPrefixedKey(collection, key))
 		if err != nil {
 			return err
@@ -115,6 +192,7 @@ func (db *Database) RawGet(collection, key string) ([]byte, error) {
 		}
 		return nil
 	})
+	end(err)
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +202,8 @@ func (db *Database) RawGet(collection, key string) ([]byte, error) {
 // Values returns an list of models with the same type as the forModel parameter.
 func (db *Database) Values(forModel Model, prefixes ...string) ([]Model, error) {
 	values := make([]Model, 0)
-	err := db.db.View(func(txn *badger.Txn) error {
+	opDB, end := db.begin("values", forModel.Collection())
+	err := opDB.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		prefix := db.getPrefixedKey(forModel.Collection(), strings.Join(prefixes, "/"))
@@ -144,6 +223,7 @@ func (db *Database) Values(forModel Model, prefixes ...string) ([]Model, error)
 		}
 		return nil
 	})
+	end(err)
 	if err != nil {
 		return nil, err
 	}
@@ -152,37 +232,49 @@ func (db *Database) Values(forModel Model, prefixes ...string) ([]Model, error)
 
 // Values is the generic equivalent of Database.Values.
 func Values[T Model](db *Database, prefixes ...string) ([]T, error) {
+	var values []T
+	var collectionType T
+	opDB, end := db.begin("values", collectionType.Collection())
+	err := opDB.view(func(txn *badger.Txn) error {
+		var err error
+		values, err = scanValues[T](db, txn, prefixes)
+		return err
+	})
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// scanValues decodes all values of the collection of T whose keys start with the prefixes.
+func scanValues[T Model](db *Database, txn *badger.Txn, prefixes []string) ([]T, error) {
 	values := make([]T, 0)
-	err := db.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		var collectionType T
-		prefix := db.getPrefixedKey(collectionType.Collection(), strings.Join(prefixes, "/"))
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				var modelVal T
-				if err := json.Unmarshal(val, &modelVal); err != nil {
-					return err
-				}
-				values = append(values, modelVal)
-				return nil
-			})
-			if err != nil {
+	var collectionType T
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	prefix := db.getPrefixedKey(collectionType.Collection(), strings.Join(prefixes, "/"))
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		err := item.Value(func(val []byte) error {
+			var modelVal T
+			if err := json.Unmarshal(val, &modelVal); err != nil {
 				return err
 			}
+			values = append(values, modelVal)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
 	return values, nil
 }
 
 // RawValues writes the raw database values of the prefixes to the provided writer.
 func (db *Database) RawValues(w io.Writer, prefixes ...string) error {
-	return db.db.View(func(txn *badger.Txn) error {
+	opDB, end := db.begin("raw_values", "")
+	err := opDB.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		_, err := w.Write([]byte("["))
@@ -210,6 +302,45 @@ func (db *Database) RawValues(w io.Writer, prefixes ...string) error {
 		_, err = w.Write([]byte("]"))
 		return err
 	})
+	end(err)
+	return err
+}
+
+// Stats describe the content of the database.
+type Stats struct {
+	// Size is the estimated size of all keys and values in bytes.
+	Size int64 `json:"size"`
+	// Keys is the number of keys per collection.
+	Keys map[string]int `json:"keys"`
+}
+
+// Stats counts the keys of all collections.
+func (db *Database) Stats() (*Stats, error) {
+	stats := &Stats{Keys: make(map[string]int)}
+	opDB, end := db.begin("stats", "")
+	err := opDB.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			collection, _, _ := strings.Cut(string(item.Key()), "/")
+			stats.Keys[collection]++
+			stats.Size += item.EstimatedSize()
+		}
+		return nil
+	})
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Closed reports whether the database was closed.
+func (db *Database) Closed() bool {
+	return db.db.IsClosed()
 }
 
 func (db *Database) Close() error {
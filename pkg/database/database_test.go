@@ -2,12 +2,16 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -60,6 +64,93 @@ func TestPutGet(t *testing.T) {
 	require.Equal(t, u, res2)
 }
 
+func TestUpdate(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	require.NoError(t, db.Put(&models.Flight{ID: "A"}, &models.Flight{ID: "B"}))
+	err = db.Update(func(txn *Txn) error {
+		if txnErr := txn.Put(&models.Flight{ID: "C"}); txnErr != nil {
+			return txnErr
+		}
+		if txnErr := txn.Delete(&models.Flight{ID: "A"}); txnErr != nil {
+			return txnErr
+		}
+		return fmt.Errorf("abort")
+	})
+	require.EqualError(t, err, "abort")
+	values, err := Values[*models.Flight](db)
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+
+	err = db.Update(func(txn *Txn) error {
+		var flight models.Flight
+		if txnErr := txn.Get("B", &flight); txnErr != nil {
+			return txnErr
+		}
+		flight.Status = "cancelled"
+		return txn.Put(&flight)
+	})
+	require.NoError(t, err)
+	flight, err := Get[*models.Flight](db, "B")
+	require.NoError(t, err)
+	require.Equal(t, "cancelled", flight.Status)
+}
+
+func TestDryRun(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	require.NoError(t, db.Put(&models.Flight{ID: "A"}))
+	seq := db.Seq()
+	err = db.DryRun(func(txn *Txn) error {
+		if txnErr := txn.Put(&models.Flight{ID: "B"}); txnErr != nil {
+			return txnErr
+		}
+		var flight models.Flight
+		// the changes are visible inside the transaction
+		return txn.Get("B", &flight)
+	})
+	require.NoError(t, err)
+	_, err = Get[*models.Flight](db, "B")
+	require.ErrorIs(t, err, badger.ErrKeyNotFound)
+	require.Equal(t, seq, db.Seq())
+}
+
+func TestTxnValues(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	for _, id := range []string{"1A", "1B"} {
+		require.NoError(t, db.Put(&models.Seat{FlightID: "1", Seat: id, Available: true}))
+	}
+	require.NoError(t, db.Put(&models.Seat{FlightID: "2", Seat: "1A"}))
+
+	err = db.Update(func(txn *Txn) error {
+		seats, txnErr := TxnValues[*models.Seat](txn, "1")
+		if txnErr != nil {
+			return txnErr
+		}
+		require.Len(t, seats, 2)
+		seats[0].Available = false
+		if txnErr := txn.Put(seats[0]); txnErr != nil {
+			return txnErr
+		}
+		// a concurrent write to another seat that was read conflicts with the transaction
+		return db.Put(&models.Seat{FlightID: "1", Seat: "1B"})
+	})
+	require.ErrorIs(t, err, badger.ErrConflict)
+}
+
 func TestValues(t *testing.T) {
 	db, err := New()
 	require.NoError(t, err)
@@ -217,3 +308,84 @@ func BenchmarkRawValues(b *testing.B) {
 		_ = db.RawValues(io.Discard, "flights")
 	}
 }
+
+func TestStats(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	require.Empty(t, stats.Keys)
+	require.Zero(t, stats.Size)
+
+	require.NoError(t, db.Put(
+		&models.Flight{ID: "A"},
+		&models.Flight{ID: "B"},
+		&models.Seat{FlightID: "A", Seat: "1A"},
+	))
+	stats, err = db.Stats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"flights": 2, "seats": 1}, stats.Keys)
+	require.Positive(t, stats.Size)
+
+	require.False(t, db.Closed())
+	require.NoError(t, db.Close())
+	require.True(t, db.Closed())
+}
+
+func TestObserver(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	var operations []string
+	var errs []error
+	db.SetObserver(func(operation string, _ time.Duration, err error) {
+		operations = append(operations, operation)
+		errs = append(errs, err)
+	})
+	require.NoError(t, db.Put(&models.Flight{ID: "A"}))
+	_, err = Get[*models.Flight](db, "B")
+	require.ErrorIs(t, err, badger.ErrKeyNotFound)
+	_, err = Values[*models.Flight](db)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		return nil
+	}))
+	require.Equal(t, []string{"put", "get", "values", "view"}, operations)
+	require.ErrorIs(t, errs[1], badger.ErrKeyNotFound)
+}
+
+func TestTracing(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	var buf bytes.Buffer
+	ctx, root := tracing.NewTracer(tracing.NewFileExporter(&buf)).Start(context.Background(), "request", tracing.SpanKindServer)
+	// operations without a span in the context are not traced
+	require.NoError(t, db.Put(&models.Flight{ID: "A"}))
+	tracedDB := db.WithContext(ctx)
+	require.NoError(t, tracedDB.Put(&models.Flight{ID: "B"}))
+	_, err = Get[*models.Flight](tracedDB, "C")
+	require.ErrorIs(t, err, badger.ErrKeyNotFound)
+	root.End()
+
+	spans, err := tracing.ReadSpans(&buf)
+	require.NoError(t, err)
+	require.Len(t, spans, 5)
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	require.Equal(t, []string{"badger.txn", "database.put", "badger.txn", "database.get", "request"}, names)
+	require.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	require.Equal(t, float64(1), spans[0].Attributes["db.txn.changes"])
+	require.Equal(t, spans[4].SpanID, spans[1].ParentSpanID)
+	require.Equal(t, "flights", spans[3].Attributes["db.collection"])
+	require.Equal(t, badger.ErrKeyNotFound.Error(), spans[3].Error)
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// feedSize is the number of recent changes that are kept to resume subscriptions.
+const feedSize = 4096
+
+// ErrLagged is returned by Subscription.Err if the subscriber did not keep up and missed changes.
+var ErrLagged = errors.New("subscription lagged behind")
+
+// Change is a committed write of a model. Seq is increasing in the order the changes were published,
+// Value is nil if the model was deleted.
+type Change struct {
+	Seq        uint64
+	Collection string
+	Key        string
+	Value      json.RawMessage
+}
+
+func (c Change) Deleted() bool {
+	return c.Value == nil
+}
+
+// Subscription receives the changes that match its filter on C. If the subscriber is too slow the
+// channel is closed and Err returns ErrLagged.
+type Subscription struct {
+	C <-chan Change
+	// Seq is the sequence number of the last change before the subscription started.
+	Seq uint64
+
+	ch    chan Change
+	match func(Change) bool
+	feed  *feed
+	err   error
+}
+
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	return s.err
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.remove(s)
+}
+
+// Version identifies the state of a collection. Seq is the sequence number of the last change of the
+// collection and Modified the time it was published, both only increase with every write.
+type Version struct {
+	Seq      uint64
+	Modified time.Time
+}
+
+type feed struct {
+	mu          sync.Mutex
+	seq         uint64
+	recent      []Change
+	subscribers map[*Subscription]struct{}
+	// versions of the collections that were changed, the other collections have the version of created
+	versions map[string]Version
+	created  time.Time
+}
+
+func newFeed() *feed {
+	return &feed{
+		recent:      make([]Change, 0, feedSize),
+		subscribers: make(map[*Subscription]struct{}),
+		versions:    make(map[string]Version),
+		created:     time.Now(),
+	}
+}
+
+// remove must be called with the lock held.
+func (f *feed) remove(s *Subscription) {
+	if _, ok := f.subscribers[s]; ok {
+		delete(f.subscribers, s)
+		close(s.ch)
+	}
+}
+
+func (f *feed) publish(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for _, change := range changes {
+		f.seq++
+		change.Seq = f.seq
+		f.versions[change.Collection] = Version{Seq: change.Seq, Modified: now}
+		if len(f.recent) == feedSize {
+			copy(f.recent, f.recent[1:])
+			f.recent = f.recent[:feedSize-1]
+		}
+		f.recent = append(f.recent, change)
+		for s := range f.subscribers {
+			if !s.match(change) {
+				continue
+			}
+			select {
+			case s.ch <- change:
+			default:
+				s.err = ErrLagged
+				f.remove(s)
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription to all future changes for which match returns true. Up to size
+// changes are buffered before the subscription is closed.
+func (db *Database) Subscribe(size int, match func(Change) bool) *Subscription {
+	f := db.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan Change, size)
+	s := &Subscription{C: ch, Seq: f.seq, ch: ch, match: match, feed: f}
+	f.subscribers[s] = struct{}{}
+	return s
+}
+
+// Changes returns the recent changes after seq for which match returns true. It returns false if
+// the changes are no longer available or seq is unknown.
+func (db *Database) Changes(seq uint64, match func(Change) bool) ([]Change, bool) {
+	f := db.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seq > f.seq {
+		return nil, false
+	}
+	if seq < f.seq-uint64(len(f.recent)) {
+		return nil, false
+	}
+	changes := make([]Change, 0)
+	for _, change := range f.recent {
+		if change.Seq > seq && match(change) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, true
+}
+
+// Seq returns the sequence number of the last change.
+func (db *Database) Seq() uint64 {
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+	return db.feed.seq
+}
+
+// Version returns the version of the collection, it changes with every committed write to the collection.
+func (db *Database) Version(collection string) Version {
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+	if v, ok := db.feed.versions[collection]; ok {
+		return v
+	}
+	return Version{Modified: db.feed.created}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func isFlight(c Change) bool {
+	return c.Collection == "flights"
+}
+
+func TestSubscribe(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	require.NoError(t, db.Put(&models.Flight{ID: "122"}))
+
+	sub := db.Subscribe(10, isFlight)
+	defer sub.Close()
+	require.Equal(t, uint64(1), sub.Seq)
+
+	flight := &models.Flight{ID: "123", Status: "scheduled"}
+	require.NoError(t, db.Put(flight, &models.Seat{FlightID: "123", Seat: "1A"}))
+	require.NoError(t, db.Delete(flight))
+	// failed transactions are not published
+	require.ErrorIs(t, db.Update(func(txn *Txn) error {
+		if err := txn.Put(flight); err != nil {
+			return err
+		}
+		return badger.ErrConflict
+	}), badger.ErrConflict)
+
+	change := <-sub.C
+	require.Equal(t, uint64(2), change.Seq)
+	require.Equal(t, "123", change.Key)
+	var changed models.Flight
+	require.NoError(t, json.Unmarshal(change.Value, &changed))
+	require.Equal(t, "scheduled", changed.Status)
+	require.False(t, change.Deleted())
+	change = <-sub.C
+	require.Equal(t, uint64(4), change.Seq)
+	require.True(t, change.Deleted())
+	require.Len(t, sub.C, 0)
+	require.Equal(t, uint64(4), db.Seq())
+
+	changes, ok := db.Changes(1, isFlight)
+	require.True(t, ok)
+	require.Len(t, changes, 2)
+	changes, ok = db.Changes(0, func(Change) bool { return true })
+	require.True(t, ok)
+	require.Len(t, changes, 4)
+	_, ok = db.Changes(5, isFlight)
+	require.False(t, ok)
+
+	sub.Close()
+	_, open := <-sub.C
+	require.False(t, open)
+	require.NoError(t, sub.Err())
+}
+
+func TestSubscribeLagged(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	sub := db.Subscribe(1, isFlight)
+	require.NoError(t, db.Put(&models.Flight{ID: "1"}, &models.Flight{ID: "2"}))
+	<-sub.C
+	_, open := <-sub.C
+	require.False(t, open)
+	require.ErrorIs(t, sub.Err(), ErrLagged)
+	sub.Close()
+}
+
+func TestChangesEvicted(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	for i := 0; i < feedSize+1; i++ {
+		require.NoError(t, db.Put(&models.Flight{ID: "1"}))
+	}
+	_, ok := db.Changes(0, isFlight)
+	require.False(t, ok)
+	changes, ok := db.Changes(1, isFlight)
+	require.True(t, ok)
+	require.Len(t, changes, feedSize)
+}
+
+func TestVersion(t *testing.T) {
+	db, err := New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	initial := db.Version("flights")
+	require.Equal(t, uint64(0), initial.Seq)
+	require.False(t, initial.Modified.IsZero())
+
+	require.NoError(t, db.Put(&models.Flight{ID: "123"}, &models.Seat{FlightID: "123", Seat: "1A"}))
+	flights := db.Version("flights")
+	require.Equal(t, uint64(1), flights.Seq)
+	require.False(t, flights.Modified.Before(initial.Modified))
+	require.Equal(t, uint64(2), db.Version("seats").Seq)
+
+	// writes to other collections do not change the version
+	require.NoError(t, db.Put(&models.Seat{FlightID: "123", Seat: "1B"}))
+	require.Equal(t, flights, db.Version("flights"))
+	require.Equal(t, uint64(3), db.Version("seats").Seq)
+
+	require.NoError(t, db.Delete(&models.Flight{ID: "123"}))
+	require.Equal(t, uint64(4), db.Version("flights").Seq)
+	require.Equal(t, uint64(0), db.Version("bookings").Seq)
+}
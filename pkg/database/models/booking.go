@@ -1,19 +1,112 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"time"
 
-type Passenger struct {
-	Name string `json:"name"`
-	Seat string `json:"seat"`
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+const (
+	BookingStatusPendingPayment = "pending_payment"
+	BookingStatusConfirmed      = "confirmed"
+	BookingStatusPaymentFailed  = "payment_failed"
+	BookingStatusExpired        = "expired"
+	BookingStatusCancelled      = "cancelled"
+	BookingStatusRebooked       = "rebooked"
+)
+
+const (
+	RefundStatusPending  = "pending"
+	RefundStatusFailed   = "failed"
+	RefundStatusRefunded = "refunded"
+)
+
+// Payment is a captured payment of a booking. Refunded is the amount that was allocated for refunds,
+// PendingRefund the part of it that was not paid back yet.
+type Payment struct {
+	ID       string      `json:"id"`
+	Amount   money.Money `json:"amount"`
+	Refunded money.Money `json:"refunded"`
+
+	PendingRefund *money.Money `json:"pendingRefund,omitempty"`
+	RefundStatus  string       `json:"refundStatus,omitempty"`
+	// RefundAttemptedAt is the time the pending refund was allocated or last failed.
+	RefundAttemptedAt *time.Time `json:"refundAttemptedAt,omitempty"`
+}
+
+// RefundDue reports whether the pending refund should be attempted, refunds are retried after the delay.
+func (p *Payment) RefundDue(now time.Time, delay time.Duration) bool {
+	return p.PendingRefund != nil && (p.RefundAttemptedAt == nil || !p.RefundAttemptedAt.Add(delay).After(now))
+}
+
+// RefundSucceeded records that the amount of the pending refund was paid back.
+func (p *Payment) RefundSucceeded(amount money.Money) {
+	if p.PendingRefund == nil {
+		return
+	}
+	pending := p.PendingRefund.Sub(amount)
+	if pending.Amount > 0 {
+		p.PendingRefund = &pending
+		return
+	}
+	p.PendingRefund = nil
+	p.RefundStatus = RefundStatusRefunded
+	p.RefundAttemptedAt = nil
+}
+
+// RefundFailed records a failed attempt of the pending refund, it is retried later.
+func (p *Payment) RefundFailed(now time.Time) {
+	p.RefundStatus = RefundStatusFailed
+	p.RefundAttemptedAt = &now
+}
+
+const (
+	ModificationChangeSeat      = "changeSeat"
+	ModificationRename          = "rename"
+	ModificationAddPassenger    = "addPassenger"
+	ModificationRemovePassenger = "removePassenger"
+	ModificationDeniedBoarding  = "deniedBoarding"
+	ModificationRebook          = "rebook"
+)
+
+// Modification is an entry in the change history of a booking.
+type Modification struct {
+	Time       time.Time   `json:"time"`
+	Type       string      `json:"type"`
+	Passenger  int         `json:"passenger"`
+	From       string      `json:"from,omitempty"`
+	To         string      `json:"to,omitempty"`
+	PriceDelta money.Money `json:"priceDelta"`
 }
 
 type Booking struct {
 	ID         string      `json:"id"`
+	Reference  string      `json:"reference"`
 	UserID     string      `json:"userId"`
 	FlightID   string      `json:"flightId"`
-	Price      int         `json:"price"`
+	BasePrice  money.Money `json:"basePrice"`
+	Discount   money.Money `json:"discount"`
+	Fees       money.Money `json:"fees"`
+	Price      money.Money `json:"price"`
+	PromoCode  string      `json:"promoCode,omitempty"`
 	Status     string      `json:"status"`
 	Passengers []Passenger `json:"passengers"`
+
+	PaymentToken    string     `json:"paymentToken,omitempty"`
+	PaymentID       string     `json:"paymentId,omitempty"`
+	PaymentDeadline *time.Time `json:"paymentDeadline,omitempty"`
+	Payments        []Payment  `json:"payments,omitempty"`
+
+	CancelledAt  *time.Time   `json:"cancelledAt,omitempty"`
+	RefundAmount *money.Money `json:"refundAmount,omitempty"`
+	RefundReason string       `json:"refundReason,omitempty"`
+
+	// RebookedFrom and RebookedTo link a booking of a cancelled flight with its replacement.
+	RebookedFrom string `json:"rebookedFrom,omitempty"`
+	RebookedTo   string `json:"rebookedTo,omitempty"`
+
+	Modifications []Modification `json:"modifications,omitempty"`
 }
 
 func (b *Booking) Collection() string {
@@ -23,3 +116,47 @@ func (b *Booking) Collection() string {
 func (b *Booking) Key() string {
 	return fmt.Sprintf("%s/%s", b.UserID, b.ID)
 }
+
+// PaidFor returns the price paid for the seat of the passenger with the discount of the booking deducted proportionally.
+func (b *Booking) PaidFor(passenger *Passenger) money.Money {
+	paid := passenger.Price
+	if b.BasePrice.Amount > 0 {
+		paid.Amount = paid.Amount * b.BasePrice.Sub(b.Discount).Amount / b.BasePrice.Amount
+	}
+	return paid
+}
+
+// AllocateRefund distributes the refund over the captured payments, starting with the most recent one,
+// and records the refunded amounts as pending. It returns the refund for each payment.
+func (b *Booking) AllocateRefund(amount money.Money, now time.Time) []Payment {
+	refunds := make([]Payment, 0, 1)
+	for i := len(b.Payments) - 1; i >= 0 && amount.Amount > 0; i-- {
+		p := &b.Payments[i]
+		refundable := p.Amount.Sub(p.Refunded)
+		if refundable.Amount <= 0 {
+			continue
+		}
+		refund := refundable.Min(amount)
+		p.Refunded = p.Refunded.Add(refund)
+		pending := refund
+		if p.PendingRefund != nil {
+			pending = pending.Add(*p.PendingRefund)
+		}
+		p.PendingRefund = &pending
+		p.RefundStatus = RefundStatusPending
+		p.RefundAttemptedAt = &now
+		amount = amount.Sub(refund)
+		refunds = append(refunds, Payment{ID: p.ID, Amount: refund})
+	}
+	return refunds
+}
+
+// PaymentByID returns the payment with the id or nil.
+func (b *Booking) PaymentByID(id string) *Payment {
+	for i := range b.Payments {
+		if b.Payments[i].ID == id {
+			return &b.Payments[i]
+		}
+	}
+	return nil
+}
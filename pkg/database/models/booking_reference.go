@@ -0,0 +1,46 @@
+package models
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// referenceAlphabet omits characters that are easily confused over the phone (0/O, 1/I).
+const referenceAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const referenceLength = 6
+
+// BookingReference is the lookup index from a record locator to the booking.
+type BookingReference struct {
+	Reference string `json:"reference"`
+	UserID    string `json:"userId"`
+	BookingID string `json:"bookingId"`
+}
+
+func (r *BookingReference) Collection() string {
+	return "references"
+}
+
+func (r *BookingReference) Key() string {
+	return r.Reference
+}
+
+// NewReference generates a random six character record locator.
+func NewReference() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(referenceAlphabet)))
+	for i := 0; i < referenceLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(referenceAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// NormalizeReference converts user input into the stored form of a record locator.
+func NormalizeReference(reference string) string {
+	return strings.ToUpper(strings.TrimSpace(reference))
+}
@@ -0,0 +1,21 @@
+package models
+
+// CheckinCounter hands out the check-in sequence numbers of a flight.
+type CheckinCounter struct {
+	FlightID string `json:"flightId"`
+	Sequence int    `json:"sequence"`
+}
+
+func (c *CheckinCounter) Collection() string {
+	return "checkins"
+}
+
+func (c *CheckinCounter) Key() string {
+	return c.FlightID
+}
+
+// Next returns the next sequence number.
+func (c *CheckinCounter) Next() int {
+	c.Sequence++
+	return c.Sequence
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+const (
+	DeniedBoardingVoluntary   = "voluntary"
+	DeniedBoardingInvoluntary = "involuntary"
+)
+
+// DeniedBoarding records a passenger of an oversold flight who did not get a seat.
+type DeniedBoarding struct {
+	ID           string      `json:"id"`
+	FlightID     string      `json:"flightId"`
+	UserID       string      `json:"userId"`
+	BookingID    string      `json:"bookingId"`
+	Passenger    Passenger   `json:"passenger"`
+	Reason       string      `json:"reason"`
+	Refund       money.Money `json:"refund"`
+	Compensation money.Money `json:"compensation"`
+	Note         string      `json:"note,omitempty"`
+	Time         time.Time   `json:"time"`
+}
+
+func (d *DeniedBoarding) Collection() string {
+	return "deniedboardings"
+}
+
+func (d *DeniedBoarding) Key() string {
+	return fmt.Sprintf("%s/%s", d.FlightID, d.ID)
+}
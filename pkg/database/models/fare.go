@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+// Duration is a time.Duration that is encoded as string in JSON, e.g. "24h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// FareRules define the conditions under which a booked seat can be refunded or changed.
+type FareRules struct {
+	Name       string      `json:"name"`
+	Refundable bool        `json:"refundable"`
+	ChangeFee  money.Money `json:"changeFee"`
+	// CancellationDeadline is the time before departure until which a refundable fare is refunded in full.
+	CancellationDeadline Duration `json:"cancellationDeadline"`
+}
+
+// DefaultFareRules are used for seats without fare rules.
+func DefaultFareRules(currency string) *FareRules {
+	return &FareRules{
+		Name:                 "standard",
+		Refundable:           true,
+		ChangeFee:            money.Zero(currency),
+		CancellationDeadline: Duration(24 * time.Hour),
+	}
+}
+
+// Refund returns the refund for the price paid for a seat with these fare rules when cancelled at now.
+func (f *FareRules) Refund(paid money.Money, departure, now time.Time) (money.Money, string) {
+	switch {
+	case !now.Before(departure):
+		return money.Zero(paid.Currency), "flight already departed"
+	case !f.Refundable:
+		return money.Zero(paid.Currency), fmt.Sprintf("%s fare is non-refundable", f.Name)
+	case now.Before(departure.Add(-time.Duration(f.CancellationDeadline))):
+		return paid, fmt.Sprintf("%s fare cancelled before deadline", f.Name)
+	}
+	fee := f.ChangeFee
+	if fee.Currency != paid.Currency {
+		// fees are defined in the currency of the seat price, anything else is a configuration error
+		fee = money.Zero(paid.Currency)
+	}
+	refund := paid.Sub(fee.Min(paid))
+	return refund, fmt.Sprintf("%s fare cancelled after deadline, fee of %s deducted", f.Name, fee)
+}
+
+type PassengerRefund struct {
+	Seat   string      `json:"seat"`
+	Amount money.Money `json:"amount"`
+	Reason string      `json:"reason"`
+}
+
+type RefundQuote struct {
+	Amount     money.Money       `json:"amount"`
+	Reason     string            `json:"reason"`
+	Passengers []PassengerRefund `json:"passengers"`
+}
+
+// QuoteRefund calculates the refund of a booking cancelled at now. Discounts are deducted proportionally
+// from the refund of every passenger, fees are not refunded.
+func QuoteRefund(booking *Booking, departure, now time.Time) *RefundQuote {
+	quote := &RefundQuote{
+		Amount:     money.Zero(booking.Price.Currency),
+		Passengers: make([]PassengerRefund, 0, len(booking.Passengers)),
+	}
+	if booking.Status != BookingStatusConfirmed {
+		quote.Reason = fmt.Sprintf("no payment captured for %s booking", booking.Status)
+		return quote
+	}
+	reasons := make([]string, 0, len(booking.Passengers))
+	for _, passenger := range booking.Passengers {
+		paid := booking.PaidFor(&passenger)
+		fare := passenger.Fare
+		if fare == nil {
+			fare = DefaultFareRules(paid.Currency)
+		}
+		amount, reason := fare.Refund(paid, departure, now)
+		quote.Amount = quote.Amount.Add(amount)
+		quote.Passengers = append(quote.Passengers, PassengerRefund{
+			Seat:   passenger.Seat,
+			Amount: amount,
+			Reason: reason,
+		})
+		if !contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	quote.Reason = strings.Join(reasons, "; ")
+	return quote
+}
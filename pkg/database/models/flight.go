@@ -2,6 +2,15 @@ package models
 
 import "time"
 
+const (
+	FlightStatusScheduled = "scheduled"
+	FlightStatusDelayed   = "delayed"
+	FlightStatusBoarding  = "boarding"
+	FlightStatusDeparted  = "departed"
+	FlightStatusLanded    = "landed"
+	FlightStatusCancelled = "cancelled"
+)
+
 type Flight struct {
 	ID        string    `json:"id"`
 	From      string    `json:"from"`
@@ -9,6 +18,13 @@ type Flight struct {
 	Departure time.Time `json:"departure"`
 	Arrival   time.Time `json:"arrival"`
 	Status    string    `json:"status"`
+	// Delay is the total delay that was added to the scheduled departure and arrival.
+	Delay Duration `json:"delay,omitempty"`
+
+	// OverbookingLimit is the number of passengers without a seat that can be booked beyond the capacity.
+	OverbookingLimit int `json:"overbookingLimit,omitempty"`
+	// Oversold is the number of booked passengers that still wait for a seat.
+	Oversold int `json:"oversold,omitempty"`
 }
 
 func (f *Flight) Collection() string {
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// SeatHold is a temporary hold of a seat during seat selection. The seat is held for the user until
+// ExpiresAt or until it is booked.
+type SeatHold struct {
+	FlightID  string    `json:"flightId"`
+	Seat      string    `json:"seat"`
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (h *SeatHold) Collection() string {
+	return "holds"
+}
+
+func (h *SeatHold) Key() string {
+	return fmt.Sprintf("%s/%s", h.FlightID, h.Seat)
+}
+
+// SeatHoldGuard is written with every new hold of a user. New holds are not detected as conflicts by counting
+// the holds of the user, so concurrent holds of the same user conflict on the guard instead.
+type SeatHoldGuard struct {
+	FlightID  string    `json:"flightId"`
+	UserID    string    `json:"userId"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (g *SeatHoldGuard) Collection() string {
+	return "hold_guards"
+}
+
+func (g *SeatHoldGuard) Key() string {
+	return fmt.Sprintf("%s/%s", g.FlightID, g.UserID)
+}
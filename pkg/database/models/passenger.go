@@ -0,0 +1,217 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+const (
+	PassengerTypeAdult  = "adult"
+	PassengerTypeChild  = "child"
+	PassengerTypeInfant = "infant"
+
+	DocumentTypePassport = "passport"
+	DocumentTypeIDCard   = "id_card"
+)
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar date that is encoded as "YYYY-MM-DD" in JSON.
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+	d.Time = t
+	return nil
+}
+
+type TravelDocument struct {
+	Type    string `json:"type"`
+	Number  string `json:"number"`
+	Country string `json:"country"`
+	Expiry  Date   `json:"expiry"`
+}
+
+type Passenger struct {
+	FirstName   string          `json:"firstName"`
+	LastName    string          `json:"lastName"`
+	DateOfBirth Date            `json:"dateOfBirth"`
+	Type        string          `json:"type"`
+	Email       string          `json:"email,omitempty"`
+	Document    *TravelDocument `json:"document,omitempty"`
+
+	// Seat is empty for passengers of an overbooked flight until a seat is assigned at check-in.
+	Seat  string      `json:"seat"`
+	Cabin string      `json:"cabin,omitempty"`
+	Price money.Money `json:"price"`
+	Fare  *FareRules  `json:"fare,omitempty"`
+
+	CheckedInAt      *time.Time `json:"checkedInAt,omitempty"`
+	BoardingGroup    string     `json:"boardingGroup,omitempty"`
+	BoardingSequence int        `json:"boardingSequence,omitempty"`
+}
+
+func (p *Passenger) FullName() string {
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
+
+// ageAt returns the age in full years at the given date.
+func (p *Passenger) ageAt(t time.Time) int {
+	dob := p.DateOfBirth.Time
+	age := t.Year() - dob.Year()
+	if t.Month() < dob.Month() || (t.Month() == dob.Month() && t.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+// TypeForAge returns the passenger type for the age at the travel date.
+func TypeForAge(age int) string {
+	switch {
+	case age < 2:
+		return PassengerTypeInfant
+	case age < 12:
+		return PassengerTypeChild
+	}
+	return PassengerTypeAdult
+}
+
+const maxNameLength = 50
+
+var documentNumberRegexp = regexp.MustCompile(`^[A-Z0-9]{5,20}$`)
+
+func validateName(v *ValidationError, field, name string) {
+	switch name = strings.TrimSpace(name); {
+	case name == "":
+		v.Add(field, "required")
+	case len(name) > maxNameLength:
+		v.Add(field, fmt.Sprintf("must not be longer than %d characters", maxNameLength))
+	}
+}
+
+// ValidateNames checks the first and last name of a passenger.
+func (p *Passenger) ValidateNames(v *ValidationError, prefix string) {
+	validateName(v, prefix+".firstName", p.FirstName)
+	validateName(v, prefix+".lastName", p.LastName)
+}
+
+// Validate checks the passenger data for a flight at the travel date and adds all problems to v.
+func (p *Passenger) Validate(v *ValidationError, prefix string, travelDate time.Time) {
+	p.ValidateNames(v, prefix)
+
+	switch {
+	case p.DateOfBirth.IsZero():
+		v.Add(prefix+".dateOfBirth", "required")
+	case p.DateOfBirth.After(travelDate):
+		v.Add(prefix+".dateOfBirth", "must be before the travel date")
+	case p.DateOfBirth.Year() < 1900:
+		v.Add(prefix+".dateOfBirth", "must be after 1900")
+	}
+
+	switch p.Type {
+	case PassengerTypeAdult, PassengerTypeChild, PassengerTypeInfant:
+		if !p.DateOfBirth.IsZero() && !p.DateOfBirth.After(travelDate) {
+			if expected := TypeForAge(p.ageAt(travelDate)); expected != p.Type {
+				v.Add(prefix+".type", fmt.Sprintf("does not match date of birth, expected %s", expected))
+			}
+		}
+	case "":
+		v.Add(prefix+".type", "required")
+	default:
+		v.Add(prefix+".type", "must be one of adult, child or infant")
+	}
+
+	if p.Email != "" {
+		if addr, err := mail.ParseAddress(p.Email); err != nil || addr.Address != p.Email {
+			v.Add(prefix+".email", "invalid email address")
+		}
+	}
+
+	if p.Document != nil {
+		p.Document.validate(v, prefix+".document", travelDate)
+	}
+}
+
+func (d *TravelDocument) validate(v *ValidationError, prefix string, travelDate time.Time) {
+	switch d.Type {
+	case DocumentTypePassport, DocumentTypeIDCard:
+	case "":
+		v.Add(prefix+".type", "required")
+	default:
+		v.Add(prefix+".type", "must be one of passport or id_card")
+	}
+	if !documentNumberRegexp.MatchString(d.Number) {
+		v.Add(prefix+".number", "must consist of 5 to 20 uppercase letters and digits")
+	}
+	if len(d.Country) != 2 && len(d.Country) != 3 {
+		v.Add(prefix+".country", "must be an ISO 3166 country code")
+	}
+	switch {
+	case d.Expiry.IsZero():
+		v.Add(prefix+".expiry", "required")
+	case !d.Expiry.After(travelDate):
+		v.Add(prefix+".expiry", "must be after the travel date")
+	}
+}
+
+// ValidatePassengers validates all passengers of a booking for a flight at the travel date.
+// Every infant has to travel with an adult and at least one passenger needs a contact email address.
+func ValidatePassengers(passengers []Passenger, travelDate time.Time) error {
+	v := &ValidationError{}
+	adults, infants := 0, 0
+	hasEmail := false
+	seats := make(map[string]bool, len(passengers))
+	for i := range passengers {
+		passengers[i].Validate(v, fmt.Sprintf("passengers[%d]", i), travelDate)
+		if seat := passengers[i].Seat; seat != "" {
+			if seats[seat] {
+				v.Add(fmt.Sprintf("passengers[%d].seat", i), "already taken by another passenger")
+			}
+			seats[seat] = true
+		}
+		switch passengers[i].Type {
+		case PassengerTypeAdult:
+			adults++
+		case PassengerTypeInfant:
+			infants++
+		}
+		hasEmail = hasEmail || passengers[i].Email != ""
+	}
+	if infants > adults {
+		v.Add("passengers", "every infant must travel with an adult")
+	}
+	if len(passengers) > 0 && !hasEmail {
+		v.Add("passengers[0].email", "at least one passenger needs a contact email address")
+	}
+	return v.Err()
+}
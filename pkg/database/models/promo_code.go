@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+const (
+	DiscountPercentage = "percentage"
+	DiscountFixed      = "fixed"
+)
+
+var (
+	ErrPromoCodeNotYetValid     = errors.New("promo code not yet valid")
+	ErrPromoCodeExpired         = errors.New("promo code expired")
+	ErrPromoCodeUsageExceeded   = errors.New("promo code usage limit reached")
+	ErrPromoCodeNotApplicable   = errors.New("promo code not applicable to this flight")
+	ErrPromoCodeInvalidDiscount = errors.New("invalid discount")
+)
+
+type PromoCode struct {
+	Code           string         `json:"code"`
+	Type           string         `json:"type"`
+	Value          int            `json:"value"`
+	Currency       string         `json:"currency,omitempty"`
+	ValidFrom      time.Time      `json:"validFrom"`
+	ValidUntil     time.Time      `json:"validUntil"`
+	MaxUses        int            `json:"maxUses,omitempty"`
+	MaxUsesPerUser int            `json:"maxUsesPerUser,omitempty"`
+	Routes         []string       `json:"routes,omitempty"`
+	FlightIDs      []string       `json:"flightIds,omitempty"`
+	Uses           int            `json:"uses"`
+	UsesByUser     map[string]int `json:"usesByUser,omitempty"`
+}
+
+func (p *PromoCode) Collection() string {
+	return "promocodes"
+}
+
+func (p *PromoCode) Key() string {
+	return p.Code
+}
+
+// Validate checks that the discount rule itself is well-formed.
+func (p *PromoCode) Validate() error {
+	switch p.Type {
+	case DiscountPercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return fmt.Errorf("%w: percentage must be between 1 and 100", ErrPromoCodeInvalidDiscount)
+		}
+	case DiscountFixed:
+		if p.Value <= 0 {
+			return fmt.Errorf("%w: fixed discount must be positive", ErrPromoCodeInvalidDiscount)
+		}
+		if !money.ValidCurrency(p.Currency) {
+			return fmt.Errorf("%w: fixed discount requires a valid currency", ErrPromoCodeInvalidDiscount)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrPromoCodeInvalidDiscount, p.Type)
+	}
+	if !p.ValidUntil.IsZero() && p.ValidUntil.Before(p.ValidFrom) {
+		return fmt.Errorf("%w: validUntil is before validFrom", ErrPromoCodeInvalidDiscount)
+	}
+	return nil
+}
+
+// Route returns the route identifier used in PromoCode.Routes, e.g. "TXL-JFK".
+func Route(from, to string) string {
+	return fmt.Sprintf("%s-%s", from, to)
+}
+
+// CheckApplicable returns an error if the promo code cannot be redeemed by the user for the flight at the given time.
+func (p *PromoCode) CheckApplicable(userID string, flight *Flight, now time.Time) error {
+	if !p.ValidFrom.IsZero() && now.Before(p.ValidFrom) {
+		return ErrPromoCodeNotYetValid
+	}
+	if !p.ValidUntil.IsZero() && now.After(p.ValidUntil) {
+		return ErrPromoCodeExpired
+	}
+	if p.MaxUses > 0 && p.Uses >= p.MaxUses {
+		return ErrPromoCodeUsageExceeded
+	}
+	if p.MaxUsesPerUser > 0 && p.UsesByUser[userID] >= p.MaxUsesPerUser {
+		return ErrPromoCodeUsageExceeded
+	}
+	if len(p.Routes) > 0 && !contains(p.Routes, Route(flight.From, flight.To)) {
+		return ErrPromoCodeNotApplicable
+	}
+	if len(p.FlightIDs) > 0 && !contains(p.FlightIDs, flight.ID) {
+		return ErrPromoCodeNotApplicable
+	}
+	return nil
+}
+
+// Discount returns the discount for the base price. Fixed discounts are given in minor units of
+// the promo code currency and are converted into the currency of the base price.
+// The discount never exceeds the base price.
+func (p *PromoCode) Discount(basePrice money.Money, rates *money.Rates) (money.Money, error) {
+	if p.Type == DiscountPercentage {
+		return basePrice.Percent(p.Value), nil
+	}
+	discount, err := rates.Convert(money.New(int64(p.Value), p.Currency), basePrice.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return discount.Min(basePrice), nil
+}
+
+// Redeem records a usage of the promo code by the user.
+func (p *PromoCode) Redeem(userID string) {
+	p.Uses++
+	if p.UsesByUser == nil {
+		p.UsesByUser = make(map[string]int)
+	}
+	p.UsesByUser[userID]++
+}
+
+// Release reverts a usage of the promo code by the user, e.g. if the booking was never paid.
+func (p *PromoCode) Release(userID string) {
+	if p.Uses > 0 {
+		p.Uses--
+	}
+	if p.UsesByUser[userID] > 0 {
+		p.UsesByUser[userID]--
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RebookingOutcomeRebooked = "rebooked"
+	RebookingOutcomeFailed   = "failed"
+)
+
+// SeatChange maps the seat of a passenger on the cancelled flight to the seat on the new flight.
+type SeatChange struct {
+	Passenger string `json:"passenger"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// Rebooking is the audit record of moving a booking away from a cancelled flight.
+type Rebooking struct {
+	FlightID     string       `json:"flightId"`
+	UserID       string       `json:"userId"`
+	BookingID    string       `json:"bookingId"`
+	Outcome      string       `json:"outcome"`
+	Reason       string       `json:"reason,omitempty"`
+	NewFlightID  string       `json:"newFlightId,omitempty"`
+	NewBookingID string       `json:"newBookingId,omitempty"`
+	Seats        []SeatChange `json:"seats,omitempty"`
+	Time         time.Time    `json:"time"`
+	// SeatsVersion is the sequence number of the seats collection at the last failed attempt.
+	SeatsVersion uint64 `json:"seatsVersion,omitempty"`
+}
+
+func (r *Rebooking) Collection() string {
+	return "rebookings"
+}
+
+func (r *Rebooking) Key() string {
+	return fmt.Sprintf("%s/%s", r.FlightID, r.BookingID)
+}
@@ -1,13 +1,31 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+const (
+	CabinBusiness = "business"
+	CabinEconomy  = "economy"
+)
+
+// businessRows is the number of rows at the front of the aircraft that belong to the business cabin.
+const businessRows = 5
 
 type Seat struct {
-	FlightID  string `json:"flightId"`
-	Seat      string `json:"seat"`
-	Row       int    `json:"row"`
-	Price     int    `json:"price"`
-	Available bool   `json:"available"`
+	FlightID  string      `json:"flightId"`
+	Seat      string      `json:"seat"`
+	Row       int         `json:"row"`
+	Price     money.Money `json:"price"`
+	Available bool        `json:"available"`
+	Fare      *FareRules  `json:"fare,omitempty"`
+
+	// HeldBy is the user the unavailable seat is held for until HeldUntil.
+	HeldBy    string     `json:"heldBy,omitempty"`
+	HeldUntil *time.Time `json:"heldUntil,omitempty"`
 }
 
 func (s *Seat) Collection() string {
@@ -17,3 +35,34 @@ func (s *Seat) Collection() string {
 func (s *Seat) Key() string {
 	return fmt.Sprintf("%s/%s", s.FlightID, s.Seat)
 }
+
+func (s *Seat) Cabin() string {
+	if s.Row <= businessRows {
+		return CabinBusiness
+	}
+	return CabinEconomy
+}
+
+func ValidCabin(cabin string) bool {
+	return cabin == CabinBusiness || cabin == CabinEconomy
+}
+
+// Hold makes the seat unavailable for everyone except the user until the given time.
+func (s *Seat) Hold(userID string, until time.Time) {
+	s.Available = false
+	s.HeldBy = userID
+	s.HeldUntil = &until
+}
+
+func (s *Seat) ClearHold() {
+	s.HeldBy = ""
+	s.HeldUntil = nil
+}
+
+// AvailableFor reports whether the user can book the seat at now.
+func (s *Seat) AvailableFor(userID string, now time.Time) bool {
+	if s.Available {
+		return true
+	}
+	return s.HeldBy != "" && s.HeldBy == userID && s.HeldUntil != nil && now.Before(*s.HeldUntil)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects all invalid fields of a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (v *ValidationError) Add(field, message string) {
+	v.Fields = append(v.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns nil if no field errors were added.
+func (v *ValidationError) Err() error {
+	if len(v.Fields) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *ValidationError) Error() string {
+	msgs := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		msgs[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
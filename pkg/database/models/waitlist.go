@@ -0,0 +1,98 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	WaitlistStatusWaiting = "waiting"
+	WaitlistStatusOffered = "offered"
+	WaitlistStatusClaimed = "claimed"
+	WaitlistStatusExpired = "expired"
+	WaitlistStatusLeft    = "left"
+)
+
+type WaitlistEntry struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"userId"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	Status         string     `json:"status"`
+	Seat           string     `json:"seat,omitempty"`
+	OfferExpiresAt *time.Time `json:"offerExpiresAt,omitempty"`
+}
+
+// Pending reports whether the entry still waits for a seat or has an open offer.
+func (e *WaitlistEntry) Pending() bool {
+	return e.Status == WaitlistStatusWaiting || e.Status == WaitlistStatusOffered
+}
+
+// Waitlist is the queue of users waiting for a seat in a cabin of a flight.
+// The entries are kept in the order the users joined.
+type Waitlist struct {
+	FlightID string           `json:"flightId"`
+	Cabin    string           `json:"cabin"`
+	Entries  []*WaitlistEntry `json:"entries"`
+}
+
+func (w *Waitlist) Collection() string {
+	return "waitlists"
+}
+
+func (w *Waitlist) Key() string {
+	return fmt.Sprintf("%s/%s", w.FlightID, w.Cabin)
+}
+
+func (w *Waitlist) Entry(id string) *WaitlistEntry {
+	for _, e := range w.Entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// PendingEntry returns the waiting or offered entry of the user.
+func (w *Waitlist) PendingEntry(userID string) *WaitlistEntry {
+	for _, e := range w.Entries {
+		if e.UserID == userID && e.Pending() {
+			return e
+		}
+	}
+	return nil
+}
+
+// OfferFor returns the open offer of the user for the seat.
+func (w *Waitlist) OfferFor(userID, seat string) *WaitlistEntry {
+	for _, e := range w.Entries {
+		if e.UserID == userID && e.Status == WaitlistStatusOffered && e.Seat == seat {
+			return e
+		}
+	}
+	return nil
+}
+
+// Next returns the first entry that is still waiting.
+func (w *Waitlist) Next() *WaitlistEntry {
+	for _, e := range w.Entries {
+		if e.Status == WaitlistStatusWaiting {
+			return e
+		}
+	}
+	return nil
+}
+
+// Position returns the 1-based position of a waiting entry in the queue, or 0 if it is not waiting.
+func (w *Waitlist) Position(id string) int {
+	pos := 0
+	for _, e := range w.Entries {
+		if e.Status != WaitlistStatusWaiting {
+			continue
+		}
+		pos++
+		if e.ID == id {
+			return pos
+		}
+	}
+	return 0
+}
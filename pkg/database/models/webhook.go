@@ -0,0 +1,162 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventBookingCreated        = "booking.created"
+	EventBookingConfirmed      = "booking.confirmed"
+	EventBookingPaymentFailed  = "booking.payment_failed"
+	EventBookingExpired        = "booking.expired"
+	EventBookingCancelled      = "booking.cancelled"
+	EventBookingModified       = "booking.modified"
+	EventBookingCheckedIn      = "booking.checked_in"
+	EventBookingDeniedBoarding = "booking.denied_boarding"
+	EventBookingRebooked       = "booking.rebooked"
+	EventFlightStatusChanged   = "flight.status_changed"
+
+	// EventAll subscribes to all events.
+	EventAll = "*"
+)
+
+// EventTypes are all event types a webhook can subscribe to.
+var EventTypes = []string{
+	EventBookingCreated, EventBookingConfirmed, EventBookingPaymentFailed, EventBookingExpired,
+	EventBookingCancelled, EventBookingModified, EventBookingCheckedIn, EventBookingDeniedBoarding,
+	EventBookingRebooked, EventFlightStatusChanged,
+}
+
+// Event is an entry of the outbox. It is written in the same transaction as the change it describes
+// and removed once the deliveries to all subscribed webhooks are created.
+type Event struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+func NewEvent(eventType string, data any, now time.Time) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		// the time prefix keeps the outbox in order
+		ID:   fmt.Sprintf("%020d-%s", now.UnixNano(), uuid.NewString()),
+		Type: eventType,
+		Time: now,
+		Data: raw,
+	}, nil
+}
+
+func (e *Event) Collection() string {
+	return "outbox"
+}
+
+func (e *Event) Key() string {
+	return e.ID
+}
+
+// FlightStatusChange is the data of a flight.status_changed event.
+type FlightStatusChange struct {
+	*Flight
+	PreviousStatus string `json:"previousStatus"`
+}
+
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (w *WebhookSubscription) Collection() string {
+	return "webhooks"
+}
+
+func (w *WebhookSubscription) Key() string {
+	return w.ID
+}
+
+// Subscribed reports whether the event type is delivered to the webhook.
+func (w *WebhookSubscription) Subscribed(eventType string) bool {
+	return contains(w.Events, EventAll) || contains(w.Events, eventType)
+}
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	// DeliveryStatusDead marks deliveries that failed too often and are not retried anymore.
+	DeliveryStatusDead = "dead"
+)
+
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   Duration  `json:"duration"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook subscription.
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID string            `json:"subscriptionId"`
+	EventID        string            `json:"eventId"`
+	EventType      string            `json:"eventType"`
+	Payload        json.RawMessage   `json:"payload"`
+	Status         string            `json:"status"`
+	Attempts       []DeliveryAttempt `json:"attempts,omitempty"`
+	NextAttempt    *time.Time        `json:"nextAttempt,omitempty"`
+	// ExpiresAt is the time a delivered or dead delivery is removed.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (d *WebhookDelivery) Collection() string {
+	return "deliveries"
+}
+
+func (d *WebhookDelivery) Key() string {
+	return fmt.Sprintf("%s/%s", d.SubscriptionID, d.ID)
+}
+
+// Timer returns the timer of the next attempt of a pending delivery or of the expiry of a delivered or dead
+// delivery, it is nil if the delivery is never due.
+func (d *WebhookDelivery) Timer() *DeliveryTimer {
+	due := d.ExpiresAt
+	if d.Status == DeliveryStatusPending {
+		due = d.NextAttempt
+	}
+	if due == nil {
+		return nil
+	}
+	return &DeliveryTimer{Due: *due, SubscriptionID: d.SubscriptionID, DeliveryID: d.ID}
+}
+
+// DeliveryTimer indexes the deliveries by the time they are due, so that the worker does not have to load
+// all deliveries.
+type DeliveryTimer struct {
+	Due            time.Time `json:"due"`
+	SubscriptionID string    `json:"subscriptionId"`
+	DeliveryID     string    `json:"deliveryId"`
+}
+
+func (t *DeliveryTimer) Collection() string {
+	return "delivery_timers"
+}
+
+// Key starts with the due time, which keeps the timers in order.
+func (t *DeliveryTimer) Key() string {
+	return fmt.Sprintf("%020d/%s/%s", t.Due.UnixNano(), t.SubscriptionID, t.DeliveryID)
+}
+
+// DeliveryKey returns the key of the delivery of the timer.
+func (t *DeliveryTimer) DeliveryKey() string {
+	return fmt.Sprintf("%s/%s", t.SubscriptionID, t.DeliveryID)
+}
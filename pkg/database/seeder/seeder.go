@@ -8,22 +8,44 @@ import (
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+var (
+	flexFare = &models.FareRules{
+		Name:                 "flex",
+		Refundable:           true,
+		ChangeFee:            money.Zero("EUR"),
+		CancellationDeadline: models.Duration(24 * time.Hour),
+	}
+	basicFare = &models.FareRules{
+		Name:                 "basic",
+		Refundable:           false,
+		ChangeFee:            money.New(5000, "EUR"),
+		CancellationDeadline: models.Duration(24 * time.Hour),
+	}
 )
 
 func generateSeats(flightID string, rows int) []*models.Seat {
 	seats := make([]*models.Seat, rows*6)
 	i := 0
-	for row := 1; row <= rows+1; row++ {
+	// row 13 is skipped, so the rows are counted until all seats are generated
+	for row := 1; i < len(seats); row++ {
 		if row == 13 {
 			continue
 		}
+		fare := basicFare
+		if row <= 5 {
+			fare = flexFare
+		}
 		for _, seat := range []string{"A", "B", "C", "D", "E", "F"} {
 			seats[i] = &models.Seat{
 				FlightID:  flightID,
 				Seat:      fmt.Sprintf("%d%s", row, seat),
 				Row:       row,
-				Price:     gofakeit.IntRange(20, 500),
+				Price:     money.New(int64(gofakeit.IntRange(20, 500))*100, "EUR"),
 				Available: true,
+				Fare:      fare,
 			}
 			i++
 		}
@@ -41,7 +63,9 @@ func generateFlight(rows int) (*models.Flight, []*models.Seat) {
 		Departure: startTime,
 		Arrival:   startTime.Add(randomFlightDuration),
 		// the scheduled status should be the most common status
-		Status: gofakeit.RandomString([]string{"scheduled", "scheduled", "cancelled", "delayed"}),
+		Status: gofakeit.RandomString([]string{
+			models.FlightStatusScheduled, models.FlightStatusScheduled, models.FlightStatusCancelled, models.FlightStatusDelayed,
+		}),
 	}
 
 	return flight, generateSeats(flight.ID, rows)
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Txn is a database transaction. All reads see a consistent snapshot and all writes are
+// committed atomically. If another transaction modified a key that was read, the commit fails
+// with badger.ErrConflict.
+type Txn struct {
+	db      *Database
+	txn     *badger.Txn
+	changes []Change
+}
+
+// Update runs fn inside a read-write transaction. The transaction is committed if fn returns nil,
+// the changes are published to the subscribers after the commit.
+func (db *Database) Update(fn func(txn *Txn) error) error {
+	opDB, end := db.begin("update", "")
+	err := opDB.update(fn)
+	end(err)
+	return err
+}
+
+// update runs fn inside a read-write Badger transaction and publishes the changes.
+func (db *Database) update(fn func(txn *Txn) error) error {
+	_, span := tracing.StartChild(db.Context(), "badger.txn")
+	defer span.End()
+	span.SetAttribute("db.txn.update", true)
+	var changes []Change
+	err := db.db.Update(func(txn *badger.Txn) error {
+		t := &Txn{db: db, txn: txn}
+		if err := fn(t); err != nil {
+			return err
+		}
+		changes = t.changes
+		return nil
+	})
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	span.SetAttribute("db.txn.changes", len(changes))
+	db.feed.publish(changes)
+	return nil
+}
+
+// DryRun runs fn inside a read-write transaction that is always discarded, e.g. to calculate the outcome of
+// changes without committing them.
+func (db *Database) DryRun(fn func(txn *Txn) error) error {
+	opDB, end := db.begin("dryrun", "")
+	_, span := tracing.StartChild(opDB.Context(), "badger.txn")
+	span.SetAttribute("db.txn.update", false)
+	txn := opDB.db.NewTransaction(true)
+	err := fn(&Txn{db: opDB, txn: txn})
+	txn.Discard()
+	span.SetError(err)
+	span.End()
+	end(err)
+	return err
+}
+
+// View runs fn inside a read-only transaction.
+func (db *Database) View(fn func(txn *Txn) error) error {
+	opDB, end := db.begin("view", "")
+	err := opDB.view(func(txn *badger.Txn) error {
+		return fn(&Txn{db: opDB, txn: txn})
+	})
+	end(err)
+	return err
+}
+
+// Context returns the context of the database that started the transaction.
+func (t *Txn) Context() context.Context {
+	return t.db.Context()
+}
+
+// Get retrieves a model inside the transaction. If the model is not found, a badger.ErrKeyNotFound error is returned.
+func (t *Txn) Get(key string, val Model) error {
+	return t.db.get(t.txn, key, val)
+}
+
+// TxnValues is the equivalent of Values inside the transaction. The keys that are read are checked for
+// conflicts when the transaction is committed.
+func TxnValues[T Model](txn *Txn, prefixes ...string) ([]T, error) {
+	return scanValues[T](txn.db, txn.txn, prefixes)
+}
+
+// Put one or more models into the database when the transaction is committed.
+func (t *Txn) Put(models ...Model) error {
+	for _, m := range models {
+		e, err := t.db.toEntry(m)
+		if err != nil {
+			return err
+		}
+		if err := t.txn.SetEntry(e); err != nil {
+			return err
+		}
+		t.changes = append(t.changes, Change{Collection: m.Collection(), Key: m.Key(), Value: e.Value})
+	}
+	return nil
+}
+
+// Delete removes one or more models from the database when the transaction is committed.
+func (t *Txn) Delete(models ...Model) error {
+	for _, m := range models {
+		if err := t.txn.Delete(t.db.getPrefixedKey(m.Collection(), m.Key())); err != nil {
+			return err
+		}
+		t.changes = append(t.changes, Change{Collection: m.Collection(), Key: m.Key()})
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type contextKey struct{}
+
+// NewContext returns a context that carries the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of the context or fallback if the context carries none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	switch l := ctx.Value(contextKey{}).(type) {
+	case *Logger:
+		return l
+	case *requestLogger:
+		return l.resolve()
+	}
+	return fallback
+}
+
+// requestLogger adds the route pattern whenever the logger is retrieved, because the fields of With are
+// encoded immediately and the route is only known after the request was routed.
+type requestLogger struct {
+	base *Logger
+	rctx *chi.Context
+}
+
+// resolve returns the logger with the current route pattern. Requests without a matching route have the
+// pattern "unmatched".
+func (l *requestLogger) resolve() *Logger {
+	route := l.rctx.RoutePattern()
+	if route == "" {
+		route = "unmatched"
+	}
+	return l.base.With("route", route)
+}
+
+// Middleware adds a logger to the request context that is enriched with the request ID, trace ID, user
+// and route pattern of the request.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		fields := []any{"requestId", middleware.GetReqID(ctx)}
+		if traceID := tracing.TraceIDFromContext(ctx); traceID != "" {
+			fields = append(fields, "traceId", traceID)
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			fields = append(fields, "user", user)
+		}
+		reqLog := l.With(fields...)
+		if rctx := chi.RouteContext(ctx); rctx != nil {
+			ctx = context.WithValue(ctx, contextKey{}, &requestLogger{base: reqLog, rctx: rctx})
+		} else {
+			ctx = NewContext(ctx, reqLog)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
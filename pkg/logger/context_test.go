@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	fallback := NewNop()
+	require.Same(t, fallback, FromContext(context.Background(), fallback))
+	l := NewNop()
+	require.Same(t, l, FromContext(NewContext(context.Background(), l), fallback))
+}
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := &Logger{SugaredLogger: zap.New(core).Sugar()}
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(l.Middleware)
+	r.Get("/flights/{id}", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context(), nil).Warn("handler")
+	})
+
+	req := httptest.NewRequest("GET", "/flights/123", nil)
+	req.SetBasicAuth("user", "pw")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.NotEmpty(t, fields["requestId"])
+	require.Equal(t, "user", fields["user"])
+	require.Equal(t, "/flights/{id}", fields["route"])
+	require.NotContains(t, fields, "traceId")
+}
+
+func TestMiddlewareJSON(t *testing.T) {
+	// the JSON encoder encodes the fields of With immediately
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	l := &Logger{SugaredLogger: zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel)).Sugar()}
+	r := chi.NewRouter()
+	r.Use(l.Middleware)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			FromContext(r.Context(), nil).Info("request")
+		})
+	})
+	r.Route("/flights", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			FromContext(r.Context(), nil).Info("handler")
+		})
+	})
+
+	routes := func() []string {
+		routes := make([]string, 0)
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			var entry map[string]any
+			require.NoError(t, dec.Decode(&entry))
+			routes = append(routes, entry["route"].(string))
+		}
+		return routes
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/flights/123", nil))
+	require.Equal(t, []string{"/flights/{id}", "/flights/{id}"}, routes())
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+	require.Equal(t, []string{"unmatched"}, routes())
+}
@@ -1,10 +1,9 @@
 package logger
 
 import (
-	"net/http"
+	"fmt"
 	"os"
 
-	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/term"
@@ -19,8 +18,42 @@ const (
 	ErrorLevel = LogLevel(zap.ErrorLevel)
 )
 
+// ParseLevel returns the level of the name: debug, info, warn or error.
+func ParseLevel(name string) (LogLevel, error) {
+	switch name {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+func (l LogLevel) String() string {
+	return zapcore.Level(l).String()
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
 type Logger struct {
 	*zap.SugaredLogger
+	// level is shared by all loggers that were derived with With
+	level *zap.AtomicLevel
 }
 
 func New(level LogLevel) *Logger {
@@ -34,18 +67,44 @@ func New(level LogLevel) *Logger {
 	cfg.DisableStacktrace = true
 	cfg.Level = zap.NewAtomicLevelAt(zapcore.Level(level))
 	logger, _ := cfg.Build()
-	return &Logger{logger.Sugar()}
+	return &Logger{logger.Sugar(), &cfg.Level}
 }
 
 func NewNop() *Logger {
 	logger := zap.NewNop()
-	return &Logger{logger.Sugar()}
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	return &Logger{logger.Sugar(), &level}
 }
 
-func (l *Logger) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqID := middleware.GetReqID(r.Context())
-		l.Debugw("request", "method", r.Method, "path", r.URL.Path, "requestId", reqID, "query", r.URL.RawQuery)
-		next.ServeHTTP(w, r)
-	})
+// With returns a logger that adds the key-value pairs to every entry.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{l.SugaredLogger.With(args...), l.level}
+}
+
+// Level returns the minimum level of the logged entries.
+func (l *Logger) Level() LogLevel {
+	if l.level == nil {
+		return DebugLevel
+	}
+	return LogLevel(l.level.Level())
+}
+
+// SetLevel changes the minimum level of the logged entries at runtime.
+func (l *Logger) SetLevel(level LogLevel) {
+	if l.level != nil {
+		l.level.SetLevel(zapcore.Level(level))
+	}
+}
+
+// ToggleDebug switches between the debug level and the given level and returns the new level. If the given
+// level is debug, it switches between debug and info.
+func (l *Logger) ToggleDebug(level LogLevel) LogLevel {
+	if level == DebugLevel {
+		level = InfoLevel
+	}
+	if l.Level() != DebugLevel {
+		level = DebugLevel
+	}
+	l.SetLevel(level)
+	return level
 }
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		level, err := ParseLevel(name)
+		require.NoError(t, err)
+		require.Equal(t, name, level.String())
+	}
+	_, err := ParseLevel("verbose")
+	require.Error(t, err)
+
+	var v struct {
+		Level LogLevel `json:"level"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"level":"warn"}`), &v))
+	require.Equal(t, WarnLevel, v.Level)
+	require.Error(t, json.Unmarshal([]byte(`{"level":"verbose"}`), &v))
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"level":"warn"}`, string(data))
+}
+
+func TestSetLevel(t *testing.T) {
+	log := NewNop()
+	child := log.With("key", "value")
+	require.Equal(t, InfoLevel, log.Level())
+	log.SetLevel(ErrorLevel)
+	require.Equal(t, ErrorLevel, log.Level())
+	require.Equal(t, ErrorLevel, child.Level())
+	child.SetLevel(DebugLevel)
+	require.Equal(t, DebugLevel, log.Level())
+}
+
+func TestToggleDebug(t *testing.T) {
+	log := NewNop()
+	log.SetLevel(WarnLevel)
+	require.Equal(t, DebugLevel, log.ToggleDebug(WarnLevel))
+	require.Equal(t, DebugLevel, log.Level())
+	require.Equal(t, WarnLevel, log.ToggleDebug(WarnLevel))
+	require.Equal(t, WarnLevel, log.Level())
+
+	log.SetLevel(DebugLevel)
+	require.Equal(t, InfoLevel, log.ToggleDebug(DebugLevel))
+	require.Equal(t, DebugLevel, log.ToggleDebug(DebugLevel))
+}
@@ -0,0 +1,301 @@
+// Package metrics implements counters, histograms and gauges that are exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets for latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelSeparator joins the label values of a child, it cannot appear in valid UTF-8 label values.
+const labelSeparator = "\xff"
+
+// Collector writes the samples of one or more metric families.
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// Registry holds the collectors that are exposed by its handler.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounter registers a counter with the label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, labels: labels}, children: make(map[string]*Counter)}
+	r.Register(c)
+	return c
+}
+
+// NewHistogram registers a histogram with the upper bounds of the buckets and the label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: buckets, children: make(map[string]*Histogram)}
+	r.Register(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.Register(collectorFunc(func(w *Writer) {
+		w.Header(name, help, "gauge")
+		w.Sample(name, nil, nil, fn())
+	}))
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mu.Unlock()
+	w := &Writer{w: bufio.NewWriter(out)}
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+	if err := w.w.Flush(); err != nil {
+		return w.n, err
+	}
+	return w.n, w.err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type collectorFunc func(w *Writer)
+
+func (f collectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Writer writes metric families in the Prometheus text format.
+type Writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Header writes the HELP and TYPE lines of a metric family.
+func (w *Writer) Header(name, help, metricType string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, metricType)
+}
+
+// Sample writes a sample with the label names and values.
+func (w *Writer) Sample(name string, labels, values []string, value float64) {
+	if len(labels) == 0 {
+		w.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelEscaper.Replace(values[i]))
+	}
+	w.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, labelSeparator)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.SplitN(key, labelSeparator, n)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits uint64
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		if atomic.CompareAndSwapUint64(&c.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	desc
+	mu       sync.RWMutex
+	children map[string]*Counter
+}
+
+// With returns the counter of the label values, in the order of the label names.
+func (c *CounterVec) With(values ...string) *Counter {
+	key := c.key(values)
+	c.mu.RLock()
+	child := c.children[key]
+	c.mu.RUnlock()
+	if child != nil {
+		return child
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if child = c.children[key]; child == nil {
+		child = &Counter{}
+		c.children[key] = child
+	}
+	return child
+}
+
+func (c *CounterVec) Collect(w *Writer) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	w.Header(c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.children) {
+		w.Sample(c.name, c.labels, splitKey(key, len(c.labels)), c.children[key].Value())
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets  []float64
+	mu       sync.RWMutex
+	children map[string]*Histogram
+}
+
+// With returns the histogram of the label values, in the order of the label names.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	key := h.key(values)
+	h.mu.RLock()
+	child := h.children[key]
+	h.mu.RUnlock()
+	if child != nil {
+		return child
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if child = h.children[key]; child == nil {
+		child = &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+		h.children[key] = child
+	}
+	return child
+}
+
+func (h *HistogramVec) Collect(w *Writer) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	w.Header(h.name, h.help, "histogram")
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range sortedKeys(h.children) {
+		child := h.children[key]
+		values := splitKey(key, len(h.labels))
+		child.mu.Lock()
+		bucketValues := append(append([]string{}, values...), "")
+		cumulative := uint64(0)
+		for i, upper := range child.buckets {
+			cumulative += child.counts[i]
+			bucketValues[len(values)] = formatFloat(upper)
+			w.Sample(h.name+"_bucket", labels, bucketValues, float64(cumulative))
+		}
+		bucketValues[len(values)] = "+Inf"
+		w.Sample(h.name+"_bucket", labels, bucketValues, float64(child.count))
+		w.Sample(h.name+"_sum", h.labels, values, child.sum)
+		w.Sample(h.name+"_count", h.labels, values, float64(child.count))
+		child.mu.Unlock()
+	}
+}
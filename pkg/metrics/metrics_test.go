@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Number of requests.", "method", "path")
+	c.With("GET", "/a").Inc()
+	c.With("GET", "/a").Add(2)
+	c.With("POST", `/"b"`).Inc()
+	require.Equal(t, float64(3), c.With("GET", "/a").Value())
+	require.Panics(t, func() {
+		c.With("GET")
+	})
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a"} 3
+requests_total{method="POST",path="/\"b\""} 1
+`, buf.String())
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	h.With("get").Observe(0.05)
+	h.With("get").Observe(0.5)
+	h.With("get").Observe(0.5)
+	h.With("get").Observe(3)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 1
+latency_seconds_bucket{op="get",le="1"} 3
+latency_seconds_bucket{op="get",le="+Inf"} 4
+latency_seconds_sum{op="get"} 4.05
+latency_seconds_count{op="get"} 4
+`, buf.String())
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("answer", "The answer.", func() float64 {
+		return 42
+	})
+	r.Register(NewRuntimeCollector())
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.True(t, strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	body := res.Body.String()
+	require.Contains(t, body, "# TYPE answer gauge\nanswer 42\n")
+	require.Contains(t, body, "go_goroutines ")
+	require.Contains(t, body, `go_info{version="go`)
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+// RuntimeCollector exposes the stats of the Go runtime and the start time of the process.
+type RuntimeCollector struct {
+	start time.Time
+}
+
+func NewRuntimeCollector() *RuntimeCollector {
+	return &RuntimeCollector{start: time.Now()}
+}
+
+func (c *RuntimeCollector) Collect(w *Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	w.Header("go_info", "Information about the Go environment.", "gauge")
+	w.Sample("go_info", []string{"version"}, []string{runtime.Version()}, 1)
+	w.Header("go_goroutines", "Number of goroutines that currently exist.", "gauge")
+	w.Sample("go_goroutines", nil, nil, float64(runtime.NumGoroutine()))
+	w.Header("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge")
+	w.Sample("go_memstats_alloc_bytes", nil, nil, float64(stats.Alloc))
+	w.Header("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter")
+	w.Sample("go_memstats_alloc_bytes_total", nil, nil, float64(stats.TotalAlloc))
+	w.Header("go_memstats_sys_bytes", "Number of bytes obtained from the system.", "gauge")
+	w.Sample("go_memstats_sys_bytes", nil, nil, float64(stats.Sys))
+	w.Header("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge")
+	w.Sample("go_memstats_heap_inuse_bytes", nil, nil, float64(stats.HeapInuse))
+	w.Header("go_memstats_heap_objects", "Number of allocated objects.", "gauge")
+	w.Sample("go_memstats_heap_objects", nil, nil, float64(stats.HeapObjects))
+	w.Header("go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+	w.Sample("go_gc_cycles_total", nil, nil, float64(stats.NumGC))
+	w.Header("go_gc_pause_seconds_total", "Total time the program was stopped by the GC.", "counter")
+	w.Sample("go_gc_pause_seconds_total", nil, nil, float64(stats.PauseTotalNs)/1e9)
+	w.Header("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", "gauge")
+	w.Sample("process_start_time_seconds", nil, nil, float64(c.start.UnixNano())/1e9)
+}
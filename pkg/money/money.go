@@ -0,0 +1,132 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// minorUnits maps ISO 4217 currency codes to the number of digits after the decimal separator.
+var minorUnits = map[string]int{
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"NOK": 2,
+	"NZD": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"TRY": 2,
+	"USD": 2,
+}
+
+// Money is an amount in the minor unit of an ISO 4217 currency, e.g. cents for EUR.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns a zero amount in the given currency.
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// MinorUnits returns the number of minor unit digits of the currency.
+func MinorUnits(currency string) (int, error) {
+	digits, ok := minorUnits[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	return digits, nil
+}
+
+// ValidCurrency reports whether the currency code is known.
+func ValidCurrency(currency string) bool {
+	_, ok := minorUnits[currency]
+	return ok
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.Currency != o.Currency {
+		panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency))
+	}
+}
+
+// Add returns the sum of both amounts. A zero value without currency adopts the currency of o.
+// Adding amounts of different currencies panics.
+func (m Money) Add(o Money) Money {
+	if m.Currency == "" && m.Amount == 0 {
+		return o
+	}
+	m.mustMatch(o)
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}
+}
+
+// Sub returns the difference of both amounts. Subtracting amounts of different currencies panics.
+func (m Money) Sub(o Money) Money {
+	if o.Currency == "" && o.Amount == 0 {
+		return m
+	}
+	m.mustMatch(o)
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}
+}
+
+// Min returns the smaller of both amounts.
+func (m Money) Min(o Money) Money {
+	m.mustMatch(o)
+	if o.Amount < m.Amount {
+		return o
+	}
+	return m
+}
+
+// Percent returns the given percentage of the amount, rounded down to the minor unit.
+func (m Money) Percent(p int) Money {
+	return Money{Amount: m.Amount * int64(p) / 100, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) String() string {
+	digits, err := MinorUnits(m.Currency)
+	if err != nil || digits == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	scale := pow10(digits)
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, digits, amount%scale, m.Currency)
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
@@ -0,0 +1,79 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoneyString(t *testing.T) {
+	require.Equal(t, "12.05 EUR", New(1205, "EUR").String())
+	require.Equal(t, "-0.50 USD", New(-50, "USD").String())
+	require.Equal(t, "1500 JPY", New(1500, "JPY").String())
+	require.Equal(t, "1.234 KWD", New(1234, "KWD").String())
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	sum := Money{}.Add(New(100, "EUR")).Add(New(250, "EUR"))
+	require.Equal(t, New(350, "EUR"), sum)
+	require.Equal(t, New(300, "EUR"), sum.Sub(New(50, "EUR")))
+	require.Equal(t, New(87, "EUR"), sum.Percent(25))
+	require.Panics(t, func() {
+		sum.Add(New(1, "USD"))
+	})
+}
+
+func TestConvert(t *testing.T) {
+	rates, err := ParseRates([]byte(`{"base": "EUR", "rates": {"USD": "1.1", "JPY": "150", "KWD": "0.333"}}`))
+	require.NoError(t, err)
+
+	m, err := rates.Convert(New(1000, "EUR"), "usd")
+	require.NoError(t, err)
+	require.Equal(t, New(1100, "USD"), m)
+
+	m, err = rates.Convert(New(1100, "USD"), "EUR")
+	require.NoError(t, err)
+	require.Equal(t, New(1000, "EUR"), m)
+
+	m, err = rates.Convert(New(1001, "EUR"), "JPY")
+	require.NoError(t, err)
+	require.Equal(t, New(1502, "JPY"), m)
+
+	m, err = rates.Convert(New(100, "EUR"), "KWD")
+	require.NoError(t, err)
+	require.Equal(t, New(333, "KWD"), m)
+
+	_, err = rates.Convert(New(100, "EUR"), "CHF")
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestRounding(t *testing.T) {
+	cases := []struct {
+		rounding Rounding
+		amount   int64
+		expected int64
+	}{
+		{RoundHalfEven, 5, 2},
+		{RoundHalfEven, 15, 8},
+		{RoundHalfUp, 5, 3},
+		{RoundHalfUp, -5, -3},
+		{RoundDown, 7, 3},
+		{RoundUp, 3, 2},
+	}
+	for _, c := range cases {
+		rates, err := ParseRates([]byte(`{"base": "EUR", "rounding": "` + string(c.rounding) + `", "rates": {"USD": "0.5"}}`))
+		require.NoError(t, err)
+		m, err := rates.Convert(New(c.amount, "EUR"), "USD")
+		require.NoError(t, err)
+		require.Equal(t, c.expected, m.Amount, "%s %d", c.rounding, c.amount)
+	}
+}
+
+func TestParseRatesErrors(t *testing.T) {
+	_, err := ParseRates([]byte(`{"base": "XXX"}`))
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+	_, err = ParseRates([]byte(`{"base": "EUR", "rates": {"USD": "-1"}}`))
+	require.Error(t, err)
+	_, err = ParseRates([]byte(`{"base": "EUR", "rounding": "banker"}`))
+	require.Error(t, err)
+}
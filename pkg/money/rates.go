@@ -0,0 +1,165 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+type Rounding string
+
+const (
+	RoundHalfUp   Rounding = "half-up"
+	RoundHalfEven Rounding = "half-even"
+	RoundDown     Rounding = "down"
+	RoundUp       Rounding = "up"
+)
+
+// Rates is an exchange rate table. Every rate is the value of one unit of the base currency in the
+// target currency.
+type Rates struct {
+	Base     string
+	Rounding Rounding
+	rates    map[string]*big.Rat
+}
+
+type ratesFile struct {
+	Base     string            `json:"base"`
+	Rounding Rounding          `json:"rounding"`
+	Rates    map[string]string `json:"rates"`
+}
+
+// DefaultRates returns a fixed exchange rate table with EUR as base currency.
+func DefaultRates() *Rates {
+	rates, err := ParseRates([]byte(`{
+		"base": "EUR",
+		"rounding": "half-even",
+		"rates": {
+			"USD": "1.08",
+			"GBP": "0.86",
+			"CHF": "0.97",
+			"JPY": "157.5",
+			"SEK": "11.45",
+			"PLN": "4.35"
+		}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	return rates
+}
+
+// LoadRates reads an exchange rate table in JSON format from a file.
+func LoadRates(path string) (*Rates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRates(data)
+}
+
+// ParseRates parses an exchange rate table in JSON format. Rates are decimal strings to avoid
+// floating point errors, e.g. {"base": "EUR", "rounding": "half-even", "rates": {"USD": "1.08"}}.
+func ParseRates(data []byte) (*Rates, error) {
+	var f ratesFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	base := strings.ToUpper(f.Base)
+	if !ValidCurrency(base) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, f.Base)
+	}
+	switch f.Rounding {
+	case "":
+		f.Rounding = RoundHalfEven
+	case RoundHalfUp, RoundHalfEven, RoundDown, RoundUp:
+	default:
+		return nil, fmt.Errorf("unknown rounding rule: %q", f.Rounding)
+	}
+	rates := &Rates{
+		Base:     base,
+		Rounding: f.Rounding,
+		rates:    map[string]*big.Rat{base: big.NewRat(1, 1)},
+	}
+	for currency, value := range f.Rates {
+		currency = strings.ToUpper(currency)
+		if !ValidCurrency(currency) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate for %s: %q", currency, value)
+		}
+		rates.rates[currency] = rate
+	}
+	return rates, nil
+}
+
+// Currencies returns all currencies that can be converted.
+func (r *Rates) Currencies() []string {
+	currencies := make([]string, 0, len(r.rates))
+	for currency := range r.rates {
+		currencies = append(currencies, currency)
+	}
+	return currencies
+}
+
+// Supports reports whether the table contains a rate for the currency.
+func (r *Rates) Supports(currency string) bool {
+	_, ok := r.rates[strings.ToUpper(currency)]
+	return ok
+}
+
+// Convert converts the amount into the target currency using the rounding rule of the table.
+func (r *Rates) Convert(m Money, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	if m.Currency == currency {
+		return m, nil
+	}
+	fromRate, ok := r.rates[m.Currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, m.Currency)
+	}
+	toRate, ok := r.rates[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	fromDigits, _ := MinorUnits(m.Currency)
+	toDigits, _ := MinorUnits(currency)
+
+	// amount * toRate / fromRate * 10^(toDigits - fromDigits)
+	v := new(big.Rat).SetInt64(m.Amount)
+	v.Mul(v, toRate)
+	v.Quo(v, fromRate)
+	v.Mul(v, new(big.Rat).SetInt64(pow10(toDigits)))
+	v.Quo(v, new(big.Rat).SetInt64(pow10(fromDigits)))
+	return Money{Amount: round(v, r.Rounding), Currency: currency}, nil
+}
+
+func round(v *big.Rat, rounding Rounding) int64 {
+	num, denom := v.Num(), v.Denom()
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.Int64()
+	}
+	// QuoRem truncates towards zero, so the remainder has the sign of the numerator
+	away := big.NewInt(int64(num.Sign()))
+	switch rounding {
+	case RoundDown:
+		return quo.Int64()
+	case RoundUp:
+		return quo.Add(quo, away).Int64()
+	}
+	// compare 2*|rem| with the denominator to find out if we are above, below or at half
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	switch cmp := twiceRem.Cmp(denom); {
+	case cmp > 0:
+		quo.Add(quo, away)
+	case cmp == 0 && (rounding == RoundHalfUp || quo.Bit(0) == 1):
+		quo.Add(quo, away)
+	}
+	return quo.Int64()
+}
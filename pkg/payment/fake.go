@@ -0,0 +1,122 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/google/uuid"
+)
+
+const (
+	// FakeTokenDecline is declined by the Fake provider.
+	FakeTokenDecline = "tok_decline"
+	// FakeTokenFailure fails with a provider error in the Fake provider.
+	FakeTokenFailure = "tok_failure"
+)
+
+type FakePayment struct {
+	ID        string
+	Reference string
+	Amount    money.Money
+	Captured  bool
+	Voided    bool
+	Refunded  money.Money
+}
+
+// Fake is an in-memory Provider for local development and tests. Every token is accepted except
+// FakeTokenDecline and FakeTokenFailure.
+type Fake struct {
+	mu       sync.Mutex
+	payments map[string]*FakePayment
+}
+
+func NewFake() *Fake {
+	return &Fake{payments: make(map[string]*FakePayment)}
+}
+
+func (f *Fake) Authorize(_ context.Context, amount money.Money, token, reference string) (string, error) {
+	switch {
+	case token == "":
+		return "", ErrMissingToken
+	case token == FakeTokenDecline:
+		return "", ErrDeclined
+	case token == FakeTokenFailure:
+		return "", ErrProviderFailure
+	case amount.Amount < 0:
+		return "", ErrInvalidAmount
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := &FakePayment{
+		ID:        uuid.NewString(),
+		Reference: reference,
+		Amount:    amount,
+		Refunded:  money.Zero(amount.Currency),
+	}
+	f.payments[p.ID] = p
+	return p.ID, nil
+}
+
+func (f *Fake) Capture(_ context.Context, authorizationID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[authorizationID]
+	if !ok {
+		return ErrNotFound
+	}
+	if p.Captured {
+		return fmt.Errorf("%w: already captured", ErrInvalidState)
+	}
+	if p.Voided {
+		return fmt.Errorf("%w: voided", ErrInvalidState)
+	}
+	p.Captured = true
+	return nil
+}
+
+func (f *Fake) Void(_ context.Context, authorizationID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[authorizationID]
+	if !ok {
+		return ErrNotFound
+	}
+	if p.Captured {
+		return fmt.Errorf("%w: already captured", ErrInvalidState)
+	}
+	p.Voided = true
+	return nil
+}
+
+func (f *Fake) Refund(_ context.Context, authorizationID string, amount money.Money) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[authorizationID]
+	if !ok {
+		return ErrNotFound
+	}
+	if !p.Captured {
+		return fmt.Errorf("%w: not captured", ErrInvalidState)
+	}
+	if amount.Currency != p.Amount.Currency || amount.Amount < 0 {
+		return ErrInvalidAmount
+	}
+	if p.Refunded.Amount+amount.Amount > p.Amount.Amount {
+		return ErrRefundTooLarge
+	}
+	p.Refunded = p.Refunded.Add(amount)
+	return nil
+}
+
+// Payment returns a copy of the payment with the authorization id.
+func (f *Fake) Payment(authorizationID string) (FakePayment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[authorizationID]
+	if !ok {
+		return FakePayment{}, false
+	}
+	return *p, true
+}
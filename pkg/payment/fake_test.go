@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+
+	_, err := f.Authorize(ctx, money.New(100, "EUR"), FakeTokenDecline, "booking")
+	require.ErrorIs(t, err, ErrDeclined)
+
+	id, err := f.Authorize(ctx, money.New(1000, "EUR"), "tok_visa", "booking")
+	require.NoError(t, err)
+	require.ErrorIs(t, f.Refund(ctx, id, money.New(100, "EUR")), ErrInvalidState)
+
+	require.NoError(t, f.Capture(ctx, id))
+	require.ErrorIs(t, f.Capture(ctx, id), ErrInvalidState)
+
+	require.NoError(t, f.Refund(ctx, id, money.New(600, "EUR")))
+	require.ErrorIs(t, f.Refund(ctx, id, money.New(600, "EUR")), ErrRefundTooLarge)
+	require.ErrorIs(t, f.Refund(ctx, id, money.New(100, "USD")), ErrInvalidAmount)
+
+	p, ok := f.Payment(id)
+	require.True(t, ok)
+	require.True(t, p.Captured)
+	require.Equal(t, money.New(600, "EUR"), p.Refunded)
+	require.Equal(t, "booking", p.Reference)
+
+	voided, err := f.Authorize(ctx, money.New(500, "EUR"), "tok_visa", "booking")
+	require.NoError(t, err)
+	require.NoError(t, f.Void(ctx, voided))
+	require.ErrorIs(t, f.Capture(ctx, voided), ErrInvalidState)
+	require.ErrorIs(t, f.Void(ctx, id), ErrInvalidState)
+	p, _ = f.Payment(voided)
+	require.True(t, p.Voided)
+}
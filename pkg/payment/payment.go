@@ -0,0 +1,32 @@
+package payment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+)
+
+var (
+	ErrDeclined        = errors.New("payment declined")
+	ErrNotFound        = errors.New("payment not found")
+	ErrInvalidState    = errors.New("invalid payment state")
+	ErrRefundTooLarge  = errors.New("refund exceeds captured amount")
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrMissingToken    = errors.New("missing payment token")
+	ErrProviderFailure = errors.New("payment provider failure")
+)
+
+// Provider is a payment service provider. Payments are authorized when a booking is created and
+// captured once the booking is confirmed.
+type Provider interface {
+	// Authorize reserves the amount on the payment method identified by token and returns the
+	// authorization id. The reference is the id of the booking.
+	Authorize(ctx context.Context, amount money.Money, token, reference string) (string, error)
+	// Capture collects the authorized amount.
+	Capture(ctx context.Context, authorizationID string) error
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, authorizationID string) error
+	// Refund pays back (a part of) the captured amount.
+	Refund(ctx context.Context, authorizationID string, amount money.Money) error
+}
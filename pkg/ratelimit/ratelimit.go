@@ -0,0 +1,289 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+
+	defaultMaxKeys = 10000
+)
+
+// Rate allows Limit requests per Window, which is also the burst size. A zero limit disables the rate limit.
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRate parses a rate like "60/1m". "0" disables the rate limit.
+func ParseRate(s string) (Rate, error) {
+	if s == "0" {
+		return Rate{}, nil
+	}
+	limitText, windowText, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected <limit>/<window> like 60/1m", s)
+	}
+	limit, err := strconv.Atoi(limitText)
+	if err != nil || limit < 0 {
+		return Rate{}, fmt.Errorf("invalid limit of rate %q", s)
+	}
+	window, err := time.ParseDuration(windowText)
+	if err != nil || window <= 0 {
+		return Rate{}, fmt.Errorf("invalid window of rate %q", s)
+	}
+	if limit == 0 {
+		return Rate{}, nil
+	}
+	return Rate{Limit: limit, Window: window}, nil
+}
+
+func (r Rate) Enabled() bool {
+	return r.Limit > 0 && r.Window > 0
+}
+
+func (r Rate) String() string {
+	if !r.Enabled() {
+		return "0"
+	}
+	// 1m0s is written as 1m
+	window := r.Window.String()
+	if strings.HasSuffix(window, "m0s") {
+		window = strings.TrimSuffix(window, "0s")
+	}
+	if strings.HasSuffix(window, "h0m") {
+		window = strings.TrimSuffix(window, "0m")
+	}
+	return fmt.Sprintf("%d/%s", r.Limit, window)
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	rate, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// perSecond returns the number of tokens that are added to a bucket per second.
+func (r Rate) perSecond() float64 {
+	return float64(r.Limit) / r.Window.Seconds()
+}
+
+// Result is the state of the bucket of a key after a request.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again, RetryAfter the time until the next request is allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Opts represents a set of rate limiting options.
+type Opts struct {
+	Rate Rate
+	// KeyFn returns the key the requests are counted by, it defaults to KeyByIP.
+	KeyFn func(r *http.Request) string
+	// MaxKeys bounds the number of tracked keys, the least recently used key is evicted. It defaults to 10000.
+	MaxKeys int
+	// LimitedFn writes the response of a limited request, it defaults to a plain text error.
+	LimitedFn func(w http.ResponseWriter, r *http.Request)
+	Clock     clock.Clock
+}
+
+// Limiter is a token bucket rate limiter per key. Every key has a bucket of Rate.Limit tokens that is
+// refilled continuously within Rate.Window, every request takes one token.
+type Limiter struct {
+	keyFn     func(r *http.Request) string
+	limitedFn func(w http.ResponseWriter, r *http.Request)
+	clock     clock.Clock
+	maxKeys   int
+
+	mu      sync.Mutex
+	rate    Rate
+	buckets map[string]*list.Element
+	// lru orders the buckets from the most to the least recently used
+	lru *list.List
+}
+
+func New(opts Opts) *Limiter {
+	l := &Limiter{
+		keyFn:     opts.KeyFn,
+		limitedFn: opts.LimitedFn,
+		clock:     opts.Clock,
+		maxKeys:   opts.MaxKeys,
+		rate:      opts.Rate,
+		buckets:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	if l.keyFn == nil {
+		l.keyFn = KeyByIP
+	}
+	if l.limitedFn == nil {
+		l.limitedFn = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	}
+	if l.clock == nil {
+		l.clock = clock.Real()
+	}
+	if l.maxKeys < 1 {
+		l.maxKeys = defaultMaxKeys
+	}
+	return l
+}
+
+// SetRate changes the rate of the limiter and resets all buckets.
+func (l *Limiter) SetRate(rate Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.buckets = make(map[string]*list.Element)
+	l.lru.Init()
+}
+
+func (l *Limiter) Rate() Rate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Keys returns the number of tracked keys.
+func (l *Limiter) Keys() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// getBucket returns the bucket of the key and marks it as most recently used. A new bucket is full.
+func (l *Limiter) getBucket(key string, now time.Time) *bucket {
+	if el, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(el)
+		return el.Value.(*bucket)
+	}
+	b := &bucket{key: key, tokens: float64(l.rate.Limit), last: now}
+	l.buckets[key] = l.lru.PushFront(b)
+	for l.lru.Len() > l.maxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+	return b
+}
+
+// Allow takes a token from the bucket of the key if one is left.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.rate.Enabled() {
+		return Result{Allowed: true}
+	}
+	now := l.clock.Now()
+	perSecond := l.rate.perSecond()
+	capacity := float64(l.rate.Limit)
+
+	b := l.getBucket(key, now)
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*perSecond)
+		b.last = now
+	}
+	res := Result{Limit: l.rate.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsDuration((1 - b.tokens) / perSecond)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = secondsDuration((capacity - b.tokens) / perSecond)
+	return res
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds rounds the duration up to full seconds for the headers.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Handler limits the requests per key, limited requests are answered with 429 Too Many Requests and a
+// Retry-After header. All responses contain the RateLimit headers of the IETF draft.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Allow(l.keyFn(r))
+		if res.Limit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rate := l.Rate()
+		header := w.Header()
+		header.Set(HeaderLimit, strconv.Itoa(res.Limit))
+		header.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+		header.Set(HeaderReset, ceilSeconds(res.Reset))
+		header.Set(HeaderPolicy, fmt.Sprintf("%d;w=%s", rate.Limit, ceilSeconds(rate.Window)))
+		if !res.Allowed {
+			header.Set(HeaderRetryAfter, ceilSeconds(res.RetryAfter))
+			l.limitedFn(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Limit is a middleware that limits the requests per client IP address to the rate.
+func Limit(rate Rate) func(http.Handler) http.Handler {
+	return LimitWithOpts(Opts{Rate: rate})
+}
+
+// LimitWithOpts is a middleware that limits the requests using the passed Opts.
+func LimitWithOpts(opts Opts) func(http.Handler) http.Handler {
+	return New(opts).Handler
+}
+
+// KeyByIP returns the IP address of the client. Behind a proxy the remote address has to be set to the client
+// IP address in front of it, but only for requests of trusted proxies: forwarded headers of other clients
+// would give them a new key with every request.
+func KeyByIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// KeyByUserOrIP returns the basic auth user or the IP address of the client. The user is not verified, so
+// it must only be used behind an authentication middleware.
+func KeyByUserOrIP(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return "user:" + user
+	}
+	return KeyByIP(r)
+}
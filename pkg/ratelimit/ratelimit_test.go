@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ParseRate("60/1m")
+	require.NoError(t, err)
+	require.Equal(t, Rate{Limit: 60, Window: time.Minute}, rate)
+	require.Equal(t, "60/1m", rate.String())
+	require.Equal(t, "5/1h", Rate{Limit: 5, Window: time.Hour}.String())
+	require.Equal(t, "10/1s", Rate{Limit: 10, Window: time.Second}.String())
+
+	rate, err = ParseRate("0")
+	require.NoError(t, err)
+	require.False(t, rate.Enabled())
+	require.Equal(t, "0", rate.String())
+
+	for _, s := range []string{"", "60", "x/1m", "-1/1m", "60/x", "60/0s"} {
+		_, err := ParseRate(s)
+		require.Error(t, err, s)
+	}
+
+	var r Rate
+	require.NoError(t, r.UnmarshalText([]byte("100/10s")))
+	require.Equal(t, Rate{Limit: 100, Window: 10 * time.Second}, r)
+}
+
+func TestAllow(t *testing.T) {
+	c := clock.NewFake(time.Date(2022, time.July, 5, 12, 0, 0, 0, time.UTC))
+	l := New(Opts{Rate: Rate{Limit: 3, Window: 3 * time.Second}, Clock: c})
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		res := l.Allow("a")
+		require.True(t, res.Allowed)
+		require.Equal(t, remaining, res.Remaining)
+	}
+	res := l.Allow("a")
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+	require.Equal(t, 3*time.Second, res.Reset)
+
+	// other keys have their own bucket
+	require.True(t, l.Allow("b").Allowed)
+
+	// one token is added per second
+	c.Advance(time.Second)
+	require.True(t, l.Allow("a").Allowed)
+	require.False(t, l.Allow("a").Allowed)
+
+	// the bucket does not exceed its limit
+	c.Advance(time.Hour)
+	require.Equal(t, 2, l.Allow("a").Remaining)
+
+	l.SetRate(Rate{})
+	require.Equal(t, 0, l.Keys())
+	require.Equal(t, Result{Allowed: true}, l.Allow("a"))
+}
+
+func TestEviction(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	l := New(Opts{Rate: Rate{Limit: 1, Window: time.Minute}, MaxKeys: 2, Clock: c})
+	require.True(t, l.Allow("a").Allowed)
+	require.True(t, l.Allow("b").Allowed)
+	require.False(t, l.Allow("a").Allowed)
+
+	// b is the least recently used key and evicted
+	require.True(t, l.Allow("c").Allowed)
+	require.Equal(t, 2, l.Keys())
+	require.True(t, l.Allow("b").Allowed)
+	// a was evicted by b
+	require.True(t, l.Allow("a").Allowed)
+}
+
+func TestHandler(t *testing.T) {
+	c := clock.NewFake(time.Now())
+	h := LimitWithOpts(Opts{Rate: Rate{Limit: 2, Window: time.Minute}, KeyFn: KeyByUserOrIP, Clock: c})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if user != "" {
+			req.SetBasicAuth(user, "pw")
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	res := send("alice")
+	require.Equal(t, http.StatusNoContent, res.Code)
+	require.Equal(t, "2", res.Header().Get(HeaderLimit))
+	require.Equal(t, "1", res.Header().Get(HeaderRemaining))
+	require.Equal(t, "30", res.Header().Get(HeaderReset))
+	require.Equal(t, "2;w=60", res.Header().Get(HeaderPolicy))
+	require.Empty(t, res.Header().Get(HeaderRetryAfter))
+
+	require.Equal(t, http.StatusNoContent, send("alice").Code)
+	res = send("alice")
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	require.Equal(t, "0", res.Header().Get(HeaderRemaining))
+	require.Equal(t, "30", res.Header().Get(HeaderRetryAfter))
+
+	// requests without user are limited by their IP address
+	require.Equal(t, http.StatusNoContent, send("bob").Code)
+	require.Equal(t, http.StatusNoContent, send("").Code)
+	require.Equal(t, http.StatusNoContent, send("").Code)
+	require.Equal(t, http.StatusTooManyRequests, send("").Code)
+}
+
+func TestHandlerDisabled(t *testing.T) {
+	h := Limit(Rate{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Empty(t, rr.Header().Get(HeaderLimit))
+	}
+}
+
+func TestKeyByIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	for addr, key := range map[string]string{
+		"192.0.2.1:1234": "ip:192.0.2.1",
+		"[::1]:1234":     "ip:::1",
+		"192.0.2.1":      "ip:192.0.2.1",
+	} {
+		req.RemoteAddr = addr
+		require.Equal(t, key, KeyByIP(req), fmt.Sprintf("remote address %s", addr))
+	}
+}
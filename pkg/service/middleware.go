@@ -1,15 +1,25 @@
 package service
 
-import "net/http"
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
 
 func (s *Service) recoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if r := recover(); r != nil {
-				if err, ok := r.(error); ok {
-					s.sendError(w, err.Error(), http.StatusInternalServerError)
+			if rec := recover(); rec != nil {
+				if err, ok := rec.(error); ok {
+					s.sendError(w, r, err.Error(), http.StatusInternalServerError)
 				} else {
-					s.log.Errorf("panic: %v", r)
+					s.logger(r.Context()).Errorf("panic: %v", rec)
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 			}
@@ -17,3 +27,133 @@ func (s *Service) recoverMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// trustedProxy reports whether the address is one of the TrustedProxies.
+func (s *Service) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.TrustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP returns the client IP address of a request that was forwarded by a trusted proxy. The
+// X-Forwarded-For header is read from the right, the first address that is not a trusted proxy is the client.
+func (s *Service) forwardedIP(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !s.trustedProxy(peer) {
+		return netip.Addr{}, false
+	}
+	for _, header := range []string{"True-Client-IP", "X-Real-IP"} {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(header))); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !s.trustedProxy(addr) || i == 0 {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// realIPMiddleware replaces the remote address of requests from trusted proxies with the client IP address
+// of the forwarded headers. The headers of other requests are ignored, as every client can set them.
+func (s *Service) realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := s.forwardedIP(r); ok {
+			r.RemoteAddr = addr.String()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendRateLimited answers requests that exceeded their rate limit, the rate limit headers are already set.
+func (s *Service) sendRateLimited(w http.ResponseWriter, r *http.Request) {
+	s.sendError(w, r, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// routePattern returns the route pattern that matched the request or "unmatched". It must be called after
+// the request was routed.
+func routePattern(r *http.Request) string {
+	if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+// requestMethod returns the method of the request or "other" for non-standard methods, which clients can
+// choose freely.
+func requestMethod(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	}
+	return "other"
+}
+
+// responseStatus returns the status code that was written, handlers that write no header respond with 200.
+func responseStatus(ww middleware.WrapResponseWriter) int {
+	if status := ww.Status(); status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
+
+// accessLogMiddleware logs every request once it was handled. Successful requests are sampled with
+// AccessLogSampleRate, requests that failed with a status of 400 or above are always logged.
+func (s *Service) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := responseStatus(ww)
+		if status < http.StatusBadRequest && (s.AccessLogSampleRate <= 0 ||
+			(s.AccessLogSampleRate < 1 && rand.Float64() >= s.AccessLogSampleRate)) {
+			return
+		}
+		// the request ID, trace ID, user and route are added by the logger of the request
+		log := s.logger(r.Context())
+		fields := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remoteAddr", r.RemoteAddr,
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			log.Errorw("request", fields...)
+		case status >= http.StatusBadRequest:
+			log.Warnw("request", fields...)
+		default:
+			log.Infow("request", fields...)
+		}
+	})
+}
+
+// clientCertMiddleware rejects requests without a verified TLS client certificate if RequireAdminClientCert
+// is set. The certificate is verified by the TLS server against its client CAs.
+func (s *Service) clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.RequireAdminClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			s.sendError(w, r, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
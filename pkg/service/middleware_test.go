@@ -1,12 +1,22 @@
 package service
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"testing"
+	"time"
 
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/ratelimit"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestRecoverMiddleware(t *testing.T) {
@@ -29,3 +39,167 @@ func TestRecoverMiddlewareErrorType(t *testing.T) {
 	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &m))
 	require.Equal(t, "test", m["error"])
 }
+
+func TestAccessLogMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s = New(&logger.Logger{SugaredLogger: zap.New(core).Sugar()}, s.db)
+	s.Auth[testUser[0]] = testUser[1]
+	requests := func() []observer.LoggedEntry {
+		entries := make([]observer.LoggedEntry, 0)
+		for _, entry := range logs.TakeAll() {
+			if entry.Message == "request" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	res := sendRequest(s, "GET", "/flights/"+findFlightID(s.db), nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	entries := requests()
+	require.Len(t, entries, 1)
+	require.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	fields := entries[0].ContextMap()
+	require.Equal(t, "/flights/{id}", fields["route"])
+	require.Equal(t, int64(http.StatusOK), fields["status"])
+	require.Equal(t, int64(res.Body.Len()), fields["bytes"])
+	require.Equal(t, "user", fields["user"])
+	require.NotEmpty(t, fields["requestId"])
+	require.NotEmpty(t, fields["traceId"])
+	require.IsType(t, time.Duration(0), fields["duration"])
+
+	res = sendRequest(s, "GET", "/bookings", nil)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	entries = requests()
+	require.Len(t, entries, 1)
+	require.Equal(t, zapcore.WarnLevel, entries[0].Level)
+	require.Equal(t, "/bookings/", entries[0].ContextMap()["route"])
+
+	// successful requests are sampled, errors are always logged
+	s.AccessLogSampleRate = 0
+	sendRequest(s, "GET", "/destinations", nil)
+	sendRequest(s, "GET", "/unknown", nil)
+	entries = requests()
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(http.StatusNotFound), entries[0].ContextMap()["status"])
+	require.Equal(t, "unmatched", entries[0].ContextMap()["route"])
+}
+
+func TestRequestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s = New(&logger.Logger{SugaredLogger: zap.New(core).Sugar()}, s.db)
+	s.Auth[testUser[0]] = testUser[1]
+
+	res := sendRequest(s, "GET", "/bookings/unknown/refund-quote", nil, setBasicAuth)
+	require.Equal(t, http.StatusNotFound, res.Code)
+	entries := logs.FilterMessageSnippet("error(code=404)").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.NotEmpty(t, fields["requestId"])
+	require.NotEmpty(t, fields["traceId"])
+	require.Equal(t, "user", fields["user"])
+	require.Equal(t, "/bookings/{id}/refund-quote", fields["route"])
+}
+
+func TestAdminClientCert(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	res := sendRequest(s, "GET", "/admin/loglevel", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	s.RequireAdminClientCert = true
+	res = sendRequest(s, "GET", "/admin/loglevel", nil, setAdminAuth)
+	require.Equal(t, http.StatusForbidden, res.Code)
+	res = sendRequest(s, "GET", "/admin/loglevel", nil, setAdminAuth, func(req *http.Request) {
+		req.TLS = &tls.ConnectionState{}
+	})
+	require.Equal(t, http.StatusForbidden, res.Code)
+
+	verified := func(req *http.Request) {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	}
+	res = sendRequest(s, "GET", "/admin/loglevel", nil, setAdminAuth, verified)
+	require.Equal(t, http.StatusOK, res.Code)
+	// the client certificate does not replace the basic auth
+	res = sendRequest(s, "GET", "/admin/loglevel", nil, verified)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	// other routes do not require a client certificate
+	res = sendRequest(s, "GET", "/destinations", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestRateLimit(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.SearchLimiter.SetRate(ratelimit.Rate{Limit: 2, Window: time.Minute})
+	s.BookingLimiter.SetRate(ratelimit.Rate{Limit: 1, Window: time.Minute})
+
+	res := sendRequest(s, "GET", "/destinations", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "1", res.Header().Get(ratelimit.HeaderRemaining))
+	require.Equal(t, http.StatusOK, sendRequest(s, "GET", "/flights", nil).Code)
+	res = sendRequest(s, "GET", "/flights", nil)
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	require.Equal(t, "30", res.Header().Get(ratelimit.HeaderRetryAfter))
+	require.Contains(t, res.Body.String(), "rate limit exceeded")
+	// the search limit is per IP address, the forwarded headers of untrusted clients are ignored
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("X-Real-IP", "198.51.100.1"))
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	s.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("X-Real-IP", "198.51.100.1"))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// the booking limit is per user and independent of the search limit
+	require.Equal(t, http.StatusOK, sendRequest(s, "GET", "/bookings", nil, setBasicAuth).Code)
+	require.Equal(t, http.StatusTooManyRequests, sendRequest(s, "GET", "/bookings", nil, setBasicAuth).Code)
+	// unauthenticated requests are rejected before they are counted
+	require.Equal(t, http.StatusUnauthorized, sendRequest(s, "GET", "/bookings", nil).Code)
+
+	// other routes are not limited
+	res = sendRequest(s, "GET", "/healthz", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Empty(t, res.Header().Get(ratelimit.HeaderLimit))
+}
+
+func TestRealIP(t *testing.T) {
+	s := &Service{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	var remoteAddr string
+	handler := s.realIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+	for _, tc := range []struct {
+		remoteAddr string
+		header     string
+		value      string
+		expected   string
+	}{
+		{"192.0.2.1:1234", "X-Forwarded-For", "198.51.100.1", "192.0.2.1:1234"},
+		{"192.0.2.1:1234", "X-Real-IP", "198.51.100.1", "192.0.2.1:1234"},
+		{"10.0.0.1:1234", "X-Real-IP", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "True-Client-IP", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "203.0.113.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "invalid", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1:1234"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, tc.expected, remoteAddr, "%s: %s", tc.header, tc.value)
+	}
+}
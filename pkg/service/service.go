@@ -1,29 +1,108 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
 	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/christophwitzko/flight-booking-service/pkg/payment"
+	"github.com/christophwitzko/flight-booking-service/pkg/ratelimit"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 type Service struct {
-	router *chi.Mux
-	log    *logger.Logger
-	db     *database.Database
-	Auth   map[string]string
+	router    *chi.Mux
+	log       *logger.Logger
+	db        *database.Database
+	Auth      map[string]string
+	AdminAuth map[string]string
+	Rates     *money.Rates
+	Payments  payment.Provider
+	// Tracer records the spans of the requests and their database operations.
+	Tracer *tracing.Tracer
+
+	// PaymentTimeout is the time a booking waits for payment before its seats are released.
+	PaymentTimeout time.Duration
+	// CheckinOpens and CheckinCloses define the check-in window relative to the departure.
+	CheckinOpens  time.Duration
+	CheckinCloses time.Duration
+	// WaitlistClaimWindow is the time a waitlisted user has to book an offered seat.
+	WaitlistClaimWindow time.Duration
+	// SeatHoldDuration is the time a seat is held during seat selection, MaxSeatHolds the number of seats a
+	// user can hold per flight.
+	SeatHoldDuration time.Duration
+	MaxSeatHolds     int
+	// JobInterval is the interval of the background jobs started with Run.
+	JobInterval time.Duration
+	// AccessLogSampleRate is the fraction of successful requests that are logged, failed requests are always logged.
+	AccessLogSampleRate float64
+	// EventHeartbeat is the interval of the heartbeat comments of event streams.
+	EventHeartbeat time.Duration
+	// EventStreamTimeout is the maximum duration of an event stream, it must be below the write timeout of the server.
+	EventStreamTimeout time.Duration
+	// RequireAdminClientCert restricts the admin routes to requests with a verified TLS client certificate.
+	RequireAdminClientCert bool
+	// SearchLimiter limits the public flight and destination searches per client IP address, BookingLimiter
+	// the authenticated booking routes per user. Both are disabled until a rate is set.
+	SearchLimiter  *ratelimit.Limiter
+	BookingLimiter *ratelimit.Limiter
+	// TrustedProxies are the proxies whose forwarded headers set the client IP address, e.g. for the rate
+	// limits and the access log. Without trusted proxies the remote address of the connection is used.
+	TrustedProxies []netip.Prefix
+
+	metrics      *serviceMetrics
+	cache        *responseCache
+	stats        statsCache
+	streamsDone  chan struct{}
+	closeStreams sync.Once
+	startedAt    time.Time
+	seeded       atomic.Bool
+	shuttingDown atomic.Bool
 }
 
 func New(logger *logger.Logger, db *database.Database) *Service {
 	svc := &Service{
-		router: chi.NewRouter(),
-		log:    logger,
-		db:     db,
-		Auth:   make(map[string]string),
+		router:    chi.NewRouter(),
+		log:       logger,
+		db:        db,
+		Auth:      make(map[string]string),
+		AdminAuth: make(map[string]string),
+		Rates:     money.DefaultRates(),
+		Payments:  payment.NewFake(),
+		Tracer:    tracing.NewTracer(nil),
+
+		PaymentTimeout:      15 * time.Minute,
+		CheckinOpens:        24 * time.Hour,
+		CheckinCloses:       time.Hour,
+		WaitlistClaimWindow: 30 * time.Minute,
+		SeatHoldDuration:    10 * time.Minute,
+		MaxSeatHolds:        9,
+		JobInterval:         10 * time.Second,
+		AccessLogSampleRate: 1,
+		EventHeartbeat:      15 * time.Second,
+		EventStreamTimeout:  50 * time.Second,
+
+		metrics:     newServiceMetrics(),
+		cache:       newResponseCache(),
+		streamsDone: make(chan struct{}),
+		startedAt:   time.Now(),
 	}
+	svc.SearchLimiter = ratelimit.New(ratelimit.Opts{KeyFn: ratelimit.KeyByIP, LimitedFn: svc.sendRateLimited})
+	svc.BookingLimiter = ratelimit.New(ratelimit.Opts{KeyFn: ratelimit.KeyByUserOrIP, LimitedFn: svc.sendRateLimited})
+	db.SetObserver(svc.metrics.observeDatabase)
 	svc.setupMiddleware()
 	svc.setupRoutes()
 	return svc
@@ -31,60 +110,179 @@ func New(logger *logger.Logger, db *database.Database) *Service {
 
 func (s *Service) setupMiddleware() {
 	s.router.Use(middleware.RequestID)
-	s.router.Use(middleware.RealIP)
-	s.router.Use(middleware.Compress(5))
+	s.router.Use(s.realIPMiddleware)
+	s.router.Use(s.tracingMiddleware)
 	s.router.Use(s.log.Middleware)
+	s.router.Use(s.metricsMiddleware)
+	s.router.Use(s.accessLogMiddleware)
+	s.router.Use(middleware.Compress(5))
 	s.router.Use(s.recoverMiddleware)
 
 	s.router.Mount("/debug", middleware.Profiler())
+	s.router.Method(http.MethodGet, "/metrics", s.metrics.registry)
+}
+
+// logger returns the logger of the request or transaction context, background jobs use the logger of the service.
+func (s *Service) logger(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, s.log)
 }
 
-func (s *Service) sendError(w http.ResponseWriter, err string, code int) {
-	s.log.Warnf("error(code=%d): %s", code, err)
+func (s *Service) sendError(w http.ResponseWriter, r *http.Request, err string, code int) {
+	s.logger(r.Context()).Warnf("error(code=%d): %s", code, err)
 	w.WriteHeader(code)
-	s.writeJSON(w, map[string]string{"error": err})
+	s.writeJSON(w, r, map[string]string{"error": err})
+}
+
+// requestError can be returned from within a transaction to abort it with a specific response.
+type requestError struct {
+	code    int
+	message string
+}
+
+func newRequestError(code int, message string) error {
+	return &requestError{code: code, message: message}
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func (s *Service) sendValidationError(w http.ResponseWriter, r *http.Request, err *models.ValidationError) {
+	s.logger(r.Context()).Warnf("error(code=%d): %s", http.StatusBadRequest, err)
+	w.WriteHeader(http.StatusBadRequest)
+	s.writeJSON(w, r, map[string]any{"error": "validation failed", "fields": err.Fields})
+}
+
+// handleError sends the matching error response for err.
+func (s *Service) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var reqErr *requestError
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &reqErr):
+		s.sendError(w, r, reqErr.message, reqErr.code)
+	case errors.As(err, &validationErr):
+		s.sendValidationError(w, r, validationErr)
+	case errors.Is(err, badger.ErrConflict):
+		s.sendError(w, r, "conflicting update, please retry", http.StatusConflict)
+	case isPaymentError(err):
+		s.sendPaymentError(w, r, err)
+	default:
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// requestedCurrency returns the currency of the "currency" query parameter. An empty string is returned if no
+// currency was requested. If the currency is not supported an error is sent and false is returned.
+func (s *Service) requestedCurrency(w http.ResponseWriter, r *http.Request) (string, bool) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency != "" && !s.Rates.Supports(currency) {
+		s.sendError(w, r, "unsupported currency", http.StatusBadRequest)
+		return "", false
+	}
+	return currency, true
+}
+
+// convertPrices converts all prices in place into the currency.
+func convertPrices(rates *money.Rates, currency string, prices ...*money.Money) error {
+	for _, price := range prices {
+		converted, err := rates.Convert(*price, currency)
+		if err != nil {
+			return err
+		}
+		*price = converted
+	}
+	return nil
 }
 
 func (s *Service) contentTypeJSON(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 }
 
-func (s *Service) writeJSON(w http.ResponseWriter, d any) {
+func (s *Service) writeJSON(w http.ResponseWriter, r *http.Request, d any) {
 	s.contentTypeJSON(w)
 	err := json.NewEncoder(w).Encode(d)
 	if err != nil {
-		s.log.Errorf("json write error: %v", err)
+		s.logger(r.Context()).Errorf("json write error: %v", err)
 	}
 }
 
 func (s *Service) handlerNotFound(w http.ResponseWriter, r *http.Request) {
-	s.sendError(w, "not found", http.StatusNotFound)
+	s.sendError(w, r, "not found", http.StatusNotFound)
 }
 
 func (s *Service) handlerIndex(w http.ResponseWriter, r *http.Request) {
-	s.writeJSON(w, map[string]string{"service": "flight-booking-service"})
+	s.writeJSON(w, r, map[string]string{"service": "flight-booking-service"})
 }
 
 func (s *Service) setupRoutes() {
 	s.router.NotFound(s.handlerNotFound)
 
 	s.router.Get("/", s.handlerIndex)
+	s.router.Get("/healthz", s.handlerHealthz)
+	s.router.Get("/readyz", s.handlerReadyz)
+	s.router.Get("/status", s.handlerStatus)
 
 	s.router.
 		With(middleware.CleanPath).
 		Route("/flights", func(r chi.Router) {
-			r.Get("/", s.handlerGetFlights)
-			r.Get("/{id}", s.handlerGetFlight)
-			r.Get("/{id}/seats", s.handlerGetFlightSeats)
+			r.Group(func(r chi.Router) {
+				r.Use(s.SearchLimiter.Handler)
+				r.With(s.cacheMiddleware("flights", "from", "to", "status")).Get("/", s.handlerGetFlights)
+				r.Get("/{id}", s.handlerGetFlight)
+				r.Get("/{id}/seats", s.handlerGetFlightSeats)
+				r.Get("/{id}/events", s.handlerGetFlightEvents)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.BasicAuth("auth", s.Auth))
+				r.Use(s.BookingLimiter.Handler)
+				r.Get("/{id}/waitlist", s.handlerGetWaitlist)
+				r.Post("/{id}/waitlist", s.handlerJoinWaitlist)
+				r.Delete("/{id}/waitlist/{entryId}", s.handlerLeaveWaitlist)
+				r.Get("/{id}/seat-selection", s.handlerSeatSelection)
+			})
 		})
 
-	s.router.Get("/destinations", s.handlerGetDestinations)
+	s.router.With(s.SearchLimiter.Handler, s.cacheMiddleware("flights")).Get("/destinations", s.handlerGetDestinations)
 
-	s.router.
-		With(middleware.BasicAuth("auth", s.Auth)).
-		Route("/bookings", func(r chi.Router) {
+	s.router.Route("/bookings", func(r chi.Router) {
+		r.With(s.SearchLimiter.Handler).Get("/by-reference/{pnr}", s.handlerGetBookingByReference)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.BasicAuth("auth", s.Auth))
+			r.Use(s.BookingLimiter.Handler)
 			r.Get("/", s.handlerGetBookings)
 			r.Post("/", s.handlerCreateBooking)
+			r.Post("/quote", s.handlerQuoteBooking)
+			r.Post("/{id}/confirm", s.handlerConfirmBooking)
+			r.Post("/{id}/cancel", s.handlerCancelBooking)
+			r.Get("/{id}/refund-quote", s.handlerGetRefundQuote)
+			r.Patch("/{id}", s.handlerModifyBooking)
+			r.Post("/{id}/checkin", s.handlerCheckin)
+			r.Get("/{id}/boarding-pass", s.handlerGetBoardingPass)
+		})
+	})
+
+	s.router.
+		With(s.clientCertMiddleware, middleware.BasicAuth("admin", s.AdminAuth)).
+		Route("/admin", func(r chi.Router) {
+			r.Get("/promocodes", s.handlerGetPromoCodes)
+			r.Get("/promocodes/{code}", s.handlerGetPromoCode)
+			r.Put("/promocodes/{code}", s.handlerPutPromoCode)
+			r.Delete("/promocodes/{code}", s.handlerDeletePromoCode)
+			r.Get("/oversold", s.handlerGetOversoldFlights)
+			r.Get("/flights/{id}/oversold", s.handlerGetOversoldFlight)
+			r.Put("/flights/{id}/overbooking", s.handlerPutOverbookingLimit)
+			r.Post("/flights/{id}/denied-boardings", s.handlerDenyBoarding)
+			r.Get("/rebookings", s.handlerGetRebookings)
+			r.Get("/loglevel", s.handlerGetLogLevel)
+			r.Put("/loglevel", s.handlerPutLogLevel)
+			r.Get("/webhooks", s.handlerGetWebhooks)
+			r.Post("/webhooks", s.handlerCreateWebhook)
+			r.Get("/webhooks/{id}", s.handlerGetWebhook)
+			r.Delete("/webhooks/{id}", s.handlerDeleteWebhook)
+			r.Get("/webhooks/{id}/deliveries", s.handlerGetWebhookDeliveries)
+			r.Post("/webhooks/{id}/deliveries/{deliveryId}/retry", s.handlerRetryWebhookDelivery)
 		})
 }
 
@@ -2,72 +2,304 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/google/uuid"
 )
 
+// Errors of booking requests that are counted as booking outcomes.
+var (
+	errFlightNotFound   = newRequestError(http.StatusBadRequest, "could not find flight")
+	errSeatNotFound     = newRequestError(http.StatusBadRequest, "could not find seat")
+	errSeatNotAvailable = newRequestError(http.StatusBadRequest, "seat not available")
+	errSoldOut          = newRequestError(http.StatusConflict, "flight is sold out")
+	errSelectSeat       = newRequestError(http.StatusConflict, "seats available in this cabin, please select a seat")
+)
+
+// bookingQuote is the priced result of a booking request.
+type bookingQuote struct {
+	flight    *models.Flight
+	seats     []*models.Seat
+	seatless  int
+	promoCode *models.PromoCode
+
+	BasePrice money.Money `json:"basePrice"`
+	Discount  money.Money `json:"discount"`
+	Price     money.Money `json:"price"`
+}
+
+// quoteBooking checks the availability of the requested seats and prices the booking request.
+func (s *Service) quoteBooking(txn *database.Txn, userID string, bookingRequest *models.Booking) (*bookingQuote, error) {
+	var flight models.Flight
+	if err := txn.Get(bookingRequest.FlightID, &flight); err != nil {
+		return nil, errFlightNotFound
+	}
+	now := time.Now()
+	if flight.Status == models.FlightStatusCancelled || !now.Before(flight.Departure) {
+		return nil, newRequestError(http.StatusConflict, "flight cannot be booked anymore")
+	}
+	if err := models.ValidatePassengers(bookingRequest.Passengers, flight.Departure); err != nil {
+		return nil, err
+	}
+
+	quote := &bookingQuote{
+		flight: &flight,
+		seats:  make([]*models.Seat, len(bookingRequest.Passengers)),
+	}
+	requested := make(map[string]bool)
+	for _, passenger := range bookingRequest.Passengers {
+		if passenger.Seat != "" {
+			requested[passenger.Seat] = true
+		}
+	}
+	// the seats of the flight are only loaded for passengers without a seat
+	var allSeats []*models.Seat
+	for i, passenger := range bookingRequest.Passengers {
+		if passenger.Seat == "" {
+			if allSeats == nil {
+				var err error
+				if allSeats, err = txnFlightSeats(txn, flight.ID); err != nil {
+					return nil, err
+				}
+			}
+			seat, err := cabinFare(allSeats, passenger.Cabin)
+			if err != nil {
+				return nil, err
+			}
+			// only sold out cabins are overbooked
+			if cabinSeatAvailable(allSeats, passenger.Cabin, userID, requested, now) {
+				return nil, errSelectSeat
+			}
+			quote.BasePrice = quote.BasePrice.Add(seat.Price)
+			quote.seats[i] = seat
+			quote.seatless++
+			continue
+		}
+		var seat models.Seat
+		key := fmt.Sprintf("%s/%s", flight.ID, passenger.Seat)
+		if err := txn.Get(key, &seat); err != nil {
+			return nil, errSeatNotFound
+		}
+		if !seat.AvailableFor(userID, now) {
+			return nil, errSeatNotAvailable
+		}
+		quote.BasePrice = quote.BasePrice.Add(seat.Price)
+		quote.seats[i] = &seat
+	}
+	if quote.seatless > 0 && flight.Oversold+quote.seatless > flight.OverbookingLimit {
+		return nil, errSoldOut
+	}
+
+	quote.Discount = money.Zero(quote.BasePrice.Currency)
+	if bookingRequest.PromoCode != "" {
+		promoCode, err := s.getApplicablePromoCode(txn, bookingRequest.PromoCode, userID, &flight)
+		if err != nil {
+			return nil, err
+		}
+		quote.Discount, err = promoCode.Discount(quote.BasePrice, s.Rates)
+		if err != nil {
+			return nil, err
+		}
+		quote.promoCode = promoCode
+	}
+	quote.Price = quote.BasePrice.Sub(quote.Discount)
+	return quote, nil
+}
+
+// getBooking loads the booking of the user inside the transaction.
+func getBooking(txn *database.Txn, userID, bookingID string) (*models.Booking, error) {
+	var booking models.Booking
+	if err := txn.Get(fmt.Sprintf("%s/%s", userID, bookingID), &booking); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, newRequestError(http.StatusNotFound, "booking not found")
+		}
+		return nil, err
+	}
+	return &booking, nil
+}
+
+func (s *Service) decodeBookingRequest(w http.ResponseWriter, r *http.Request) (*models.Booking, bool) {
+	var bookingRequest models.Booking
+	if err := json.NewDecoder(r.Body).Decode(&bookingRequest); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if len(bookingRequest.Passengers) == 0 {
+		s.sendError(w, r, "no passengers", http.StatusBadRequest)
+		return nil, false
+	}
+	return &bookingRequest, true
+}
+
 func (s *Service) handlerGetBookings(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
 	user, _, _ := r.BasicAuth()
-	bookings, err := database.Values[*models.Booking](s.db, user)
+	bookings, err := database.Values[*models.Booking](db, user)
 	if err != nil {
-		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	s.writeJSON(w, bookings)
+	if currency != "" {
+		for _, booking := range bookings {
+			if err := convertPrices(s.Rates, currency, &booking.BasePrice, &booking.Discount, &booking.Price); err != nil {
+				s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	s.writeJSON(w, r, bookings)
 }
 
-func (s *Service) handlerCreateBooking(w http.ResponseWriter, r *http.Request) {
+func (s *Service) handlerQuoteBooking(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
 	userID, _, _ := r.BasicAuth()
-	var bookingRequest models.Booking
-	if err := json.NewDecoder(r.Body).Decode(&bookingRequest); err != nil {
-		s.sendError(w, err.Error(), http.StatusBadRequest)
+	bookingRequest, ok := s.decodeBookingRequest(w, r)
+	if !ok {
 		return
 	}
-	if len(bookingRequest.Passengers) == 0 {
-		s.sendError(w, "no passengers", http.StatusBadRequest)
+	var quote *bookingQuote
+	err := db.View(func(txn *database.Txn) error {
+		var err error
+		quote, err = s.quoteBooking(txn, userID, bookingRequest)
+		return err
+	})
+	if err != nil {
+		s.handleError(w, r, err)
 		return
 	}
-	var flight models.Flight
-	if err := s.db.Get(bookingRequest.FlightID, &flight); err != nil {
-		s.sendError(w, "could not find flight", http.StatusBadRequest)
+	if currency != "" {
+		if err := convertPrices(s.Rates, currency, &quote.BasePrice, &quote.Discount, &quote.Price); err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writeJSON(w, r, quote)
+}
+
+func (s *Service) handlerCreateBooking(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
+	userID, _, _ := r.BasicAuth()
+	bookingRequest, ok := s.decodeBookingRequest(w, r)
+	if !ok {
 		return
 	}
+	var booking *models.Booking
+	err := db.Update(func(txn *database.Txn) error {
+		quote, err := s.quoteBooking(txn, userID, bookingRequest)
+		if err != nil {
+			return err
+		}
 
-	price := 0
-	updates := make([]database.Model, len(bookingRequest.Passengers)+1)
-	for i, passenger := range bookingRequest.Passengers {
-		var seat models.Seat
-		key := fmt.Sprintf("%s/%s", flight.ID, passenger.Seat)
-		if err := s.db.Get(key, &seat); err != nil {
-			s.sendError(w, "could not find seat", http.StatusBadRequest)
-			return
+		updates := make([]database.Model, 0, len(quote.seats)+3)
+		now := time.Now()
+		for i, seat := range quote.seats {
+			if seat.Seat != "" {
+				if err := takeSeat(txn, userID, seat, now); err != nil {
+					return err
+				}
+				updates = append(updates, seat)
+			}
+			passenger := &bookingRequest.Passengers[i]
+			assignSeat(passenger, seat)
+			passenger.CheckedInAt = nil
+			passenger.BoardingGroup = ""
+			passenger.BoardingSequence = 0
 		}
-		if !seat.Available {
-			s.sendError(w, "seat not available", http.StatusBadRequest)
-			return
+		if quote.seatless > 0 {
+			quote.flight.Oversold += quote.seatless
+			updates = append(updates, quote.flight)
+		}
+		if quote.promoCode != nil {
+			quote.promoCode.Redeem(userID)
+			updates = append(updates, quote.promoCode)
 		}
-		price += seat.Price
-		seat.Available = false
-		updates[i] = &seat
-	}
 
-	booking := &models.Booking{
-		ID:         uuid.NewString(),
-		UserID:     userID,
-		FlightID:   flight.ID,
-		Price:      price,
-		Status:     "confirmed",
-		Passengers: bookingRequest.Passengers,
+		deadline := now.Add(s.PaymentTimeout)
+		booking = &models.Booking{
+			ID:              uuid.NewString(),
+			UserID:          userID,
+			FlightID:        quote.flight.ID,
+			BasePrice:       quote.BasePrice,
+			Discount:        quote.Discount,
+			Fees:            money.Zero(quote.Price.Currency),
+			Price:           quote.Price,
+			Status:          models.BookingStatusPendingPayment,
+			Passengers:      bookingRequest.Passengers,
+			PaymentDeadline: &deadline,
+		}
+		if quote.promoCode != nil {
+			booking.PromoCode = quote.promoCode.Code
+		}
+		if err := assignReference(txn, booking); err != nil {
+			return err
+		}
+		if err := emitEvent(txn, models.EventBookingCreated, booking); err != nil {
+			return err
+		}
+		return txn.Put(append(updates, booking)...)
+	})
+	if err != nil {
+		s.recordBooking(bookingOutcome(err))
+		s.handleError(w, r, err)
+		return
 	}
-	updates[len(updates)-1] = booking
 
-	if err := s.db.Put(updates...); err != nil {
-		s.sendError(w, err.Error(), http.StatusInternalServerError)
+	paymentID, err := s.Payments.Authorize(r.Context(), booking.Price, bookingRequest.PaymentToken, booking.ID)
+	if err != nil {
+		// release the seats right away instead of waiting for the payment deadline
+		if releaseErr := s.releaseBooking(r.Context(), userID, booking.ID, models.BookingStatusPaymentFailed); releaseErr != nil {
+			s.logger(r.Context()).Errorf("could not release booking %s: %v", booking.ID, releaseErr)
+		}
+		s.recordBooking(bookingOutcomePaymentFailed)
+		s.sendPaymentError(w, r, err)
+		return
+	}
+	bookingID := booking.ID
+	err = s.updateRetrying(r.Context(), func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, userID, bookingID)
+		if err != nil {
+			return err
+		}
+		booking.PaymentID = paymentID
+		return txn.Put(booking)
+	})
+	if err != nil {
+		// a booking without payment cannot be confirmed, so it is released together with the authorization
+		s.voidPayment(r.Context(), paymentID)
+		if releaseErr := s.releaseBooking(r.Context(), userID, bookingID, models.BookingStatusPaymentFailed); releaseErr != nil {
+			s.logger(r.Context()).Errorf("could not release booking %s: %v", bookingID, releaseErr)
+		}
+		s.recordBooking(bookingOutcomeError)
+		s.handleError(w, r, err)
+		return
 	}
 
-	s.writeJSON(w, booking)
+	if currency != "" {
+		if err := convertPrices(s.Rates, currency, &booking.BasePrice, &booking.Discount, &booking.Price); err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.recordBooking(bookingOutcomeCreated)
+	s.writeJSON(w, r, booking)
 }
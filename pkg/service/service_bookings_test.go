@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
@@ -42,13 +43,15 @@ func BenchmarkHandlerCreateBooking(b *testing.B) {
 	s := New(logger.NewNop(), db)
 
 	amountOfBookingRequests := 90000
+	departure := time.Now().Add(72 * time.Hour)
 	bookingRequests := make([]io.ReadCloser, amountOfBookingRequests)
 	for i := 0; i < amountOfBookingRequests; i++ {
 		flightID := strconv.Itoa(i)
-		_ = db.Put(&models.Flight{ID: flightID}, &models.Seat{FlightID: flightID, Seat: "A1", Available: true})
+		_ = db.Put(&models.Flight{ID: flightID, Departure: departure}, &models.Seat{FlightID: flightID, Seat: "A1", Available: true})
 		bookingRequest := &models.Booking{
-			FlightID:   flightID,
-			Passengers: []models.Passenger{{Name: "user", Seat: "A1"}},
+			FlightID:     flightID,
+			Passengers:   []models.Passenger{testPassenger("user", "test", "A1")},
+			PaymentToken: "tok_visa",
 		}
 		payload, _ := json.Marshal(bookingRequest)
 		bookingRequests[i] = io.NopCloser(bytes.NewReader(payload))
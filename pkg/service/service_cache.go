@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+)
+
+// maxCachedResponses bounds the number of cached responses per collection, e.g. for different search queries.
+const maxCachedResponses = 1000
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+type collectionCache struct {
+	version   database.Version
+	responses map[string]*cachedResponse
+}
+
+// responseCache keeps the successful responses of routes that only depend on one collection. The responses
+// of a collection are dropped once its version changed, so writes to the collection invalidate them.
+type responseCache struct {
+	mu          sync.Mutex
+	collections map[string]*collectionCache
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{collections: make(map[string]*collectionCache)}
+}
+
+// collection returns the cache of the collection at the version, the responses of older versions are dropped.
+// Nil is returned if a newer version is cached already. It must be called with the lock held.
+func (c *responseCache) collection(collection string, version database.Version) *collectionCache {
+	cc, ok := c.collections[collection]
+	if ok && cc.version.Seq > version.Seq {
+		return nil
+	}
+	if !ok || cc.version.Seq < version.Seq {
+		cc = &collectionCache{version: version, responses: make(map[string]*cachedResponse)}
+		c.collections[collection] = cc
+	}
+	return cc
+}
+
+func (c *responseCache) get(collection string, version database.Version, key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cc := c.collection(collection, version); cc != nil {
+		return cc.responses[key]
+	}
+	return nil
+}
+
+func (c *responseCache) put(collection string, version database.Version, key string, res *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cc := c.collection(collection, version); cc != nil && len(cc.responses) < maxCachedResponses {
+		cc.responses[key] = res
+	}
+}
+
+// cacheKey returns the path and the given query parameters of the request. Other parameters are ignored by
+// the handlers, so they must not create new cache entries.
+func cacheKey(r *http.Request, params []string) string {
+	query := r.URL.Query()
+	values := make(url.Values)
+	for _, param := range params {
+		if value := query.Get(param); value != "" {
+			values.Set(param, value)
+		}
+	}
+	return r.URL.Path + "?" + values.Encode()
+}
+
+// recordingWriter records the response of a handler to write it after the caching headers were set.
+type recordingWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.body.Write(data)
+}
+
+// notModified reports whether the conditional headers of the request match the version. If-None-Match
+// takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// weak comparison, the tags match with or without W/ prefix
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		// Last-Modified has a precision of seconds
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// cacheMiddleware serves the successful responses of GET requests from the response cache as long as the
+// collection is not changed. The responses contain an ETag and Last-Modified header derived from the version
+// of the collection, conditional requests with a matching If-None-Match or If-Modified-Since header are
+// answered with 304 Not Modified. The responses are cached per value of the query parameters the handler reads.
+func (s *Service) cacheMiddleware(collection string, params ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// the version is published after the write was committed, so the response of the handler is
+			// at least as new as the version
+			version := s.db.Version(collection)
+			etag := fmt.Sprintf(`W/"%s-%d"`, collection, version.Seq)
+			setValidators := func(header http.Header) {
+				header.Set("ETag", etag)
+				header.Set("Last-Modified", version.Modified.UTC().Format(http.TimeFormat))
+				header.Set("Cache-Control", "no-cache")
+			}
+
+			key := cacheKey(r, params)
+			res := s.cache.get(collection, version, key)
+			if res != nil {
+				w.Header().Set("X-Cache", "HIT")
+			} else {
+				rw := &recordingWriter{header: make(http.Header)}
+				next.ServeHTTP(rw, r)
+				if rw.status == 0 {
+					rw.status = http.StatusOK
+				}
+				if rw.status != http.StatusOK {
+					// errors are not cached and written without validators
+					for name, values := range rw.header {
+						w.Header()[name] = values
+					}
+					w.WriteHeader(rw.status)
+					if _, err := w.Write(rw.body.Bytes()); err != nil {
+						s.logger(r.Context()).Errorf("write error: %v", err)
+					}
+					return
+				}
+				res = &cachedResponse{header: rw.header, body: rw.body.Bytes()}
+				s.cache.put(collection, version, key, res)
+				w.Header().Set("X-Cache", "MISS")
+			}
+
+			for name, values := range res.header.Clone() {
+				w.Header()[name] = values
+			}
+			setValidators(w.Header())
+			if notModified(r, etag, version.Modified) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if _, err := w.Write(res.body); err != nil {
+				s.logger(r.Context()).Errorf("write error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func setHeader(name, value string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.Header.Set(name, value)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+
+	res := sendRequest(s, "GET", "/flights", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.Equal(t, "application/json; charset=utf-8", res.Header().Get("Content-Type"))
+	etag := res.Header().Get("ETag")
+	require.Regexp(t, `^W/"flights-\d+"$`, etag)
+	lastModified := res.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+	body := res.Body.String()
+
+	res = sendRequest(s, "GET", "/flights", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	require.Equal(t, etag, res.Header().Get("ETag"))
+	require.Equal(t, body, res.Body.String())
+
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-None-Match", etag))
+	require.Equal(t, http.StatusNotModified, res.Code)
+	require.Empty(t, res.Body.String())
+	require.Equal(t, etag, res.Header().Get("ETag"))
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-None-Match", `W/"flights-0", `+etag))
+	require.Equal(t, http.StatusNotModified, res.Code)
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-Modified-Since", lastModified))
+	require.Equal(t, http.StatusNotModified, res.Code)
+	// If-None-Match takes precedence over If-Modified-Since
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-None-Match", `W/"flights-0"`), setHeader("If-Modified-Since", lastModified))
+	require.Equal(t, http.StatusOK, res.Code)
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-Modified-Since", time.Unix(0, 0).UTC().Format(http.TimeFormat)))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// the responses are cached per query and share the version of the collection
+	res = sendRequest(s, "GET", "/flights?status=scheduled", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.Equal(t, etag, res.Header().Get("ETag"))
+	res = sendRequest(s, "GET", "/destinations", nil, setHeader("If-None-Match", etag))
+	require.Equal(t, http.StatusNotModified, res.Code)
+	// unknown query parameters share the cached response
+	res = sendRequest(s, "GET", "/flights?status=scheduled&x=1", nil)
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	res = sendRequest(s, "GET", "/flights?x=2", nil)
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	res = sendRequest(s, "GET", "/destinations?x=3", nil)
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		res = sendRequest(s, "GET", "/flights?from=XXX", nil)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		require.Empty(t, res.Header().Get("X-Cache"))
+		require.Empty(t, res.Header().Get("ETag"))
+	}
+
+	// writes to the collection invalidate the responses
+	require.NoError(t, s.db.Put(&models.Flight{ID: "123", From: "XXX", To: "YYY", Status: "test"}))
+	res = sendRequest(s, "GET", "/flights", nil, setHeader("If-None-Match", etag))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.NotEqual(t, etag, res.Header().Get("ETag"))
+	require.Contains(t, res.Body.String(), `"id":"123"`)
+	res = sendRequest(s, "GET", "/flights?from=XXX", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = sendRequest(s, "GET", "/destinations", nil)
+	require.Contains(t, res.Body.String(), "XXX")
+
+	// writes to other collections keep the responses
+	require.NoError(t, s.db.Put(&models.Seat{FlightID: "123", Seat: "1A"}))
+	res = sendRequest(s, "GET", "/flights", nil)
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/go-chi/chi/v5"
+)
+
+// quoteRefund calculates the refund for cancelling the booking now.
+func quoteRefund(txn *database.Txn, booking *models.Booking, now time.Time) (*models.RefundQuote, error) {
+	var flight models.Flight
+	if err := txn.Get(booking.FlightID, &flight); err != nil {
+		return nil, err
+	}
+	return models.QuoteRefund(booking, flight.Departure, now), nil
+}
+
+func (s *Service) handlerGetRefundQuote(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
+	userID, _, _ := r.BasicAuth()
+	var quote *models.RefundQuote
+	err := db.View(func(txn *database.Txn) error {
+		booking, err := getBooking(txn, userID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if booking.Status == models.BookingStatusCancelled {
+			return newRequestError(http.StatusConflict, "booking already cancelled")
+		}
+		quote, err = quoteRefund(txn, booking, time.Now())
+		return err
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	if currency != "" {
+		prices := []*money.Money{&quote.Amount}
+		for i := range quote.Passengers {
+			prices = append(prices, &quote.Passengers[i].Amount)
+		}
+		if err := convertPrices(s.Rates, currency, prices...); err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writeJSON(w, r, quote)
+}
+
+func (s *Service) handlerCancelBooking(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	bookingID := chi.URLParam(r, "id")
+
+	var booking *models.Booking
+	var refunds []models.Payment
+	var voidPaymentID string
+	err := db.Update(func(txn *database.Txn) error {
+		var err error
+		voidPaymentID = ""
+		booking, err = getBooking(txn, userID, bookingID)
+		if err != nil {
+			return err
+		}
+		switch booking.Status {
+		case models.BookingStatusPendingPayment, models.BookingStatusConfirmed:
+		default:
+			return newRequestError(http.StatusConflict, "booking cannot be cancelled")
+		}
+		now := time.Now()
+		quote, err := quoteRefund(txn, booking, now)
+		if err != nil {
+			return err
+		}
+		if err := s.releaseSeats(txn, booking, now); err != nil {
+			return err
+		}
+		if booking.Status == models.BookingStatusPendingPayment {
+			if err := releasePromoCode(txn, booking); err != nil {
+				return err
+			}
+			// nothing was captured yet, the authorization is voided instead of refunded
+			voidPaymentID = booking.PaymentID
+		}
+		booking.Status = models.BookingStatusCancelled
+		booking.PaymentDeadline = nil
+		booking.CancelledAt = &now
+		booking.RefundAmount = &quote.Amount
+		booking.RefundReason = quote.Reason
+		refunds = booking.AllocateRefund(quote.Amount, now)
+		if err := emitEvent(txn, models.EventBookingCancelled, booking); err != nil {
+			return err
+		}
+		return txn.Put(booking)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	// the cancellation is committed, failed refunds are retried in the background
+	s.voidPayment(r.Context(), voidPaymentID)
+	s.executeRefunds(r.Context(), booking, refunds)
+	s.writeJSON(w, r, booking)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/boardingpass"
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+type checkinRequest struct {
+	// Passengers are the indices of the passengers to check in, all passengers are checked in if empty.
+	Passengers []int `json:"passengers"`
+}
+
+// boardingGroup returns the boarding group of a seated passenger: the business cabin boards first,
+// followed by window, middle and aisle seats.
+func boardingGroup(passenger *models.Passenger) string {
+	if passenger.Cabin == models.CabinBusiness {
+		return "1"
+	}
+	switch passenger.Seat[len(passenger.Seat)-1] {
+	case 'A', 'F':
+		return "2"
+	case 'B', 'E':
+		return "3"
+	}
+	return "4"
+}
+
+func compartment(cabin string) string {
+	if cabin == models.CabinBusiness {
+		return "J"
+	}
+	return "Y"
+}
+
+// checkinWindow returns an error if the check-in for the flight is not open at now.
+func (s *Service) checkinWindow(flight *models.Flight, now time.Time) error {
+	if flight.Status == models.FlightStatusCancelled {
+		return newRequestError(http.StatusConflict, "flight is cancelled")
+	}
+	if now.Before(flight.Departure.Add(-s.CheckinOpens)) {
+		return newRequestError(http.StatusConflict, fmt.Sprintf("check-in opens %s before departure", s.CheckinOpens))
+	}
+	if now.After(flight.Departure.Add(-s.CheckinCloses)) {
+		return newRequestError(http.StatusConflict, "check-in closed")
+	}
+	return nil
+}
+
+func (s *Service) handlerCheckin(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	var checkin checkinRequest
+	if err := json.NewDecoder(r.Body).Decode(&checkin); err != nil && !errors.Is(err, io.EOF) {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var booking *models.Booking
+	err := db.Update(func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, userID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if booking.Status != models.BookingStatusConfirmed {
+			return newRequestError(http.StatusConflict, "only confirmed bookings can be checked in")
+		}
+		var flight models.Flight
+		if err := txn.Get(booking.FlightID, &flight); err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := s.checkinWindow(&flight, now); err != nil {
+			return err
+		}
+
+		passengers := checkin.Passengers
+		if len(passengers) == 0 {
+			for i := range booking.Passengers {
+				passengers = append(passengers, i)
+			}
+		}
+		counter := &models.CheckinCounter{FlightID: flight.ID}
+		if err := txn.Get(flight.ID, counter); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		for _, i := range passengers {
+			if i < 0 || i >= len(booking.Passengers) {
+				return newRequestError(http.StatusBadRequest, fmt.Sprintf("invalid passenger index: %d", i))
+			}
+			passenger := &booking.Passengers[i]
+			if passenger.CheckedInAt != nil {
+				continue
+			}
+			if passenger.Seat == "" {
+				if err := s.assignCheckinSeat(txn, booking, passenger, now); err != nil {
+					return err
+				}
+			}
+			passenger.CheckedInAt = &now
+			passenger.BoardingGroup = boardingGroup(passenger)
+			passenger.BoardingSequence = counter.Next()
+		}
+		if err := emitEvent(txn, models.EventBookingCheckedIn, booking); err != nil {
+			return err
+		}
+		return txn.Put(counter, booking)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, booking)
+}
+
+func (s *Service) handlerGetBoardingPass(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	passengerIndex := 0
+	if p := r.URL.Query().Get("passenger"); p != "" {
+		var err error
+		if passengerIndex, err = strconv.Atoi(p); err != nil {
+			s.sendError(w, r, "invalid passenger index", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var booking *models.Booking
+	var flight models.Flight
+	err := db.View(func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, userID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		return txn.Get(booking.FlightID, &flight)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	// cancelled and rebooked bookings keep their passengers, but their seats may belong to others now
+	if booking.Status != models.BookingStatusConfirmed {
+		s.sendError(w, r, "booking is not confirmed", http.StatusConflict)
+		return
+	}
+	if passengerIndex < 0 || passengerIndex >= len(booking.Passengers) {
+		s.sendError(w, r, "invalid passenger index", http.StatusBadRequest)
+		return
+	}
+	passenger := booking.Passengers[passengerIndex]
+	if passenger.CheckedInAt == nil {
+		s.sendError(w, r, "passenger not checked in", http.StatusConflict)
+		return
+	}
+
+	bp := &boardingpass.BoardingPass{
+		FirstName:    passenger.FirstName,
+		LastName:     passenger.LastName,
+		Reference:    booking.Reference,
+		From:         flight.From,
+		To:           flight.To,
+		Carrier:      boardingpass.Carrier,
+		FlightNumber: boardingpass.FlightNumber(flight.ID),
+		Departure:    flight.Departure,
+		Compartment:  compartment(passenger.Cabin),
+		Seat:         passenger.Seat,
+		Sequence:     passenger.BoardingSequence,
+		Group:        passenger.BoardingGroup,
+	}
+	bp.Barcode = bp.BCBP()
+
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = bp.WriteText(w)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = bp.WriteHTML(w)
+	case "", "json":
+		s.writeJSON(w, r, bp)
+	default:
+		s.sendError(w, r, "unsupported format", http.StatusBadRequest)
+	}
+	if err != nil {
+		s.logger(r.Context()).Errorf("could not render boarding pass: %v", err)
+	}
+}
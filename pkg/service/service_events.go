@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+// eventBufferSize is the number of changes that are buffered for a slow event stream before it is closed.
+const eventBufferSize = 256
+
+type seatAvailability struct {
+	Seat      string      `json:"seat"`
+	Row       int         `json:"row"`
+	Cabin     string      `json:"cabin"`
+	Price     money.Money `json:"price"`
+	Available bool        `json:"available"`
+	// Held is set if the seat is held for the user of the seat selection.
+	Held bool `json:"held,omitempty"`
+}
+
+// eventStream writes Server-Sent Events and flushes them immediately.
+type eventStream struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (e *eventStream) send(id uint64, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e *eventStream) comment(text string) error {
+	if _, err := fmt.Fprintf(e.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// CloseStreams ends all open event streams. It is registered with http.Server.RegisterOnShutdown
+// because Shutdown waits for the streams to end.
+func (s *Service) CloseStreams() {
+	s.closeStreams.Do(func() {
+		close(s.streamsDone)
+	})
+}
+
+func flightChanges(flightID string) func(database.Change) bool {
+	return func(c database.Change) bool {
+		if c.Deleted() {
+			return false
+		}
+		return (c.Collection == "flights" && c.Key == flightID) ||
+			(c.Collection == "seats" && strings.HasPrefix(c.Key, flightID+"/"))
+	}
+}
+
+// sendSeat sends the availability of the seat in the requested currency.
+func (s *Service) sendSeat(stream *eventStream, id uint64, seat *models.Seat, currency string) error {
+	availability := seatAvailability{
+		Seat:      seat.Seat,
+		Row:       seat.Row,
+		Cabin:     seat.Cabin(),
+		Price:     seat.Price,
+		Available: seat.Available,
+	}
+	if currency != "" {
+		if err := convertPrices(s.Rates, currency, &availability.Price); err != nil {
+			return err
+		}
+	}
+	return stream.send(id, "seat", availability)
+}
+
+func (s *Service) sendChange(stream *eventStream, change database.Change, currency string) error {
+	if change.Collection == "flights" {
+		return stream.send(change.Seq, "flight", change.Value)
+	}
+	var seat models.Seat
+	if err := json.Unmarshal(change.Value, &seat); err != nil {
+		return err
+	}
+	return s.sendSeat(stream, change.Seq, &seat, currency)
+}
+
+// sendSnapshot sends the flight and all of its seats with the given event ID.
+func (s *Service) sendSnapshot(stream *eventStream, id uint64, flightID, currency string) error {
+	flight, err := database.Get[*models.Flight](s.db, flightID)
+	if err != nil {
+		return err
+	}
+	seats, err := flightSeats(s.db, flightID)
+	if err != nil {
+		return err
+	}
+	if err := stream.send(id, "flight", flight); err != nil {
+		return err
+	}
+	for _, seat := range seats {
+		if err := s.sendSeat(stream, id, seat, currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerGetFlightEvents streams the status of the flight and the availability of its seats as Server-Sent Events.
+// The stream starts with the current state, a client that reconnects with Last-Event-ID only receives the missed
+// changes if they are still known. The stream ends after EventStreamTimeout to stay within the write timeout of the
+// server, the client reconnects and resumes.
+func (s *Service) handlerGetFlightEvents(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, r, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	flightID := chi.URLParam(r, "id")
+	match := flightChanges(flightID)
+	// subscribe before reading the current state, changes in between are sent twice but never lost
+	sub := db.Subscribe(eventBufferSize, match)
+	defer sub.Close()
+
+	if _, err := database.Get[*models.Flight](db, flightID); errors.Is(err, badger.ErrKeyNotFound) {
+		s.sendError(w, r, "flight not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var missed []database.Change
+	resumed := false
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if seq, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			missed, resumed = db.Changes(seq, match)
+		}
+	}
+
+	// text/event-stream is not in the list of compressible types, so the compress middleware passes it through
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	stream := &eventStream{w: w, flusher: flusher}
+
+	err := stream.comment("stream of flight " + flightID)
+	if err == nil && resumed {
+		for _, change := range missed {
+			if change.Seq > sub.Seq {
+				// delivered by the subscription
+				break
+			}
+			if err = s.sendChange(stream, change, currency); err != nil {
+				break
+			}
+		}
+	} else if err == nil {
+		err = s.sendSnapshot(stream, sub.Seq, flightID, currency)
+	}
+	if err != nil {
+		s.logger(r.Context()).Warnf("could not send events of flight %s: %v", flightID, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(s.EventHeartbeat)
+	defer heartbeat.Stop()
+	timeout := time.NewTimer(s.EventStreamTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case <-timeout.C:
+			return
+		case <-heartbeat.C:
+			err = stream.comment("heartbeat")
+		case change, ok := <-sub.C:
+			if !ok {
+				s.logger(r.Context()).Warnf("closing events of flight %s: %v", flightID, sub.Err())
+				return
+			}
+			err = s.sendChange(stream, change, currency)
+		}
+		if err != nil {
+			s.logger(r.Context()).Warnf("could not send events of flight %s: %v", flightID, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			event.comment = value
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			event.data = value
+		}
+	}
+}
+
+func parseEventID(t *testing.T, id string) uint64 {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	require.NoError(t, err)
+	return seq
+}
+
+func openEvents(t *testing.T, url, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = res.Body.Close()
+	})
+	return res, bufio.NewReader(res.Body)
+}
+
+func TestFlightEvents(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	defer s.CloseStreams()
+
+	res := sendRequest(s, "GET", "/flights/unknown/events", nil)
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	events, r := openEvents(t, srv.URL+"/flights/123/events?currency=USD", "")
+	require.Equal(t, http.StatusOK, events.StatusCode)
+	require.Equal(t, "text/event-stream", events.Header.Get("Content-Type"))
+	require.Empty(t, events.Header.Get("Content-Encoding"))
+	require.Equal(t, "stream of flight 123", readEvent(t, r).comment)
+
+	// the stream starts with the current state
+	event := readEvent(t, r)
+	require.Equal(t, "flight", event.event)
+	require.Contains(t, event.data, `"id":"123"`)
+	snapshotID := event.id
+	seats := make(map[string]seatAvailability)
+	for i := 0; i < 4; i++ {
+		event = readEvent(t, r)
+		require.Equal(t, "seat", event.event)
+		require.Equal(t, snapshotID, event.id)
+		var seat seatAvailability
+		require.NoError(t, json.Unmarshal([]byte(event.data), &seat))
+		seats[seat.Seat] = seat
+	}
+	require.True(t, seats["B1"].Available)
+	require.False(t, seats["A1"].Available)
+	require.Equal(t, "USD", seats["B1"].Price.Currency)
+
+	booking := createConfirmedBooking(t, s, "B1")
+	event = readEvent(t, r)
+	require.Equal(t, "seat", event.event)
+	require.Greater(t, parseEventID(t, event.id), parseEventID(t, snapshotID))
+	require.Contains(t, event.data, `"seat":"B1","row":1`)
+	require.Contains(t, event.data, `"available":false`)
+	lastEventID := event.id
+	require.NoError(t, events.Body.Close())
+
+	// the missed changes are sent after reconnecting
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	flight, err := database.Get[*models.Flight](s.db, "123")
+	require.NoError(t, err)
+	flight.Status = models.FlightStatusDelayed
+	require.NoError(t, s.db.Put(flight))
+
+	_, r = openEvents(t, srv.URL+"/flights/123/events", lastEventID)
+	readEvent(t, r)
+	event = readEvent(t, r)
+	require.Equal(t, "seat", event.event)
+	require.Contains(t, event.data, `"seat":"B1"`)
+	require.Contains(t, event.data, `"available":true`)
+	event = readEvent(t, r)
+	require.Equal(t, "flight", event.event)
+	require.Contains(t, event.data, `"status":"delayed"`)
+
+	// an unknown event ID starts with the current state
+	_, r = openEvents(t, srv.URL+"/flights/123/events", "999999999")
+	readEvent(t, r)
+	event = readEvent(t, r)
+	require.Equal(t, "flight", event.event)
+	require.Contains(t, event.data, `"status":"delayed"`)
+}
+
+func TestFlightEventsHeartbeatAndTimeout(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	s.EventHeartbeat = 10 * time.Millisecond
+	s.EventStreamTimeout = 100 * time.Millisecond
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, r := openEvents(t, srv.URL+"/flights/123/events", "")
+	for i := 0; i < 6; i++ {
+		readEvent(t, r)
+	}
+	require.Equal(t, "heartbeat", readEvent(t, r).comment)
+	// the stream ends after the timeout
+	_, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	// open streams end when the service shuts down
+	s.EventStreamTimeout = time.Minute
+	_, r = openEvents(t, srv.URL+"/flights/123/events", "")
+	readEvent(t, r)
+	s.CloseStreams()
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+}
@@ -23,37 +23,39 @@ func filterFlights(flights []*models.Flight, queryFrom, queryTo, queryStatus str
 }
 
 func (s *Service) handlerGetFlights(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
 	queryFrom := r.URL.Query().Get("from")
 	queryTo := r.URL.Query().Get("to")
 	queryStatus := r.URL.Query().Get("status")
 
 	if queryFrom == "" && queryTo == "" && queryStatus == "" {
 		s.contentTypeJSON(w)
-		err := s.db.RawValues(w, "flights")
+		err := db.RawValues(w, "flights")
 		if err != nil {
-			s.log.Errorf("error getting flights: %v", err)
+			s.logger(r.Context()).Errorf("error getting flights: %v", err)
 		}
 		return
 	}
 
-	allFlights, err := database.Values[*models.Flight](s.db)
+	allFlights, err := database.Values[*models.Flight](db)
 	if err != nil {
-		s.sendError(w, "could not get flights", http.StatusInternalServerError)
+		s.sendError(w, r, "could not get flights", http.StatusInternalServerError)
 		return
 	}
 
 	foundFlights := filterFlights(allFlights, queryFrom, queryTo, queryStatus)
 	if len(foundFlights) == 0 {
-		s.sendError(w, "no flights found", http.StatusBadRequest)
+		s.sendError(w, r, "no flights found", http.StatusBadRequest)
 		return
 	}
-	s.writeJSON(w, foundFlights)
+	s.writeJSON(w, r, foundFlights)
 }
 
 func (s *Service) handlerGetDestinations(w http.ResponseWriter, r *http.Request) {
-	allFlights, err := database.Values[*models.Flight](s.db)
+	db := s.db.WithContext(r.Context())
+	allFlights, err := database.Values[*models.Flight](db)
 	if err != nil {
-		s.sendError(w, "could not get flights", http.StatusInternalServerError)
+		s.sendError(w, r, "could not get flights", http.StatusInternalServerError)
 		return
 	}
 
@@ -77,41 +79,54 @@ func (s *Service) handlerGetDestinations(w http.ResponseWriter, r *http.Request)
 	for k := range to {
 		ret.To = append(ret.To, k)
 	}
-	s.writeJSON(w, ret)
+	s.writeJSON(w, r, ret)
 }
 
 func (s *Service) handlerGetFlight(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
 	flightID := chi.URLParam(r, "id")
-	flightData, err := s.db.RawGet("flights", flightID)
+	flightData, err := db.RawGet("flights", flightID)
 	if err == nil {
 		s.contentTypeJSON(w)
 		if _, err = w.Write(flightData); err != nil {
-			s.log.Errorf("write error: %v", err)
+			s.logger(r.Context()).Errorf("write error: %v", err)
 		}
 		return
 	} else if errors.Is(err, badger.ErrKeyNotFound) {
-		s.sendError(w, "flight not found", http.StatusNotFound)
+		s.sendError(w, r, "flight not found", http.StatusNotFound)
 		return
 	}
-	s.sendError(w, err.Error(), http.StatusInternalServerError)
+	s.sendError(w, r, err.Error(), http.StatusInternalServerError)
 }
 
 func (s *Service) handlerGetFlightSeats(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	currency, ok := s.requestedCurrency(w, r)
+	if !ok {
+		return
+	}
 	flightID := chi.URLParam(r, "id")
-	allSeats, err := database.Values[*models.Seat](s.db, flightID)
+	allSeats, err := database.Values[*models.Seat](db, flightID)
 	if err != nil {
-		s.sendError(w, err.Error(), http.StatusInternalServerError)
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	availableSeats := make([]*models.Seat, 0)
 	for _, seat := range allSeats {
-		if seat.Available {
-			availableSeats = append(availableSeats, seat)
+		if !seat.Available {
+			continue
+		}
+		if currency != "" {
+			if err := convertPrices(s.Rates, currency, &seat.Price); err != nil {
+				s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
 		}
+		availableSeats = append(availableSeats, seat)
 	}
 	if len(availableSeats) == 0 {
-		s.sendError(w, "no seats available", http.StatusNotFound)
+		s.sendError(w, r, "no seats available", http.StatusNotFound)
 		return
 	}
-	s.writeJSON(w, availableSeats)
+	s.writeJSON(w, r, availableSeats)
 }
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+)
+
+// SetSeeded marks the database as seeded, the service is not ready before.
+func (s *Service) SetSeeded() {
+	s.seeded.Store(true)
+}
+
+// StartShutdown fails the readiness check, so that no new traffic is routed to the service while the
+// server is shut down.
+func (s *Service) StartShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// readinessChecks returns the state of every readiness check, passed checks have the state "ok".
+func (s *Service) readinessChecks() (map[string]string, bool) {
+	checks := map[string]string{"database": "ok", "seeding": "ok", "shutdown": "ok"}
+	if s.db.Closed() {
+		checks["database"] = "closed"
+	}
+	if !s.seeded.Load() {
+		checks["seeding"] = "pending"
+	}
+	if s.shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+	}
+	for _, state := range checks {
+		if state != "ok" {
+			return checks, false
+		}
+	}
+	return checks, true
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func newReadinessResponse(checks map[string]string, ready bool) *readinessResponse {
+	status := "ready"
+	if !ready {
+		status = "not ready"
+	}
+	return &readinessResponse{Status: status, Checks: checks}
+}
+
+type statusResponse struct {
+	*readinessResponse
+	StartedAt time.Time       `json:"startedAt"`
+	Uptime    string          `json:"uptime"`
+	Database  *database.Stats `json:"database"`
+}
+
+// statsTTL is the time the database stats of the status endpoint are reused, as they iterate over all keys.
+const statsTTL = 5 * time.Second
+
+type statsCache struct {
+	mu      sync.Mutex
+	stats   *database.Stats
+	updated time.Time
+}
+
+// databaseStats returns the cached database stats, concurrent requests wait for a single refresh.
+func (s *Service) databaseStats(ctx context.Context) (*database.Stats, error) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if s.stats.stats != nil && time.Since(s.stats.updated) < statsTTL {
+		return s.stats.stats, nil
+	}
+	stats, err := s.db.WithContext(ctx).Stats()
+	if err != nil {
+		return nil, err
+	}
+	s.stats.stats = stats
+	s.stats.updated = time.Now()
+	return stats, nil
+}
+
+func (s *Service) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, map[string]string{"status": "ok"})
+}
+
+func (s *Service) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	checks, ready := s.readinessChecks()
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	s.writeJSON(w, r, newReadinessResponse(checks, ready))
+}
+
+func (s *Service) handlerStatus(w http.ResponseWriter, r *http.Request) {
+	checks, ready := s.readinessChecks()
+	res := &statusResponse{
+		readinessResponse: newReadinessResponse(checks, ready),
+		StartedAt:         s.startedAt,
+		Uptime:            time.Since(s.startedAt).Round(time.Second).String(),
+	}
+	// the stats show the progress of the seeding, only a closed database cannot be read
+	if !s.db.Closed() {
+		stats, err := s.databaseStats(r.Context())
+		if err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Database = stats
+	}
+	s.writeJSON(w, r, res)
+}
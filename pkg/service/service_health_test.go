@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	s := initService(t)
+
+	res := sendRequest(s, "GET", "/healthz", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = sendRequest(s, "GET", "/readyz", nil)
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+	var readiness readinessResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &readiness))
+	require.Equal(t, "not ready", readiness.Status)
+	require.Equal(t, map[string]string{"database": "ok", "seeding": "pending", "shutdown": "ok"}, readiness.Checks)
+
+	s.SetSeeded()
+	res = sendRequest(s, "GET", "/readyz", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &readiness))
+	require.Equal(t, "ready", readiness.Status)
+
+	s.StartShutdown()
+	res = sendRequest(s, "GET", "/readyz", nil)
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &readiness))
+	require.Equal(t, "shutting down", readiness.Checks["shutdown"])
+
+	require.NoError(t, s.db.Close())
+	res = sendRequest(s, "GET", "/readyz", nil)
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &readiness))
+	require.Equal(t, "closed", readiness.Checks["database"])
+	res = sendRequest(s, "GET", "/healthz", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestStatus(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.SetSeeded()
+
+	res := sendRequest(s, "GET", "/status", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var status struct {
+		Status   string `json:"status"`
+		Uptime   string `json:"uptime"`
+		Database struct {
+			Size int64          `json:"size"`
+			Keys map[string]int `json:"keys"`
+		} `json:"database"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &status))
+	require.Equal(t, "ready", status.Status)
+	require.NotEmpty(t, status.Uptime)
+	require.Positive(t, status.Database.Size)
+	require.Equal(t, 100, status.Database.Keys["flights"])
+	require.Equal(t, 100*29*6, status.Database.Keys["seats"])
+
+	// the stats are cached for a short time
+	require.NoError(t, s.db.Put(&models.Flight{ID: "new"}))
+	res = sendRequest(s, "GET", "/status", nil)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &status))
+	require.Equal(t, 100, status.Database.Keys["flights"])
+	s.stats.updated = time.Time{}
+	res = sendRequest(s, "GET", "/status", nil)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &status))
+	require.Equal(t, 101, status.Database.Keys["flights"])
+}
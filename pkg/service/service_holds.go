@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// holdSeat holds an available seat of the flight for the user until the given time. A seat that is already
+// held by the user is held again.
+func (s *Service) holdSeat(txn *database.Txn, userID, flightID, seatID string, now, until time.Time) error {
+	var flight models.Flight
+	if err := txn.Get(flightID, &flight); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return newRequestError(http.StatusNotFound, "flight not found")
+		}
+		return err
+	}
+	if flight.Status == models.FlightStatusCancelled || !now.Before(flight.Departure) {
+		return newRequestError(http.StatusConflict, "flight cannot be booked anymore")
+	}
+	var seat models.Seat
+	if err := txn.Get(fmt.Sprintf("%s/%s", flightID, seatID), &seat); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return errSeatNotFound
+		}
+		return err
+	}
+	hold := &models.SeatHold{FlightID: flightID, Seat: seatID}
+	if err := txn.Get(hold.Key(), hold); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	renew := hold.UserID == userID && seat.HeldBy == userID
+	if !seat.Available && !renew {
+		return errSeatNotAvailable
+	}
+	updates := []database.Model{&seat, hold}
+	if !renew {
+		guard := &models.SeatHoldGuard{FlightID: flightID, UserID: userID}
+		if err := txn.Get(guard.Key(), guard); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		guard.UpdatedAt = now
+		updates = append(updates, guard)
+		// the empty prefix restricts the holds to the exact flight ID
+		holds, err := database.TxnValues[*models.SeatHold](txn, flightID, "")
+		if err != nil {
+			return err
+		}
+		count := 0
+		for _, h := range holds {
+			// expired holds that were not released yet are not counted
+			if h.UserID == userID && h.ExpiresAt.After(now) {
+				count++
+			}
+		}
+		if count >= s.MaxSeatHolds {
+			return newRequestError(http.StatusConflict, fmt.Sprintf("at most %d seats can be held", s.MaxSeatHolds))
+		}
+	}
+	seat.Hold(userID, until)
+	hold.UserID = userID
+	hold.ExpiresAt = until
+	return txn.Put(updates...)
+}
+
+// releaseHold removes the hold of the user and releases the seat.
+func (s *Service) releaseHold(txn *database.Txn, userID, flightID, seatID string, now time.Time) error {
+	var hold models.SeatHold
+	if err := txn.Get(fmt.Sprintf("%s/%s", flightID, seatID), &hold); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return newRequestError(http.StatusNotFound, "hold not found")
+		}
+		return err
+	}
+	if hold.UserID != userID {
+		return newRequestError(http.StatusNotFound, "hold not found")
+	}
+	if err := txn.Delete(&hold); err != nil {
+		return err
+	}
+	return s.releaseSeat(txn, flightID, seatID, now)
+}
+
+// releaseExpiredHolds releases the seats of all temporary holds that expired.
+func (s *Service) releaseExpiredHolds(now time.Time) error {
+	holds, err := database.Values[*models.SeatHold](s.db)
+	if err != nil {
+		return err
+	}
+	for _, hold := range holds {
+		if hold.ExpiresAt.After(now) {
+			continue
+		}
+		err := s.db.Update(func(txn *database.Txn) error {
+			var current models.SeatHold
+			if err := txn.Get(hold.Key(), &current); errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if current.ExpiresAt.After(now) {
+				return nil
+			}
+			if err := txn.Delete(&current); err != nil {
+				return err
+			}
+			var seat models.Seat
+			if err := txn.Get(current.Key(), &seat); err != nil {
+				return err
+			}
+			if seat.Available || seat.HeldBy != current.UserID {
+				return nil
+			}
+			s.logger(txn.Context()).Infof("hold of seat %s of flight %s expired", current.Seat, current.FlightID)
+			return s.releaseSeat(txn, current.FlightID, current.Seat, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+)
+
+type logLevelRequest struct {
+	Level *logger.LogLevel `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level logger.LogLevel `json:"level"`
+}
+
+func (s *Service) handlerGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, logLevelResponse{Level: s.log.Level()})
+}
+
+func (s *Service) handlerPutLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Level == nil {
+		s.sendError(w, r, "missing log level", http.StatusBadRequest)
+		return
+	}
+	previous := s.log.Level()
+	s.log.SetLevel(*req.Level)
+	s.logger(r.Context()).Infof("changed log level from %s to %s", previous, *req.Level)
+	s.writeJSON(w, r, logLevelResponse{Level: s.log.Level()})
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/metrics"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Outcomes of booking requests.
+const (
+	bookingOutcomeCreated         = "created"
+	bookingOutcomeConfirmed       = "confirmed"
+	bookingOutcomeSeatUnavailable = "seat_unavailable"
+	bookingOutcomeNotFound        = "not_found"
+	bookingOutcomeSoldOut         = "sold_out"
+	bookingOutcomeInvalid         = "invalid"
+	bookingOutcomePaymentFailed   = "payment_failed"
+	bookingOutcomeConflict        = "conflict"
+	bookingOutcomeError           = "error"
+)
+
+type serviceMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	bookings        *metrics.CounterVec
+	dbDuration      *metrics.HistogramVec
+	dbErrors        *metrics.CounterVec
+}
+
+func newServiceMetrics() *serviceMetrics {
+	registry := metrics.NewRegistry()
+	registry.Register(metrics.NewRuntimeCollector())
+	return &serviceMetrics{
+		registry: registry,
+		requests: registry.NewCounter("http_requests_total",
+			"Number of HTTP requests by route pattern and status.", "method", "route", "status"),
+		requestDuration: registry.NewHistogram("http_request_duration_seconds",
+			"Latency of HTTP requests by route pattern and status.", metrics.DefBuckets, "method", "route", "status"),
+		bookings: registry.NewCounter("bookings_total",
+			"Number of booking requests by outcome.", "outcome"),
+		dbDuration: registry.NewHistogram("database_operation_duration_seconds",
+			"Latency of database operations.", []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25}, "operation"),
+		dbErrors: registry.NewCounter("database_operation_errors_total",
+			"Number of failed database operations.", "operation"),
+	}
+}
+
+// observeDatabase is the observer of the database operations. Missing keys and transactions that were aborted
+// with a request or validation error are not counted as errors.
+func (m *serviceMetrics) observeDatabase(operation string, duration time.Duration, err error) {
+	m.dbDuration.With(operation).Observe(duration.Seconds())
+	var reqErr *requestError
+	var validationErr *models.ValidationError
+	if err == nil || errors.Is(err, badger.ErrKeyNotFound) || errors.As(err, &reqErr) || errors.As(err, &validationErr) {
+		return
+	}
+	m.dbErrors.With(operation).Inc()
+}
+
+// bookingOutcome returns the outcome of a failed booking request.
+func bookingOutcome(err error) string {
+	var validationErr *models.ValidationError
+	var reqErr *requestError
+	switch {
+	case errors.Is(err, errSeatNotAvailable):
+		return bookingOutcomeSeatUnavailable
+	case errors.Is(err, errFlightNotFound), errors.Is(err, errSeatNotFound):
+		return bookingOutcomeNotFound
+	case errors.Is(err, errSoldOut):
+		return bookingOutcomeSoldOut
+	case errors.As(err, &validationErr), errors.As(err, &reqErr):
+		return bookingOutcomeInvalid
+	case errors.Is(err, badger.ErrConflict):
+		return bookingOutcomeConflict
+	case isPaymentError(err):
+		return bookingOutcomePaymentFailed
+	default:
+		return bookingOutcomeError
+	}
+}
+
+func (s *Service) recordBooking(outcome string) {
+	s.metrics.bookings.With(outcome).Inc()
+}
+
+// metricsMiddleware counts the requests and their latency by the matched route pattern.
+func (s *Service) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		labels := []string{requestMethod(r), routePattern(r), strconv.Itoa(responseStatus(ww))}
+		s.metrics.requests.With(labels...).Inc()
+		s.metrics.requestDuration.With(labels...).Observe(time.Since(start).Seconds())
+	})
+}
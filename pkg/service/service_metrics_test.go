@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	createConfirmedBooking(t, s, "B1")
+	bookingRequest := &models.Booking{FlightID: "123", PaymentToken: "tok_visa"}
+	bookingRequest.Passengers = append(bookingRequest.Passengers, testPassenger("John", "Doe", "A1"))
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	bookingRequest.FlightID = "unknown"
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	sendRequest(s, "GET", "/flights/123", nil)
+	sendRequest(s, "GET", "/unknown", nil)
+	sendRequest(s, "FOO", "/unknown", nil)
+	sendRequest(s, "BAR", "/flights/123", nil)
+
+	res = sendRequest(s, "GET", "/metrics", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header().Get("Content-Type"))
+	body := res.Body.String()
+	require.Contains(t, body, `http_requests_total{method="POST",route="/bookings/",status="200"} 1`+"\n")
+	require.Contains(t, body, `http_requests_total{method="POST",route="/bookings/",status="400"} 2`+"\n")
+	require.Contains(t, body, `http_requests_total{method="POST",route="/bookings/{id}/confirm",status="200"} 1`+"\n")
+	require.Contains(t, body, `http_requests_total{method="GET",route="/flights/{id}",status="200"} 1`+"\n")
+	require.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`+"\n")
+	require.Contains(t, body, `http_requests_total{method="other",route="unmatched",status="405"} 2`+"\n")
+	require.NotContains(t, body, `method="FOO"`)
+	require.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/flights/{id}",status="200"} 1`+"\n")
+	require.Contains(t, body, `bookings_total{outcome="created"} 1`+"\n")
+	require.Contains(t, body, `bookings_total{outcome="confirmed"} 1`+"\n")
+	require.Contains(t, body, `bookings_total{outcome="seat_unavailable"} 1`+"\n")
+	require.Contains(t, body, `bookings_total{outcome="not_found"} 1`+"\n")
+	require.Contains(t, body, `database_operation_duration_seconds_count{operation="update"}`)
+	require.Contains(t, body, `database_operation_duration_seconds_count{operation="view"}`)
+	require.NotContains(t, body, `database_operation_errors_total{operation="update"}`)
+	require.Contains(t, body, "go_goroutines ")
+}
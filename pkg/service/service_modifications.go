@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/go-chi/chi/v5"
+)
+
+type bookingChange struct {
+	Type      string `json:"type"`
+	Passenger int    `json:"passenger"`
+	Seat      string `json:"seat,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	// Details are the passenger data of an added passenger.
+	Details *models.Passenger `json:"details,omitempty"`
+}
+
+type modificationRequest struct {
+	Changes      []bookingChange `json:"changes"`
+	PaymentToken string          `json:"paymentToken,omitempty"`
+}
+
+// bookingModifier applies changes to a booking inside a transaction and keeps track of the price delta.
+type bookingModifier struct {
+	svc     *Service
+	txn     *database.Txn
+	booking *models.Booking
+	flight  *models.Flight
+	now     time.Time
+	delta   money.Money
+}
+
+func (m *bookingModifier) passenger(change *bookingChange) (*models.Passenger, error) {
+	if change.Passenger < 0 || change.Passenger >= len(m.booking.Passengers) {
+		return nil, newRequestError(http.StatusBadRequest, fmt.Sprintf("invalid passenger index: %d", change.Passenger))
+	}
+	passenger := &m.booking.Passengers[change.Passenger]
+	if passenger.CheckedInAt != nil {
+		return nil, newRequestError(http.StatusConflict, "passenger already checked in")
+	}
+	return passenger, nil
+}
+
+func (m *bookingModifier) record(change *bookingChange, from, to string, delta money.Money) {
+	m.delta = m.delta.Add(delta)
+	m.booking.Price = m.booking.Price.Add(delta)
+	m.booking.Modifications = append(m.booking.Modifications, models.Modification{
+		Time:       m.now,
+		Type:       change.Type,
+		Passenger:  change.Passenger,
+		From:       from,
+		To:         to,
+		PriceDelta: delta,
+	})
+}
+
+// changeSeat moves a passenger to another seat. The change fee of the old fare is charged and price
+// differences of non-refundable fares are only charged but not refunded.
+func (m *bookingModifier) changeSeat(change *bookingChange) error {
+	passenger, err := m.passenger(change)
+	if err != nil {
+		return err
+	}
+	if change.Seat == passenger.Seat {
+		return newRequestError(http.StatusBadRequest, "passenger already has this seat")
+	}
+	seat, err := reserveSeat(m.txn, m.booking.UserID, m.booking.FlightID, change.Seat, m.now)
+	if err != nil {
+		return err
+	}
+	if err := m.svc.releasePassengerSeat(m.txn, m.booking.FlightID, passenger, m.now); err != nil {
+		return err
+	}
+
+	oldSeat, oldFare := passenger.Seat, passenger.Fare
+	seatDiff := seat.Price.Sub(passenger.Price)
+	fees := money.Zero(seatDiff.Currency)
+	// passengers of an overbooked flight get their first seat without a change fee
+	if oldSeat != "" && oldFare != nil && oldFare.ChangeFee.Currency == fees.Currency {
+		fees = fees.Add(oldFare.ChangeFee)
+	}
+	if seatDiff.Amount < 0 && oldFare != nil && !oldFare.Refundable {
+		// the difference is retained
+		fees = fees.Sub(seatDiff)
+	}
+	m.booking.BasePrice = m.booking.BasePrice.Add(seatDiff)
+	m.booking.Fees = m.booking.Fees.Add(fees)
+	assignSeat(passenger, seat)
+	m.record(change, oldSeat, seat.Seat, seatDiff.Add(fees))
+	return nil
+}
+
+func (m *bookingModifier) rename(change *bookingChange) error {
+	passenger, err := m.passenger(change)
+	if err != nil {
+		return err
+	}
+	renamed := *passenger
+	renamed.FirstName = strings.TrimSpace(change.FirstName)
+	renamed.LastName = strings.TrimSpace(change.LastName)
+	v := &models.ValidationError{}
+	renamed.ValidateNames(v, fmt.Sprintf("passengers[%d]", change.Passenger))
+	if err := v.Err(); err != nil {
+		return err
+	}
+	oldName := passenger.FullName()
+	*passenger = renamed
+	m.record(change, oldName, passenger.FullName(), money.Zero(m.booking.Price.Currency))
+	return nil
+}
+
+func (m *bookingModifier) addPassenger(change *bookingChange) error {
+	if change.Details == nil {
+		return newRequestError(http.StatusBadRequest, "missing passenger details")
+	}
+	passenger := models.Passenger{
+		FirstName:   strings.TrimSpace(change.Details.FirstName),
+		LastName:    strings.TrimSpace(change.Details.LastName),
+		DateOfBirth: change.Details.DateOfBirth,
+		Type:        change.Details.Type,
+		Email:       change.Details.Email,
+		Document:    change.Details.Document,
+	}
+	passengers := append(append([]models.Passenger{}, m.booking.Passengers...), passenger)
+	if err := models.ValidatePassengers(passengers, m.flight.Departure); err != nil {
+		return err
+	}
+	seat, err := reserveSeat(m.txn, m.booking.UserID, m.booking.FlightID, change.Details.Seat, m.now)
+	if err != nil {
+		return err
+	}
+	assignSeat(&passenger, seat)
+	m.booking.Passengers = append(m.booking.Passengers, passenger)
+	m.booking.BasePrice = m.booking.BasePrice.Add(seat.Price)
+	change.Passenger = len(m.booking.Passengers) - 1
+	m.record(change, "", seat.Seat, seat.Price)
+	return nil
+}
+
+// removePassenger removes a passenger from the booking and refunds the seat according to its fare rules.
+func (m *bookingModifier) removePassenger(change *bookingChange) error {
+	passenger, err := m.passenger(change)
+	if err != nil {
+		return err
+	}
+	if len(m.booking.Passengers) == 1 {
+		return newRequestError(http.StatusBadRequest, "cannot remove the last passenger, cancel the booking instead")
+	}
+	remaining := make([]models.Passenger, 0, len(m.booking.Passengers)-1)
+	remaining = append(remaining, m.booking.Passengers[:change.Passenger]...)
+	remaining = append(remaining, m.booking.Passengers[change.Passenger+1:]...)
+	if err := models.ValidatePassengers(remaining, m.flight.Departure); err != nil {
+		return err
+	}
+	if err := m.svc.releasePassengerSeat(m.txn, m.booking.FlightID, passenger, m.now); err != nil {
+		return err
+	}
+	fare := passenger.Fare
+	if fare == nil {
+		fare = models.DefaultFareRules(passenger.Price.Currency)
+	}
+	refund, _ := fare.Refund(m.booking.PaidFor(passenger), m.flight.Departure, m.now)
+	removed := *passenger
+	m.booking.BasePrice = m.booking.BasePrice.Sub(removed.Price)
+	m.booking.Fees = m.booking.Fees.Add(removed.Price.Sub(refund))
+	m.booking.Passengers = append(m.booking.Passengers[:change.Passenger], m.booking.Passengers[change.Passenger+1:]...)
+	m.record(change, removed.Seat, "", money.Zero(refund.Currency).Sub(refund))
+	return nil
+}
+
+func (m *bookingModifier) apply(changes []bookingChange) error {
+	for i := range changes {
+		change := &changes[i]
+		var err error
+		switch change.Type {
+		case models.ModificationChangeSeat:
+			err = m.changeSeat(change)
+		case models.ModificationRename:
+			err = m.rename(change)
+		case models.ModificationAddPassenger:
+			err = m.addPassenger(change)
+		case models.ModificationRemovePassenger:
+			err = m.removePassenger(change)
+		default:
+			err = newRequestError(http.StatusBadRequest, fmt.Sprintf("unknown change type: %q", change.Type))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modifyBooking applies the changes to the confirmed booking inside the transaction and returns the modifier
+// with the updated booking and the price delta. The booking is not written.
+func (s *Service) modifyBooking(txn *database.Txn, userID, bookingID string, changes []bookingChange, now time.Time) (*bookingModifier, error) {
+	booking, err := getBooking(txn, userID, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.Status != models.BookingStatusConfirmed {
+		return nil, newRequestError(http.StatusConflict, "only confirmed bookings can be modified")
+	}
+	var flight models.Flight
+	if err := txn.Get(booking.FlightID, &flight); err != nil {
+		return nil, err
+	}
+	if !now.Before(flight.Departure) {
+		return nil, newRequestError(http.StatusConflict, "flight already departed")
+	}
+
+	modifier := &bookingModifier{
+		svc:     s,
+		txn:     txn,
+		booking: booking,
+		flight:  &flight,
+		now:     now,
+		delta:   money.Zero(booking.Price.Currency),
+	}
+	// the changes are applied to a copy, because they are applied again when the modification is committed
+	if err := modifier.apply(append([]bookingChange(nil), changes...)); err != nil {
+		return nil, err
+	}
+	return modifier, nil
+}
+
+// collectPayment authorizes and captures the amount, the authorization is voided if it cannot be captured.
+func (s *Service) collectPayment(ctx context.Context, amount money.Money, token, reference string) (*models.Payment, error) {
+	paymentID, err := s.Payments.Authorize(ctx, amount, token, reference)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Payments.Capture(ctx, paymentID); err != nil {
+		s.voidPayment(ctx, paymentID)
+		return nil, err
+	}
+	return &models.Payment{ID: paymentID, Amount: amount, Refunded: money.Zero(amount.Currency)}, nil
+}
+
+func (s *Service) handlerModifyBooking(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	bookingID := chi.URLParam(r, "id")
+	var modRequest modificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&modRequest); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(modRequest.Changes) == 0 {
+		s.sendError(w, r, "no changes", http.StatusBadRequest)
+		return
+	}
+
+	// the price delta is quoted in a discarded transaction, so that the payment provider is not called while
+	// a transaction is open
+	now := time.Now()
+	var quoted money.Money
+	err := db.DryRun(func(txn *database.Txn) error {
+		modifier, err := s.modifyBooking(txn, userID, bookingID, modRequest.Changes, now)
+		if err != nil {
+			return err
+		}
+		quoted = modifier.delta
+		return nil
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	var captured *models.Payment
+	if quoted.Amount > 0 {
+		if captured, err = s.collectPayment(r.Context(), quoted, modRequest.PaymentToken, bookingID); err != nil {
+			s.handleError(w, r, err)
+			return
+		}
+	}
+
+	var booking *models.Booking
+	var refunds []models.Payment
+	err = db.Update(func(txn *database.Txn) error {
+		modifier, err := s.modifyBooking(txn, userID, bookingID, modRequest.Changes, now)
+		if err != nil {
+			return err
+		}
+		if modifier.delta != quoted {
+			return newRequestError(http.StatusConflict, "the price of the modification changed, please retry")
+		}
+		booking = modifier.booking
+		switch {
+		case captured != nil:
+			booking.Payments = append(booking.Payments, *captured)
+		case modifier.delta.Amount < 0:
+			refunds = booking.AllocateRefund(money.Zero(modifier.delta.Currency).Sub(modifier.delta), now)
+		}
+		if err := emitEvent(txn, models.EventBookingModified, booking); err != nil {
+			return err
+		}
+		return txn.Put(booking)
+	})
+	if err != nil {
+		// the captured amount is paid back if the modification could not be committed
+		if captured != nil {
+			if refundErr := s.Payments.Refund(r.Context(), captured.ID, captured.Amount); refundErr != nil {
+				s.logger(r.Context()).Errorf("could not refund payment %s: %v", captured.ID, refundErr)
+			}
+		}
+		s.handleError(w, r, err)
+		return
+	}
+
+	s.executeRefunds(r.Context(), booking, refunds)
+	s.writeJSON(w, r, booking)
+}
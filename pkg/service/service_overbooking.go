@@ -0,0 +1,388 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// flightSeats returns the seats of the flight ordered by row and seat.
+func flightSeats(db *database.Database, flightID string) ([]*models.Seat, error) {
+	// the empty prefix restricts the seats to the exact flight ID
+	seats, err := database.Values[*models.Seat](db, flightID, "")
+	if err != nil {
+		return nil, err
+	}
+	sortSeats(seats)
+	return seats, nil
+}
+
+// txnFlightSeats returns the seats of the flight ordered by row and seat inside the transaction, so that
+// decisions based on their availability conflict with concurrent changes of the seats.
+func txnFlightSeats(txn *database.Txn, flightID string) ([]*models.Seat, error) {
+	seats, err := database.TxnValues[*models.Seat](txn, flightID, "")
+	if err != nil {
+		return nil, err
+	}
+	sortSeats(seats)
+	return seats, nil
+}
+
+func sortSeats(seats []*models.Seat) {
+	sort.Slice(seats, func(i, j int) bool {
+		if seats[i].Row != seats[j].Row {
+			return seats[i].Row < seats[j].Row
+		}
+		return seats[i].Seat < seats[j].Seat
+	})
+}
+
+// cabinFare returns the seat template that is sold to passengers without a seat: the most expensive
+// seat of the cabin without a seat number.
+func cabinFare(seats []*models.Seat, cabin string) (*models.Seat, error) {
+	if !models.ValidCabin(cabin) {
+		return nil, newRequestError(http.StatusBadRequest, "missing seat or cabin")
+	}
+	var fare *models.Seat
+	for _, seat := range seats {
+		if seat.Cabin() == cabin && (fare == nil || seat.Price.Amount > fare.Price.Amount) {
+			fare = seat
+		}
+	}
+	if fare == nil {
+		return nil, newRequestError(http.StatusBadRequest, "flight has no seats in this cabin")
+	}
+	template := *fare
+	template.Seat = ""
+	template.Available = false
+	template.ClearHold()
+	return &template, nil
+}
+
+// cabinSeatAvailable reports whether the user can book a seat of the cabin that is not already requested.
+func cabinSeatAvailable(seats []*models.Seat, cabin, userID string, requested map[string]bool, now time.Time) bool {
+	for _, seat := range seats {
+		if seat.Cabin() == cabin && !requested[seat.Seat] && seat.AvailableFor(userID, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseOverbookingSlot frees the overbooking slot of a passenger who got a seat or left the flight.
+func releaseOverbookingSlot(txn *database.Txn, flightID string) error {
+	var flight models.Flight
+	if err := txn.Get(flightID, &flight); err != nil {
+		return err
+	}
+	if flight.Oversold > 0 {
+		flight.Oversold--
+	}
+	return txn.Put(&flight)
+}
+
+// assignCheckinSeat gives a passenger without a seat the first available seat of the booked cabin.
+func (s *Service) assignCheckinSeat(txn *database.Txn, booking *models.Booking, passenger *models.Passenger, now time.Time) error {
+	seats, err := txnFlightSeats(txn, booking.FlightID)
+	if err != nil {
+		return err
+	}
+	for _, seat := range seats {
+		if seat.Cabin() != passenger.Cabin || !seat.AvailableFor(booking.UserID, now) {
+			continue
+		}
+		reserved, err := reserveSeat(txn, booking.UserID, booking.FlightID, seat.Seat, now)
+		if err != nil {
+			return err
+		}
+		passenger.Seat = reserved.Seat
+		return releaseOverbookingSlot(txn, booking.FlightID)
+	}
+	return newRequestError(http.StatusConflict, fmt.Sprintf("no seat available for %s, please contact the gate", passenger.FullName()))
+}
+
+type overbookingRequest struct {
+	Limit int `json:"limit"`
+}
+
+func (s *Service) handlerPutOverbookingLimit(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	var req overbookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Limit < 0 {
+		s.sendError(w, r, "limit must not be negative", http.StatusBadRequest)
+		return
+	}
+	var flight models.Flight
+	err := db.Update(func(txn *database.Txn) error {
+		if err := txn.Get(chi.URLParam(r, "id"), &flight); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return newRequestError(http.StatusNotFound, "flight not found")
+			}
+			return err
+		}
+		// already sold seatless bookings stay valid if the limit is lowered
+		flight.OverbookingLimit = req.Limit
+		return txn.Put(&flight)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, flight)
+}
+
+type cabinCapacity struct {
+	Seats     int `json:"seats"`
+	Available int `json:"available"`
+	Seatless  int `json:"seatless"`
+	// Shortfall is the number of seatless passengers that cannot get a seat.
+	Shortfall int `json:"shortfall"`
+}
+
+type seatlessPassenger struct {
+	UserID    string `json:"userId"`
+	BookingID string `json:"bookingId"`
+	Reference string `json:"reference"`
+	Passenger int    `json:"passenger"`
+	Name      string `json:"name"`
+	Cabin     string `json:"cabin"`
+}
+
+type oversoldReport struct {
+	Flight          *models.Flight            `json:"flight"`
+	Cabins          map[string]*cabinCapacity `json:"cabins"`
+	Passengers      []seatlessPassenger       `json:"passengers,omitempty"`
+	DeniedBoardings []*models.DeniedBoarding  `json:"deniedBoardings,omitempty"`
+}
+
+// oversoldReport compares the seatless passengers of the flight with the available seats per cabin.
+func (s *Service) oversoldReport(flight *models.Flight, bookings []*models.Booking) (*oversoldReport, error) {
+	seats, err := flightSeats(s.db, flight.ID)
+	if err != nil {
+		return nil, err
+	}
+	report := &oversoldReport{
+		Flight: flight,
+		Cabins: make(map[string]*cabinCapacity),
+	}
+	cabin := func(name string) *cabinCapacity {
+		if report.Cabins[name] == nil {
+			report.Cabins[name] = &cabinCapacity{}
+		}
+		return report.Cabins[name]
+	}
+	for _, seat := range seats {
+		c := cabin(seat.Cabin())
+		c.Seats++
+		if seat.Available {
+			c.Available++
+		}
+	}
+	for _, booking := range bookings {
+		if booking.FlightID != flight.ID {
+			continue
+		}
+		switch booking.Status {
+		case models.BookingStatusPendingPayment, models.BookingStatusConfirmed:
+		default:
+			continue
+		}
+		for i, passenger := range booking.Passengers {
+			if passenger.Seat != "" {
+				continue
+			}
+			cabin(passenger.Cabin).Seatless++
+			report.Passengers = append(report.Passengers, seatlessPassenger{
+				UserID:    booking.UserID,
+				BookingID: booking.ID,
+				Reference: booking.Reference,
+				Passenger: i,
+				Name:      passenger.FullName(),
+				Cabin:     passenger.Cabin,
+			})
+		}
+	}
+	for _, c := range report.Cabins {
+		if c.Seatless > c.Available {
+			c.Shortfall = c.Seatless - c.Available
+		}
+	}
+	return report, nil
+}
+
+func (s *Service) handlerGetOversoldFlights(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	flights, err := database.Values[*models.Flight](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bookings, err := database.Values[*models.Booking](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reports := make([]*oversoldReport, 0)
+	for _, flight := range flights {
+		if flight.Oversold == 0 {
+			continue
+		}
+		report, err := s.oversoldReport(flight, bookings)
+		if err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the list only contains the summary, details are available per flight
+		report.Passengers = nil
+		reports = append(reports, report)
+	}
+	s.writeJSON(w, r, reports)
+}
+
+func (s *Service) handlerGetOversoldFlight(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	flight, err := database.Get[*models.Flight](db, chi.URLParam(r, "id"))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		s.sendError(w, r, "flight not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bookings, err := database.Values[*models.Booking](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report, err := s.oversoldReport(flight, bookings)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.DeniedBoardings, err = database.Values[*models.DeniedBoarding](db, flight.ID, "")
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, report)
+}
+
+type deniedBoardingRequest struct {
+	UserID       string      `json:"userId"`
+	BookingID    string      `json:"bookingId"`
+	Passenger    int         `json:"passenger"`
+	Reason       string      `json:"reason"`
+	Compensation money.Money `json:"compensation"`
+	Note         string      `json:"note"`
+}
+
+// handlerDenyBoarding resolves a seatless passenger of an oversold flight: the passenger is removed from
+// the booking, the ticket is refunded in full and the compensation is recorded.
+func (s *Service) handlerDenyBoarding(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	var req deniedBoardingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch req.Reason {
+	case models.DeniedBoardingVoluntary, models.DeniedBoardingInvoluntary:
+	default:
+		s.sendError(w, r, "reason must be voluntary or involuntary", http.StatusBadRequest)
+		return
+	}
+	if req.Compensation.Amount < 0 || (req.Compensation.Amount > 0 && !money.ValidCurrency(req.Compensation.Currency)) {
+		s.sendError(w, r, "invalid compensation", http.StatusBadRequest)
+		return
+	}
+
+	var denied *models.DeniedBoarding
+	var booking *models.Booking
+	var refunds []models.Payment
+	err := db.Update(func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, req.UserID, req.BookingID)
+		if err != nil {
+			return err
+		}
+		if booking.FlightID != chi.URLParam(r, "id") {
+			return newRequestError(http.StatusNotFound, "booking not found")
+		}
+		if booking.Status != models.BookingStatusConfirmed {
+			return newRequestError(http.StatusConflict, "only confirmed bookings can be denied boarding")
+		}
+		if req.Passenger < 0 || req.Passenger >= len(booking.Passengers) {
+			return newRequestError(http.StatusBadRequest, fmt.Sprintf("invalid passenger index: %d", req.Passenger))
+		}
+		passenger := booking.Passengers[req.Passenger]
+		if passenger.Seat != "" {
+			return newRequestError(http.StatusConflict, "passenger already has a seat")
+		}
+		if err := releaseOverbookingSlot(txn, booking.FlightID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		refund := booking.PaidFor(&passenger)
+		if len(booking.Passengers) == 1 {
+			booking.Status = models.BookingStatusCancelled
+			booking.CancelledAt = &now
+			booking.RefundAmount = &refund
+			booking.RefundReason = "denied boarding"
+		} else {
+			booking.BasePrice = booking.BasePrice.Sub(passenger.Price)
+			booking.Fees = booking.Fees.Add(passenger.Price.Sub(refund))
+			booking.Price = booking.Price.Sub(refund)
+			booking.Passengers = append(booking.Passengers[:req.Passenger], booking.Passengers[req.Passenger+1:]...)
+		}
+		booking.Modifications = append(booking.Modifications, models.Modification{
+			Time:       now,
+			Type:       models.ModificationDeniedBoarding,
+			Passenger:  req.Passenger,
+			From:       passenger.FullName(),
+			PriceDelta: money.Zero(refund.Currency).Sub(refund),
+		})
+		refunds = booking.AllocateRefund(refund, now)
+
+		compensation := req.Compensation
+		if compensation.Currency == "" {
+			compensation = money.Zero(refund.Currency)
+		}
+		denied = &models.DeniedBoarding{
+			ID:           uuid.NewString(),
+			FlightID:     booking.FlightID,
+			UserID:       booking.UserID,
+			BookingID:    booking.ID,
+			Passenger:    passenger,
+			Reason:       req.Reason,
+			Refund:       refund,
+			Compensation: compensation,
+			Note:         req.Note,
+			Time:         now,
+		}
+		if err := emitEvent(txn, models.EventBookingDeniedBoarding, booking); err != nil {
+			return err
+		}
+		return txn.Put(booking, denied)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.executeRefunds(r.Context(), booking, refunds)
+	s.writeJSON(w, r, denied)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/christophwitzko/flight-booking-service/pkg/payment"
+	"github.com/stretchr/testify/require"
+)
+
+func createSeatlessBooking(t *testing.T, s *Service, user string, passengers int) models.Booking {
+	bookingRequest := &models.Booking{FlightID: "123", PaymentToken: "tok_visa"}
+	for i := 0; i < passengers; i++ {
+		passenger := testPassenger("Jane", "Roe", "")
+		passenger.Cabin = models.CabinBusiness
+		bookingRequest.Passengers = append(bookingRequest.Passengers, passenger)
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setUserAuth(user))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/confirm", nil, setUserAuth(user))
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	return booking
+}
+
+func TestOverbookingAndCheckinSeatAssignment(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.Auth["alice"] = "pw"
+	s.CheckinOpens = 100 * time.Hour
+	require.NoError(t, putBookingRequestData(s))
+	booking := createConfirmedBooking(t, s, "B1", "C1")
+
+	passenger := testPassenger("Jane", "Roe", "")
+	passenger.Cabin = models.CabinBusiness
+	bookingRequest := &models.Booking{FlightID: "123", Passengers: []models.Passenger{passenger}, PaymentToken: "tok_visa"}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code, "flights are not overbooked by default")
+
+	res = sendRequest(s, "PUT", "/admin/flights/123/overbooking", jsonBody(t, overbookingRequest{Limit: 1}), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	seatless := createSeatlessBooking(t, s, "alice", 1)
+	require.Empty(t, seatless.Passengers[0].Seat)
+	require.Equal(t, money.New(1000, "EUR"), seatless.Price)
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	var reports []oversoldReport
+	res = sendRequest(s, "GET", "/admin/oversold", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &reports))
+	require.Len(t, reports, 1)
+	require.Equal(t, 1, reports[0].Flight.Oversold)
+	require.Equal(t, 1, reports[0].Cabins[models.CabinBusiness].Shortfall)
+
+	res = sendRequest(s, "POST", "/bookings/"+seatless.ID+"/checkin", nil, setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	// a released seat is kept for the seatless passenger instead of going back on sale
+	changes := map[string]any{
+		"changes": []map[string]any{{"type": "removePassenger", "passenger": 1}},
+	}
+	res = sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = sendRequest(s, "POST", "/bookings/"+seatless.ID+"/checkin", nil, setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code)
+	seatless = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &seatless))
+	require.Equal(t, "C1", seatless.Passengers[0].Seat)
+	require.NotNil(t, seatless.Passengers[0].CheckedInAt)
+
+	flight, err := database.Get[*models.Flight](s.db, "123")
+	require.NoError(t, err)
+	require.Zero(t, flight.Oversold)
+	seat, err := database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+}
+
+func TestDenyBoarding(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.Auth["alice"] = "pw"
+	require.NoError(t, putBookingRequestData(s))
+	res := sendRequest(s, "PUT", "/admin/flights/123/overbooking", jsonBody(t, overbookingRequest{Limit: 2}), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// passengers without a seat are only accepted once the cabin is sold out
+	passenger := testPassenger("Jane", "Roe", "")
+	passenger.Cabin = models.CabinBusiness
+	bookingRequest := &models.Booking{FlightID: "123", Passengers: []models.Passenger{passenger}, PaymentToken: "tok_visa"}
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code)
+	require.Contains(t, res.Body.String(), "please select a seat")
+	bookingRequest.Passengers = append(bookingRequest.Passengers, testPassenger("John", "Roe", "B1"), testPassenger("Max", "Roe", "C1"))
+	res = sendRequest(s, "POST", "/bookings/quote", jsonBody(t, bookingRequest), setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code, "the requested seats are not available for passengers without a seat")
+
+	createConfirmedBooking(t, s, "B1", "C1")
+	booking := createSeatlessBooking(t, s, "alice", 2)
+
+	deny := deniedBoardingRequest{
+		UserID:       "alice",
+		BookingID:    booking.ID,
+		Passenger:    1,
+		Reason:       models.DeniedBoardingVoluntary,
+		Compensation: money.New(25000, "EUR"),
+	}
+	res = sendRequest(s, "POST", "/admin/flights/123/denied-boardings", jsonBody(t, deny), setBasicAuth)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	res = sendRequest(s, "POST", "/admin/flights/123/denied-boardings", jsonBody(t, deny), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var denied models.DeniedBoarding
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &denied))
+	require.Equal(t, money.New(1000, "EUR"), denied.Refund)
+	require.Equal(t, money.New(25000, "EUR"), denied.Compensation)
+
+	updated, err := database.Get[*models.Booking](s.db, "alice/"+booking.ID)
+	require.NoError(t, err)
+	require.Len(t, updated.Passengers, 1)
+	require.Equal(t, money.New(1000, "EUR"), updated.Price)
+	require.Equal(t, models.ModificationDeniedBoarding, updated.Modifications[0].Type)
+	fakePayment, ok := s.Payments.(*payment.Fake).Payment(updated.PaymentID)
+	require.True(t, ok)
+	require.Equal(t, money.New(1000, "EUR"), fakePayment.Refunded)
+
+	var report oversoldReport
+	res = sendRequest(s, "GET", "/admin/flights/123/oversold", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Flight.Oversold)
+	require.Len(t, report.Passengers, 1)
+	require.Len(t, report.DeniedBoardings, 1)
+}
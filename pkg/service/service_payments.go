@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/christophwitzko/flight-booking-service/pkg/payment"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+func isPaymentError(err error) bool {
+	for _, paymentErr := range []error{
+		payment.ErrDeclined, payment.ErrNotFound, payment.ErrInvalidState, payment.ErrRefundTooLarge,
+		payment.ErrInvalidAmount, payment.ErrMissingToken, payment.ErrProviderFailure,
+	} {
+		if errors.Is(err, paymentErr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) sendPaymentError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, payment.ErrDeclined):
+		s.sendError(w, r, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, payment.ErrMissingToken), errors.Is(err, payment.ErrInvalidAmount):
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+	default:
+		s.sendError(w, r, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// releaseBooking sets the status of a booking that is still waiting for payment and releases its seats
+// and promo code usage. The payment authorization of the booking is voided after the release.
+func (s *Service) releaseBooking(ctx context.Context, userID, bookingID, status string) error {
+	var paymentID string
+	err := s.db.WithContext(ctx).Update(func(txn *database.Txn) error {
+		booking, err := getBooking(txn, userID, bookingID)
+		if err != nil {
+			return err
+		}
+		if booking.Status != models.BookingStatusPendingPayment {
+			return nil
+		}
+		if err := s.releaseSeats(txn, booking, time.Now()); err != nil {
+			return err
+		}
+		if err := releasePromoCode(txn, booking); err != nil {
+			return err
+		}
+		booking.Status = status
+		booking.PaymentDeadline = nil
+		if err := emitEvent(txn, "booking."+status, booking); err != nil {
+			return err
+		}
+		paymentID = booking.PaymentID
+		return txn.Put(booking)
+	})
+	if err != nil {
+		return err
+	}
+	s.voidPayment(ctx, paymentID)
+	return nil
+}
+
+// voidPayment releases the payment authorization of a booking that will not be captured anymore. Failures
+// are only logged, the authorization expires at the payment provider eventually.
+func (s *Service) voidPayment(ctx context.Context, paymentID string) {
+	if paymentID == "" {
+		return
+	}
+	if err := s.Payments.Void(ctx, paymentID); err != nil {
+		s.logger(ctx).Errorf("could not void payment %s: %v", paymentID, err)
+	}
+}
+
+// maxConflictRetries is the number of attempts of updates that have to be recorded after a call to the
+// payment provider, even if the booking is modified concurrently.
+const maxConflictRetries = 3
+
+// updateRetrying runs the update again if it conflicts with a concurrent transaction.
+func (s *Service) updateRetrying(ctx context.Context, fn func(txn *database.Txn) error) error {
+	var err error
+	for attempt := 0; attempt < maxConflictRetries; attempt++ {
+		if err = s.db.WithContext(ctx).Update(fn); !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	return err
+}
+
+// refundRetryDelay is the time after which failed refunds, and refunds that were never attempted, are retried.
+const refundRetryDelay = time.Minute
+
+// executeRefunds pays back the refunds that were allocated to the payments of the booking and records the
+// result on the booking, which is updated in place. Failed refunds stay pending and are retried by Run.
+func (s *Service) executeRefunds(ctx context.Context, booking *models.Booking, refunds []models.Payment) {
+	for _, refund := range refunds {
+		refundErr := s.Payments.Refund(ctx, refund.ID, refund.Amount)
+		if refundErr != nil {
+			s.logger(ctx).Errorf("could not refund %s of payment %s for booking %s: %v", refund.Amount, refund.ID, booking.ID, refundErr)
+		}
+		record := func(txn *database.Txn) error {
+			updated, err := getBooking(txn, booking.UserID, booking.ID)
+			if err != nil {
+				return err
+			}
+			p := updated.PaymentByID(refund.ID)
+			if p == nil {
+				return nil
+			}
+			if refundErr != nil {
+				p.RefundFailed(time.Now())
+			} else {
+				p.RefundSucceeded(refund.Amount)
+			}
+			*booking = *updated
+			return txn.Put(updated)
+		}
+		// the result has to be recorded even if the booking is modified concurrently, otherwise a successful
+		// refund would be paid back again
+		if err := s.updateRetrying(ctx, record); err != nil {
+			s.logger(ctx).Errorf("could not record refund of payment %s for booking %s: %v", refund.ID, booking.ID, err)
+		}
+	}
+}
+
+// retryRefunds executes the pending refunds of all bookings that are due.
+func (s *Service) retryRefunds(now time.Time) error {
+	bookings, err := database.Values[*models.Booking](s.db)
+	if err != nil {
+		return err
+	}
+	for _, booking := range bookings {
+		refunds := make([]models.Payment, 0)
+		for _, p := range booking.Payments {
+			if p.RefundDue(now, refundRetryDelay) {
+				refunds = append(refunds, models.Payment{ID: p.ID, Amount: *p.PendingRefund})
+			}
+		}
+		if len(refunds) > 0 {
+			s.log.Infof("retrying %d refunds of booking %s", len(refunds), booking.ID)
+			s.executeRefunds(context.Background(), booking, refunds)
+		}
+	}
+	return nil
+}
+
+// releasePromoCode reverts the promo code usage of a booking that was never paid.
+func releasePromoCode(txn *database.Txn, booking *models.Booking) error {
+	if booking.PromoCode == "" {
+		return nil
+	}
+	var promoCode models.PromoCode
+	if err := txn.Get(booking.PromoCode, &promoCode); errors.Is(err, badger.ErrKeyNotFound) {
+		// the promo code was deleted in the meantime
+		return nil
+	} else if err != nil {
+		return err
+	}
+	promoCode.Release(booking.UserID)
+	return txn.Put(&promoCode)
+}
+
+// releaseExpiredBookings releases all bookings whose payment deadline is before now.
+func (s *Service) releaseExpiredBookings(now time.Time) error {
+	bookings, err := database.Values[*models.Booking](s.db)
+	if err != nil {
+		return err
+	}
+	for _, booking := range bookings {
+		if booking.Status != models.BookingStatusPendingPayment || booking.PaymentDeadline == nil ||
+			booking.PaymentDeadline.After(now) {
+			continue
+		}
+		s.log.Infof("payment deadline of booking %s expired", booking.ID)
+		if err := s.releaseBooking(context.Background(), booking.UserID, booking.ID, models.BookingStatusExpired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) handlerConfirmBooking(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	bookingID := chi.URLParam(r, "id")
+
+	var booking *models.Booking
+	err := db.View(func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, userID, bookingID)
+		return err
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	if booking.Status != models.BookingStatusPendingPayment || booking.PaymentID == "" {
+		s.sendError(w, r, "booking is not awaiting payment", http.StatusConflict)
+		return
+	}
+	if booking.PaymentDeadline != nil && time.Now().After(*booking.PaymentDeadline) {
+		s.sendError(w, r, "payment deadline expired", http.StatusConflict)
+		return
+	}
+
+	paymentID, price := booking.PaymentID, booking.Price
+	if err = s.Payments.Capture(r.Context(), paymentID); err != nil {
+		s.sendPaymentError(w, r, err)
+		return
+	}
+	// the captured payment has to be recorded even if the booking is modified concurrently
+	err = s.updateRetrying(r.Context(), func(txn *database.Txn) error {
+		var err error
+		booking, err = getBooking(txn, userID, bookingID)
+		if err != nil {
+			return err
+		}
+		if booking.Status != models.BookingStatusPendingPayment {
+			return newRequestError(http.StatusConflict, "booking is not awaiting payment")
+		}
+		booking.Status = models.BookingStatusConfirmed
+		booking.PaymentDeadline = nil
+		booking.Payments = append(booking.Payments, models.Payment{
+			ID:       paymentID,
+			Amount:   price,
+			Refunded: money.Zero(price.Currency),
+		})
+		if err := emitEvent(txn, models.EventBookingConfirmed, booking); err != nil {
+			return err
+		}
+		return txn.Put(booking)
+	})
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		// the booking was released in the meantime, so the captured amount has to be paid back
+		if refundErr := s.Payments.Refund(r.Context(), paymentID, price); refundErr != nil {
+			s.logger(r.Context()).Errorf("could not refund payment %s: %v", paymentID, refundErr)
+		}
+	}
+	if err != nil {
+		s.logger(r.Context()).Errorf("could not record captured payment %s of booking %s: %v", paymentID, bookingID, err)
+		s.handleError(w, r, err)
+		return
+	}
+	s.recordBooking(bookingOutcomeConfirmed)
+	s.writeJSON(w, r, booking)
+}
+
+// Run executes the background jobs of the service until the context is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.JobInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.releaseExpiredBookings(now); err != nil {
+				s.log.Errorf("could not release expired bookings: %v", err)
+			}
+			if err := s.releaseExpiredOffers(now); err != nil {
+				s.log.Errorf("could not release expired waitlist offers: %v", err)
+			}
+			if err := s.releaseExpiredHolds(now); err != nil {
+				s.log.Errorf("could not release expired seat holds: %v", err)
+			}
+			if err := s.rebookCancelledFlights(now); err != nil {
+				s.log.Errorf("could not rebook cancelled flights: %v", err)
+			}
+			if err := s.retryRefunds(now); err != nil {
+				s.log.Errorf("could not retry refunds: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// getApplicablePromoCode loads the promo code and checks whether the user can redeem it for the flight.
+func (s *Service) getApplicablePromoCode(txn *database.Txn, code, userID string, flight *models.Flight) (*models.PromoCode, error) {
+	var promoCode models.PromoCode
+	if err := txn.Get(normalizePromoCode(code), &promoCode); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, newRequestError(http.StatusBadRequest, "invalid promo code")
+		}
+		return nil, err
+	}
+	if err := promoCode.CheckApplicable(userID, flight, time.Now()); err != nil {
+		return nil, newRequestError(http.StatusBadRequest, err.Error())
+	}
+	return &promoCode, nil
+}
+
+func (s *Service) handlerGetPromoCodes(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	promoCodes, err := database.Values[*models.PromoCode](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, promoCodes)
+}
+
+func (s *Service) handlerGetPromoCode(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	promoCode, err := database.Get[*models.PromoCode](db, normalizePromoCode(chi.URLParam(r, "code")))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		s.sendError(w, r, "promo code not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, promoCode)
+}
+
+func (s *Service) handlerPutPromoCode(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	var promoCode models.PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&promoCode); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	promoCode.Code = normalizePromoCode(chi.URLParam(r, "code"))
+	promoCode.Currency = strings.ToUpper(promoCode.Currency)
+	if promoCode.Code == "" {
+		s.sendError(w, r, "missing promo code", http.StatusBadRequest)
+		return
+	}
+	if err := promoCode.Validate(); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// a fixed discount is converted into the currency of every flight it is applied to
+	if promoCode.Type == models.DiscountFixed && !s.Rates.Supports(promoCode.Currency) {
+		s.sendError(w, r, "unsupported currency", http.StatusBadRequest)
+		return
+	}
+
+	err := db.Update(func(txn *database.Txn) error {
+		// usage counters are owned by the service and survive updates of the rule
+		var existing models.PromoCode
+		err := txn.Get(promoCode.Code, &existing)
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		promoCode.Uses = existing.Uses
+		promoCode.UsesByUser = existing.UsesByUser
+		return txn.Put(&promoCode)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, promoCode)
+}
+
+func (s *Service) handlerDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	promoCode := &models.PromoCode{Code: normalizePromoCode(chi.URLParam(r, "code"))}
+	if err := db.Delete(promoCode); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// rebookingCandidates returns the flights on the route of the cancelled flight that can still be booked,
+// ordered by departure.
+func rebookingCandidates(flights []*models.Flight, cancelled *models.Flight, now time.Time) []*models.Flight {
+	candidates := make([]*models.Flight, 0)
+	for _, flight := range flights {
+		if flight.ID == cancelled.ID || flight.From != cancelled.From || flight.To != cancelled.To {
+			continue
+		}
+		if flight.Status != models.FlightStatusScheduled && flight.Status != models.FlightStatusDelayed {
+			continue
+		}
+		if !now.Before(flight.Departure) {
+			continue
+		}
+		candidates = append(candidates, flight)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Departure.Before(candidates[j].Departure)
+	})
+	return candidates
+}
+
+// pickSeats chooses an available seat for every passenger, preferring the cabin of the passenger.
+// It reports false if the flight does not have enough seats.
+func pickSeats(seats []*models.Seat, passengers []models.Passenger, userID string, now time.Time) ([]string, bool) {
+	taken := make(map[string]bool)
+	picked := make([]string, len(passengers))
+	pick := func(cabin string) string {
+		for _, seat := range seats {
+			if taken[seat.Seat] || !seat.AvailableFor(userID, now) {
+				continue
+			}
+			if cabin == "" || seat.Cabin() == cabin {
+				taken[seat.Seat] = true
+				return seat.Seat
+			}
+		}
+		return ""
+	}
+	for i, passenger := range passengers {
+		if picked[i] = pick(passenger.Cabin); picked[i] == "" {
+			if picked[i] = pick(""); picked[i] == "" {
+				return nil, false
+			}
+		}
+	}
+	return picked, true
+}
+
+// moveBooking replaces the booking of a cancelled flight with a booking on the new flight. The price,
+// fares, payments and the booking reference are kept.
+func (s *Service) moveBooking(txn *database.Txn, userID, bookingID string, newFlight *models.Flight, seats []string, now time.Time) error {
+	booking, err := getBooking(txn, userID, bookingID)
+	if err != nil {
+		return err
+	}
+	if booking.Status != models.BookingStatusConfirmed && booking.Status != models.BookingStatusPendingPayment {
+		return nil
+	}
+
+	rebooked := *booking
+	rebooked.ID = uuid.NewString()
+	rebooked.FlightID = newFlight.ID
+	rebooked.RebookedFrom = booking.ID
+	rebooked.Passengers = append([]models.Passenger{}, booking.Passengers...)
+	rebooked.Modifications = append([]models.Modification{}, booking.Modifications...)
+	record := &models.Rebooking{
+		FlightID:     booking.FlightID,
+		UserID:       booking.UserID,
+		BookingID:    booking.ID,
+		Outcome:      models.RebookingOutcomeRebooked,
+		NewFlightID:  newFlight.ID,
+		NewBookingID: rebooked.ID,
+		Time:         now,
+	}
+	for i := range rebooked.Passengers {
+		passenger := &rebooked.Passengers[i]
+		seat, err := reserveSeat(txn, userID, newFlight.ID, seats[i], now)
+		if err != nil {
+			return err
+		}
+		record.Seats = append(record.Seats, models.SeatChange{
+			Passenger: passenger.FullName(),
+			From:      passenger.Seat,
+			To:        seat.Seat,
+		})
+		rebooked.Modifications = append(rebooked.Modifications, models.Modification{
+			Time:       now,
+			Type:       models.ModificationRebook,
+			Passenger:  i,
+			From:       fmt.Sprintf("%s/%s", booking.FlightID, passenger.Seat),
+			To:         fmt.Sprintf("%s/%s", newFlight.ID, seat.Seat),
+			PriceDelta: money.Zero(booking.Price.Currency),
+		})
+		passenger.Seat = seat.Seat
+		passenger.Cabin = seat.Cabin()
+		passenger.CheckedInAt = nil
+		passenger.BoardingGroup = ""
+		passenger.BoardingSequence = 0
+	}
+	if err := s.releaseSeats(txn, booking, now); err != nil {
+		return err
+	}
+
+	booking.Status = models.BookingStatusRebooked
+	booking.RebookedTo = rebooked.ID
+	booking.PaymentDeadline = nil
+	updates := []database.Model{booking, &rebooked, record}
+	if booking.Reference != "" {
+		updates = append(updates, &models.BookingReference{
+			Reference: booking.Reference,
+			UserID:    booking.UserID,
+			BookingID: rebooked.ID,
+		})
+	}
+	if err := emitEvent(txn, models.EventBookingRebooked, &rebooked); err != nil {
+		return err
+	}
+	return txn.Put(updates...)
+}
+
+// recordFailedRebooking stores the failed attempt once, later attempts only update the version of the seats
+// that was checked.
+func (s *Service) recordFailedRebooking(booking *models.Booking, reason string, seatsVersion uint64, now time.Time) error {
+	return s.db.Update(func(txn *database.Txn) error {
+		record := &models.Rebooking{FlightID: booking.FlightID, BookingID: booking.ID}
+		if err := txn.Get(record.Key(), record); err == nil {
+			record.SeatsVersion = seatsVersion
+			return txn.Put(record)
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		s.logger(txn.Context()).Warnf("could not rebook booking %s of cancelled flight %s: %s", booking.ID, booking.FlightID, reason)
+		record.UserID = booking.UserID
+		record.Outcome = models.RebookingOutcomeFailed
+		record.Reason = reason
+		record.Time = now
+		record.SeatsVersion = seatsVersion
+		return txn.Put(record)
+	})
+}
+
+// rebookBooking moves the booking to the next flight on the same route with enough seats.
+func (s *Service) rebookBooking(booking *models.Booking, cancelled *models.Flight, flights []*models.Flight, now time.Time) error {
+	// seats that change after this point are checked by the next attempt
+	seatsVersion := s.db.Version("seats").Seq
+	for _, candidate := range rebookingCandidates(flights, cancelled, now) {
+		seats, err := flightSeats(s.db, candidate.ID)
+		if err != nil {
+			return err
+		}
+		picked, ok := pickSeats(seats, booking.Passengers, booking.UserID, now)
+		if !ok {
+			continue
+		}
+		err = s.db.Update(func(txn *database.Txn) error {
+			return s.moveBooking(txn, booking.UserID, booking.ID, candidate, picked, now)
+		})
+		var reqErr *requestError
+		if errors.As(err, &reqErr) || errors.Is(err, badger.ErrConflict) {
+			// the picked seats were taken in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		s.log.Infof("rebooked booking %s of cancelled flight %s to flight %s", booking.ID, cancelled.ID, candidate.ID)
+		return nil
+	}
+	return s.recordFailedRebooking(booking, "no flight on the same route with enough seats", seatsVersion, now)
+}
+
+// rebookCancelledFlights moves all open bookings of cancelled flights to other flights.
+func (s *Service) rebookCancelledFlights(now time.Time) error {
+	flights, err := database.Values[*models.Flight](s.db)
+	if err != nil {
+		return err
+	}
+	cancelled := make(map[string]*models.Flight)
+	for _, flight := range flights {
+		if flight.Status == models.FlightStatusCancelled {
+			cancelled[flight.ID] = flight
+		}
+	}
+	if len(cancelled) == 0 {
+		return nil
+	}
+	bookings, err := database.Values[*models.Booking](s.db)
+	if err != nil {
+		return err
+	}
+	rebookings, err := database.Values[*models.Rebooking](s.db)
+	if err != nil {
+		return err
+	}
+	failed := make(map[string]*models.Rebooking)
+	for _, rebooking := range rebookings {
+		if rebooking.Outcome == models.RebookingOutcomeFailed {
+			failed[rebooking.Key()] = rebooking
+		}
+	}
+	seatsVersion := s.db.Version("seats").Seq
+	for _, booking := range bookings {
+		flight := cancelled[booking.FlightID]
+		if flight == nil {
+			continue
+		}
+		if booking.Status != models.BookingStatusConfirmed && booking.Status != models.BookingStatusPendingPayment {
+			continue
+		}
+		// failed bookings are only retried if a seat changed since the last attempt
+		if record := failed[fmt.Sprintf("%s/%s", booking.FlightID, booking.ID)]; record != nil && record.SeatsVersion >= seatsVersion {
+			continue
+		}
+		if err := s.rebookBooking(booking, flight, flights, now); err != nil {
+			s.log.Errorf("could not rebook booking %s of cancelled flight %s: %v", booking.ID, flight.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) handlerGetRebookings(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	rebookings, err := database.Values[*models.Rebooking](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, rebookings)
+}
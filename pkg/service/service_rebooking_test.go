@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebookCancelledFlights(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	departure := time.Now().Add(72 * time.Hour)
+	require.NoError(t, s.db.Put(
+		// not enough seats
+		&models.Flight{ID: "124", From: "AAA", To: "BBB", Status: models.FlightStatusScheduled, Departure: departure.Add(time.Hour)},
+		&models.Seat{FlightID: "124", Seat: "1A", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		// other route
+		&models.Flight{ID: "125", From: "AAA", To: "CCC", Status: models.FlightStatusScheduled, Departure: departure.Add(2 * time.Hour)},
+		&models.Seat{FlightID: "125", Seat: "1A", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		&models.Seat{FlightID: "125", Seat: "1B", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		&models.Flight{ID: "126", From: "AAA", To: "BBB", Status: models.FlightStatusDelayed, Departure: departure.Add(3 * time.Hour)},
+		&models.Seat{FlightID: "126", Seat: "1A", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		&models.Seat{FlightID: "126", Seat: "7A", Row: 7, Price: money.New(5000, "EUR"), Available: true},
+		&models.Seat{FlightID: "126", Seat: "7B", Row: 7, Price: money.New(5000, "EUR"), Available: true},
+	))
+	booking := createConfirmedBooking(t, s, "B1", "C1")
+
+	// nothing happens as long as the flight is not cancelled
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	flight, err := database.Get[*models.Flight](s.db, "123")
+	require.NoError(t, err)
+	flight.Status = models.FlightStatusCancelled
+	require.NoError(t, s.db.Put(flight))
+
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	original, err := database.Get[*models.Booking](s.db, "user/"+booking.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.BookingStatusRebooked, original.Status)
+	require.NotEmpty(t, original.RebookedTo)
+
+	rebooked, err := database.Get[*models.Booking](s.db, "user/"+original.RebookedTo)
+	require.NoError(t, err)
+	require.Equal(t, models.BookingStatusConfirmed, rebooked.Status)
+	require.Equal(t, "126", rebooked.FlightID)
+	require.Equal(t, booking.ID, rebooked.RebookedFrom)
+	require.Equal(t, booking.Price, rebooked.Price)
+	require.Equal(t, booking.Reference, rebooked.Reference)
+	// the business cabin is preferred, the second passenger gets an economy seat
+	require.Equal(t, "1A", rebooked.Passengers[0].Seat)
+	require.Equal(t, "7A", rebooked.Passengers[1].Seat)
+	require.Len(t, rebooked.Modifications, 2)
+	require.Equal(t, "123/B1", rebooked.Modifications[0].From)
+	require.Equal(t, "126/1A", rebooked.Modifications[0].To)
+
+	seat, err := database.Get[*models.Seat](s.db, "126/7A")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+	seat, err = database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	res := sendRequest(s, "GET", "/bookings/by-reference/"+booking.Reference+"?lastName=Doe", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var byReference models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &byReference))
+	require.Equal(t, rebooked.ID, byReference.ID)
+
+	// the job is idempotent
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	bookings, err := database.Values[*models.Booking](s.db, "user")
+	require.NoError(t, err)
+	require.Len(t, bookings, 2)
+
+	var rebookings []models.Rebooking
+	res = sendRequest(s, "GET", "/admin/rebookings", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rebookings))
+	require.Len(t, rebookings, 1)
+	require.Equal(t, models.RebookingOutcomeRebooked, rebookings[0].Outcome)
+	require.Equal(t, "126", rebookings[0].NewFlightID)
+	require.Len(t, rebookings[0].Seats, 2)
+}
+
+func TestRebookingWithoutAlternative(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	booking := createConfirmedBooking(t, s, "B1")
+	flight, err := database.Get[*models.Flight](s.db, "123")
+	require.NoError(t, err)
+	flight.Status = models.FlightStatusCancelled
+	require.NoError(t, s.db.Put(flight))
+
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	rebookings, err := database.Values[*models.Rebooking](s.db)
+	require.NoError(t, err)
+	require.Len(t, rebookings, 1)
+	require.Equal(t, models.RebookingOutcomeFailed, rebookings[0].Outcome)
+	require.Equal(t, booking.ID, rebookings[0].BookingID)
+
+	original, err := database.Get[*models.Booking](s.db, "user/"+booking.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.BookingStatusConfirmed, original.Status)
+
+	// the cancelled flight cannot be booked anymore
+	bookingRequest := &models.Booking{FlightID: "123", Passengers: []models.Passenger{testPassenger("Jane", "Roe", "C1")}, PaymentToken: "tok_visa"}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+	require.Contains(t, res.Body.String(), "flight cannot be booked anymore")
+
+	// the failed booking is retried once a seat changed
+	require.Equal(t, rebookings[0].SeatsVersion, s.db.Version("seats").Seq)
+	require.NoError(t, s.db.Put(&models.Flight{ID: "456", From: flight.From, To: flight.To, Departure: flight.Departure.Add(time.Hour), Status: models.FlightStatusScheduled}))
+	require.NoError(t, s.db.Put(&models.Seat{FlightID: "456", Seat: "A1", Row: 1, Available: true, Price: money.New(1000, "EUR")}))
+	require.NoError(t, s.rebookCancelledFlights(time.Now()))
+	original, err = database.Get[*models.Booking](s.db, "user/"+booking.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.BookingStatusRebooked, original.Status)
+}
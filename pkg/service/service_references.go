@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxReferenceAttempts = 10
+
+// assignReference generates an unused record locator for the booking and stores the lookup index.
+func assignReference(txn *database.Txn, booking *models.Booking) error {
+	for i := 0; i < maxReferenceAttempts; i++ {
+		reference, err := models.NewReference()
+		if err != nil {
+			return err
+		}
+		err = txn.Get(reference, &models.BookingReference{})
+		if err == nil {
+			continue
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		booking.Reference = reference
+		return txn.Put(&models.BookingReference{
+			Reference: reference,
+			UserID:    booking.UserID,
+			BookingID: booking.ID,
+		})
+	}
+	return fmt.Errorf("could not generate unique booking reference after %d attempts", maxReferenceAttempts)
+}
+
+// handlerGetBookingByReference allows passengers to look up a booking with the record locator and the
+// last name of one of the passengers.
+func (s *Service) handlerGetBookingByReference(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	lastName := strings.TrimSpace(r.URL.Query().Get("lastName"))
+	if lastName == "" {
+		s.sendError(w, r, "missing last name", http.StatusBadRequest)
+		return
+	}
+	var booking *models.Booking
+	err := db.View(func(txn *database.Txn) error {
+		var ref models.BookingReference
+		if err := txn.Get(models.NormalizeReference(chi.URLParam(r, "pnr")), &ref); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return newRequestError(http.StatusNotFound, "booking not found")
+			}
+			return err
+		}
+		var err error
+		booking, err = getBooking(txn, ref.UserID, ref.BookingID)
+		return err
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	found := false
+	for i := range booking.Passengers {
+		if strings.EqualFold(strings.TrimSpace(booking.Passengers[i].LastName), lastName) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		// same response as an unknown reference to not reveal valid references
+		s.sendError(w, r, "booking not found", http.StatusNotFound)
+		return
+	}
+	// payment details are only visible to the owner of the booking
+	booking.PaymentID = ""
+	booking.Payments = nil
+	s.writeJSON(w, r, booking)
+}
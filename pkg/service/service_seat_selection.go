@@ -0,0 +1,270 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/websocket"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// seatSelectionSendBuffer is the number of messages that are queued for a connection before it is closed.
+	seatSelectionSendBuffer = 64
+	// seatSelectionReadLimit is the maximum size of a message from the client.
+	seatSelectionReadLimit = 4 << 10
+	// seatSelectionWriteTimeout is the time a client has to receive a message.
+	seatSelectionWriteTimeout = 10 * time.Second
+)
+
+var errSendBufferFull = errors.New("send buffer full")
+
+type seatSelectionRequest struct {
+	Type string `json:"type"`
+	Seat string `json:"seat"`
+}
+
+type seatSelectionMessage struct {
+	Type      string              `json:"type"`
+	Flight    json.RawMessage     `json:"flight,omitempty"`
+	Seat      string              `json:"seat,omitempty"`
+	Details   *seatAvailability   `json:"details,omitempty"`
+	Seats     []*seatAvailability `json:"seats,omitempty"`
+	ExpiresAt *time.Time          `json:"expiresAt,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// seatSelection is a WebSocket connection of a user to the seats of a flight. Messages are queued and
+// written by a separate goroutine, a client that does not keep up is disconnected.
+type seatSelection struct {
+	svc      *Service
+	log      *logger.Logger
+	conn     *websocket.Conn
+	userID   string
+	flightID string
+	send     chan []byte
+}
+
+// queue adds the message to the send queue and reports false if the queue is full.
+func (c *seatSelection) queue(msg *seatSelectionMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.log.Errorf("json write error: %v", err)
+		return true
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *seatSelection) availability(seat *models.Seat) *seatAvailability {
+	return &seatAvailability{
+		Seat:      seat.Seat,
+		Row:       seat.Row,
+		Cabin:     seat.Cabin(),
+		Price:     seat.Price,
+		Available: seat.Available,
+		Held:      !seat.Available && seat.HeldBy == c.userID,
+	}
+}
+
+// snapshot queues the flight and all of its seats in a single message.
+func (c *seatSelection) snapshot() (bool, error) {
+	flight, err := c.svc.db.RawGet("flights", c.flightID)
+	if err != nil {
+		return false, err
+	}
+	seats, err := flightSeats(c.svc.db, c.flightID)
+	if err != nil {
+		return false, err
+	}
+	msg := &seatSelectionMessage{Type: "snapshot", Flight: flight, Seats: make([]*seatAvailability, 0, len(seats))}
+	for _, seat := range seats {
+		msg.Seats = append(msg.Seats, c.availability(seat))
+	}
+	return c.queue(msg), nil
+}
+
+func (c *seatSelection) change(change database.Change) (bool, error) {
+	if change.Collection == "flights" {
+		return c.queue(&seatSelectionMessage{Type: "flight", Flight: change.Value}), nil
+	}
+	var seat models.Seat
+	if err := json.Unmarshal(change.Value, &seat); err != nil {
+		return false, err
+	}
+	return c.queue(&seatSelectionMessage{Type: "seat", Seat: seat.Seat, Details: c.availability(&seat)}), nil
+}
+
+// handle executes a request of the client and returns the reply.
+func (c *seatSelection) handle(req *seatSelectionRequest) *seatSelectionMessage {
+	now := time.Now()
+	reply := &seatSelectionMessage{Seat: req.Seat}
+	var err error
+	switch req.Type {
+	case "hold":
+		expiresAt := now.Add(c.svc.SeatHoldDuration)
+		err = c.svc.db.Update(func(txn *database.Txn) error {
+			return c.svc.holdSeat(txn, c.userID, c.flightID, req.Seat, now, expiresAt)
+		})
+		reply.Type = "held"
+		reply.ExpiresAt = &expiresAt
+	case "release":
+		err = c.svc.db.Update(func(txn *database.Txn) error {
+			return c.svc.releaseHold(txn, c.userID, c.flightID, req.Seat, now)
+		})
+		reply.Type = "released"
+	default:
+		err = newRequestError(http.StatusBadRequest, "unknown message type")
+	}
+	var reqErr *requestError
+	switch {
+	case err == nil:
+		return reply
+	case errors.As(err, &reqErr):
+		return &seatSelectionMessage{Type: "error", Seat: req.Seat, Error: reqErr.message}
+	case errors.Is(err, badger.ErrConflict):
+		return &seatSelectionMessage{Type: "error", Seat: req.Seat, Error: "conflicting update, please retry"}
+	default:
+		c.log.Errorf("seat selection of flight %s failed: %v", c.flightID, err)
+		return &seatSelectionMessage{Type: "error", Seat: req.Seat, Error: "internal error"}
+	}
+}
+
+// read handles the requests of the client until the connection is closed.
+func (c *seatSelection) read() error {
+	for {
+		opcode, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var req seatSelectionRequest
+		if opcode != websocket.TextMessage || json.Unmarshal(data, &req) != nil {
+			if !c.queue(&seatSelectionMessage{Type: "error", Error: "invalid message"}) {
+				return errSendBufferFull
+			}
+			continue
+		}
+		if !c.queue(c.handle(&req)) {
+			return errSendBufferFull
+		}
+	}
+}
+
+// write sends the queued messages until done is closed.
+func (c *seatSelection) write(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case data := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(seatSelectionWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				// the read loop ends when the connection is closed
+				_ = c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// run streams the seat changes of the flight to the client until the connection ends and returns the
+// status to close the connection with.
+func (c *seatSelection) run(sub *database.Subscription) (int, string) {
+	ok, err := c.snapshot()
+	if err != nil {
+		c.log.Errorf("seat selection of flight %s failed: %v", c.flightID, err)
+		return websocket.CloseInternalError, "internal error"
+	} else if !ok {
+		return websocket.CloseTryAgainLater, errSendBufferFull.Error()
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.read()
+	}()
+	ping := time.NewTicker(c.svc.EventHeartbeat)
+	defer ping.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			if errors.Is(err, errSendBufferFull) {
+				return websocket.CloseTryAgainLater, err.Error()
+			}
+			// a close of the client was already confirmed
+			return websocket.CloseNormalClosure, ""
+		case <-c.svc.streamsDone:
+			return websocket.CloseGoingAway, "server shutting down"
+		case <-ping.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(seatSelectionWriteTimeout))
+			if err := c.conn.WritePing(nil); err != nil {
+				return websocket.CloseNormalClosure, ""
+			}
+		case change, ok := <-sub.C:
+			if !ok {
+				return websocket.CloseTryAgainLater, "too many changes"
+			}
+			queued, err := c.change(change)
+			if err != nil {
+				c.log.Errorf("seat selection of flight %s failed: %v", c.flightID, err)
+				return websocket.CloseInternalError, "internal error"
+			} else if !queued {
+				return websocket.CloseTryAgainLater, errSendBufferFull.Error()
+			}
+		}
+	}
+}
+
+// handlerSeatSelection upgrades the request to a WebSocket connection for the seat selection of the flight.
+// The client receives the flight and all seats followed by every change and places or releases temporary
+// holds. All viewers of the flight receive the changes.
+func (s *Service) handlerSeatSelection(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	flightID := chi.URLParam(r, "id")
+	if _, err := database.Get[*models.Flight](db, flightID); errors.Is(err, badger.ErrKeyNotFound) {
+		s.sendError(w, r, "flight not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// subscribe before the snapshot is sent, changes in between are sent twice but never lost
+	sub := db.Subscribe(eventBufferSize, flightChanges(flightID))
+	defer sub.Close()
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		s.logger(r.Context()).Warnf("could not upgrade seat selection of flight %s: %v", flightID, err)
+		return
+	}
+	defer conn.Close()
+	conn.ReadLimit = seatSelectionReadLimit
+
+	c := &seatSelection{
+		svc:      s,
+		log:      s.logger(r.Context()),
+		conn:     conn,
+		userID:   userID,
+		flightID: flightID,
+		send:     make(chan []byte, seatSelectionSendBuffer),
+	}
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		c.write(done)
+		close(writerDone)
+	}()
+	code, reason := c.run(sub)
+	close(done)
+	<-writerDone
+	_ = conn.WriteClose(code, reason)
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/websocket"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func dialSeatSelection(t *testing.T, srv *httptest.Server, flightID, user string) (*websocket.Conn, *http.Response, error) {
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	setUserAuth(user)(req)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/flights/" + flightID + "/seat-selection"
+	conn, res, err := websocket.Dial(url, req.Header)
+	if conn != nil {
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+	}
+	return conn, res, err
+}
+
+// readSeatSelection returns the next message of the type.
+func readSeatSelection(t *testing.T, conn *websocket.Conn, msgType string) seatSelectionMessage {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var msg seatSelectionMessage
+		require.NoError(t, json.Unmarshal(data, &msg))
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func sendSeatSelection(t *testing.T, conn *websocket.Conn, msgType, seat string) {
+	data, err := json.Marshal(seatSelectionRequest{Type: msgType, Seat: seat})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+}
+
+func TestSeatSelection(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	s.Auth["other"] = "pw"
+	s.MaxSeatHolds = 1
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, res, err := dialSeatSelection(t, srv, "123", "unknown")
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	_, res, err = dialSeatSelection(t, srv, "unknown", "user")
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	user, _, err := dialSeatSelection(t, srv, "123", "user")
+	require.NoError(t, err)
+	other, _, err := dialSeatSelection(t, srv, "123", "other")
+	require.NoError(t, err)
+	snapshot := readSeatSelection(t, user, "snapshot")
+	require.Contains(t, string(snapshot.Flight), `"id":"123"`)
+	require.Len(t, snapshot.Seats, 4)
+	readSeatSelection(t, other, "snapshot")
+
+	// the hold is broadcast to all viewers
+	sendSeatSelection(t, user, "hold", "B1")
+	held := readSeatSelection(t, user, "held")
+	require.Equal(t, "B1", held.Seat)
+	require.NotNil(t, held.ExpiresAt)
+	seat := readSeatSelection(t, other, "seat")
+	require.Equal(t, "B1", seat.Seat)
+	require.False(t, seat.Details.Available)
+	require.False(t, seat.Details.Held)
+	seat = readSeatSelection(t, user, "seat")
+	require.True(t, seat.Details.Held)
+
+	sendSeatSelection(t, other, "hold", "B1")
+	require.Equal(t, "seat not available", readSeatSelection(t, other, "error").Error)
+	sendSeatSelection(t, other, "release", "B1")
+	require.Equal(t, "hold not found", readSeatSelection(t, other, "error").Error)
+	sendSeatSelection(t, other, "hold", "A1")
+	require.Equal(t, "seat not available", readSeatSelection(t, other, "error").Error)
+	sendSeatSelection(t, other, "book", "C1")
+	require.Equal(t, "unknown message type", readSeatSelection(t, other, "error").Error)
+	require.NoError(t, other.WriteMessage(websocket.TextMessage, []byte("{")))
+	require.Equal(t, "invalid message", readSeatSelection(t, other, "error").Error)
+
+	// the held seat can be booked by the user
+	createConfirmedBooking(t, s, "B1")
+	holds, err := database.Values[*models.SeatHold](s.db)
+	require.NoError(t, err)
+	require.Empty(t, holds)
+
+	sendSeatSelection(t, user, "hold", "C1")
+	readSeatSelection(t, user, "held")
+	sendSeatSelection(t, other, "hold", "C1")
+	require.Equal(t, "seat not available", readSeatSelection(t, other, "error").Error)
+	require.NoError(t, s.db.Put(&models.Seat{FlightID: "123", Seat: "D1", Row: 1, Available: true}))
+	sendSeatSelection(t, user, "hold", "D1")
+	require.Equal(t, "at most 1 seats can be held", readSeatSelection(t, user, "error").Error)
+	// holding a seat again renews the hold
+	sendSeatSelection(t, user, "hold", "C1")
+	readSeatSelection(t, user, "held")
+
+	sendSeatSelection(t, user, "release", "C1")
+	require.Equal(t, "C1", readSeatSelection(t, user, "released").Seat)
+	seat = readSeatSelection(t, other, "seat")
+	for seat.Seat != "C1" || !seat.Details.Available {
+		seat = readSeatSelection(t, other, "seat")
+	}
+
+	require.NoError(t, user.WriteClose(websocket.CloseNormalClosure, ""))
+	require.True(t, websocket.IsClose(readClose(user), websocket.CloseNormalClosure))
+
+	// open connections are closed when the service shuts down
+	s.CloseStreams()
+	require.True(t, websocket.IsClose(readClose(other), websocket.CloseGoingAway))
+}
+
+// readClose skips the pending messages and returns the error that ended the connection.
+func readClose(conn *websocket.Conn) error {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestReleaseExpiredHolds(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	now := time.Now()
+	require.NoError(t, s.db.Update(func(txn *database.Txn) error {
+		return s.holdSeat(txn, "user", "123", "B1", now, now.Add(s.SeatHoldDuration))
+	}))
+
+	require.NoError(t, s.releaseExpiredHolds(now))
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+	require.Equal(t, "user", seat.HeldBy)
+
+	require.NoError(t, s.releaseExpiredHolds(now.Add(s.SeatHoldDuration)))
+	seat, err = database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+	require.Empty(t, seat.HeldBy)
+	holds, err := database.Values[*models.SeatHold](s.db)
+	require.NoError(t, err)
+	require.Empty(t, holds)
+}
+
+func TestSeatHoldLimit(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	s.MaxSeatHolds = 1
+	now := time.Now()
+	require.NoError(t, s.db.Put(&models.SeatHold{FlightID: "123", Seat: "A1", UserID: "user", ExpiresAt: now.Add(-time.Second)}))
+
+	// the expired hold is not counted, the concurrent hold of the same user conflicts
+	err := s.db.Update(func(txn *database.Txn) error {
+		if err := s.holdSeat(txn, "user", "123", "B1", now, now.Add(s.SeatHoldDuration)); err != nil {
+			return err
+		}
+		return s.db.Update(func(txn *database.Txn) error {
+			return s.holdSeat(txn, "user", "123", "C1", now, now.Add(s.SeatHoldDuration))
+		})
+	})
+	require.ErrorIs(t, err, badger.ErrConflict)
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	err = s.db.Update(func(txn *database.Txn) error {
+		return s.holdSeat(txn, "user", "123", "B1", now, now.Add(s.SeatHoldDuration))
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "at most 1 seats can be held")
+}
+
+func TestSeatSelectionBackpressure(t *testing.T) {
+	c := &seatSelection{send: make(chan []byte, 1)}
+	require.True(t, c.queue(&seatSelectionMessage{Type: "seat"}))
+	require.False(t, c.queue(&seatSelectionMessage{Type: "seat"}))
+}
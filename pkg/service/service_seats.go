@@ -0,0 +1,97 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// takeSeat marks the seat as unavailable for the user. If the seat was held for the user by a waitlist
+// offer, the offer is claimed, a temporary hold of the seat selection is removed. The caller has to store the seat.
+func takeSeat(txn *database.Txn, userID string, seat *models.Seat, now time.Time) error {
+	if !seat.AvailableFor(userID, now) {
+		return errSeatNotAvailable
+	}
+	if seat.HeldBy != "" {
+		if err := claimWaitlistOffer(txn, userID, seat); err != nil {
+			return err
+		}
+		if err := txn.Delete(&models.SeatHold{FlightID: seat.FlightID, Seat: seat.Seat}); err != nil {
+			return err
+		}
+		seat.ClearHold()
+	}
+	seat.Available = false
+	return nil
+}
+
+// reserveSeat marks an available seat of the flight as unavailable for the user.
+func reserveSeat(txn *database.Txn, userID, flightID, seatID string, now time.Time) (*models.Seat, error) {
+	var seat models.Seat
+	if err := txn.Get(fmt.Sprintf("%s/%s", flightID, seatID), &seat); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, errSeatNotFound
+		}
+		return nil, err
+	}
+	if err := takeSeat(txn, userID, &seat, now); err != nil {
+		return nil, err
+	}
+	if err := txn.Put(&seat); err != nil {
+		return nil, err
+	}
+	return &seat, nil
+}
+
+// releaseSeat makes a seat of the flight available again, or offers it to the next user on the waitlist.
+func (s *Service) releaseSeat(txn *database.Txn, flightID, seatID string, now time.Time) error {
+	var seat models.Seat
+	if err := txn.Get(fmt.Sprintf("%s/%s", flightID, seatID), &seat); err != nil {
+		return err
+	}
+	seat.ClearHold()
+	seat.Available = true
+	if err := s.offerSeat(txn, &seat, now); err != nil {
+		return err
+	}
+	return txn.Put(&seat)
+}
+
+// releasePassengerSeat releases the seat of the passenger, or the overbooking slot of a passenger
+// without a seat.
+func (s *Service) releasePassengerSeat(txn *database.Txn, flightID string, passenger *models.Passenger, now time.Time) error {
+	if passenger.Seat == "" {
+		return releaseOverbookingSlot(txn, flightID)
+	}
+	return s.releaseSeat(txn, flightID, passenger.Seat, now)
+}
+
+// releaseSeats makes the seats of all passengers of the booking available again. The check-in of the
+// passengers is revoked, because their seats can be booked by others.
+func (s *Service) releaseSeats(txn *database.Txn, booking *models.Booking, now time.Time) error {
+	for i := range booking.Passengers {
+		passenger := &booking.Passengers[i]
+		if err := s.releasePassengerSeat(txn, booking.FlightID, passenger, now); err != nil {
+			return err
+		}
+		passenger.CheckedInAt = nil
+		passenger.BoardingGroup = ""
+		passenger.BoardingSequence = 0
+	}
+	return nil
+}
+
+// assignSeat copies the seat, its price and fare rules to the passenger.
+func assignSeat(passenger *models.Passenger, seat *models.Seat) {
+	passenger.Seat = seat.Seat
+	passenger.Cabin = seat.Cabin()
+	passenger.Price = seat.Price
+	passenger.Fare = seat.Fare
+	if seat.Fare == nil {
+		passenger.Fare = models.DefaultFareRules(seat.Price.Currency)
+	}
+}
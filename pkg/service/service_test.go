@@ -2,18 +2,23 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/christophwitzko/flight-booking-service/pkg/database"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
 	"github.com/christophwitzko/flight-booking-service/pkg/database/seeder"
 	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/money"
+	"github.com/christophwitzko/flight-booking-service/pkg/payment"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,18 +32,32 @@ func sendRequest(s http.Handler, method, path string, body io.Reader, modReqFns
 	return rr
 }
 
-var testUser = []string{"user", "pw"}
+var (
+	testUser  = []string{"user", "pw"}
+	testAdmin = []string{"admin", "admin-pw"}
+)
 
 func setBasicAuth(req *http.Request) {
 	req.SetBasicAuth(testUser[0], testUser[1])
 }
 
+func setAdminAuth(req *http.Request) {
+	req.SetBasicAuth(testAdmin[0], testAdmin[1])
+}
+
+func jsonBody(t *testing.T, v any) io.Reader {
+	buf := &bytes.Buffer{}
+	require.NoError(t, json.NewEncoder(buf).Encode(v))
+	return buf
+}
+
 func initService(t *testing.T) *Service {
 	db, err := database.New()
 	require.NoError(t, err)
 	require.NoError(t, seeder.Seed(db, 100))
 	s := New(logger.NewNop(), db)
 	s.Auth[testUser[0]] = testUser[1]
+	s.AdminAuth[testAdmin[0]] = testAdmin[1]
 	return s
 }
 
@@ -101,11 +120,11 @@ func TestGetFlight(t *testing.T) {
 
 func putBookingRequestData(s *Service) error {
 	seats := []database.Model{
-		&models.Flight{ID: "123", From: "AAA", To: "BBB", Status: "test"},
-		&models.Seat{FlightID: "123", Seat: "A1", Row: 1, Price: 10, Available: false},
-		&models.Seat{FlightID: "123", Seat: "B1", Row: 1, Price: 10, Available: true},
-		&models.Seat{FlightID: "123", Seat: "C1", Row: 1, Price: 10, Available: true},
-		&models.Seat{FlightID: "123", Seat: "F3", Row: 3, Price: 10, Available: false},
+		&models.Flight{ID: "123", From: "AAA", To: "BBB", Status: "test", Departure: time.Now().Add(72 * time.Hour)},
+		&models.Seat{FlightID: "123", Seat: "A1", Row: 1, Price: money.New(1000, "EUR"), Available: false},
+		&models.Seat{FlightID: "123", Seat: "B1", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		&models.Seat{FlightID: "123", Seat: "C1", Row: 1, Price: money.New(1000, "EUR"), Available: true},
+		&models.Seat{FlightID: "123", Seat: "F3", Row: 3, Price: money.New(1000, "EUR"), Available: false},
 	}
 	return s.db.Put(seats...)
 }
@@ -127,9 +146,10 @@ func TestCreateBooking(t *testing.T) {
 	bookingRequest := &models.Booking{
 		FlightID: "123",
 		Passengers: []models.Passenger{
-			{Name: "John", Seat: "B1"},
-			{Name: "Jane", Seat: "C1"},
+			testPassenger("John", "Doe", "B1"),
+			testPassenger("Jane", "Doe", "C1"),
 		},
+		PaymentToken: "tok_visa",
 	}
 	buf := &bytes.Buffer{}
 	require.NoError(t, json.NewEncoder(buf).Encode(bookingRequest))
@@ -140,8 +160,23 @@ func TestCreateBooking(t *testing.T) {
 	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bookingResponse))
 	require.Equal(t, bookingRequest.FlightID, bookingResponse.FlightID)
 	require.Equal(t, bookingResponse.Passengers, bookingResponse.Passengers)
-	require.Equal(t, 20, bookingResponse.Price)
-	require.Equal(t, "confirmed", bookingResponse.Status)
+	require.Equal(t, money.New(2000, "EUR"), bookingResponse.Price)
+	require.Equal(t, models.BookingStatusPendingPayment, bookingResponse.Status)
+	require.NotEmpty(t, bookingResponse.PaymentID)
+	require.Empty(t, bookingResponse.PaymentToken)
+
+	res = sendRequest(s, "POST", "/bookings/"+bookingResponse.ID+"/confirm", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	bookingResponse = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bookingResponse))
+	require.Equal(t, models.BookingStatusConfirmed, bookingResponse.Status)
+	require.Nil(t, bookingResponse.PaymentDeadline)
+	payment, ok := s.Payments.(*payment.Fake).Payment(bookingResponse.PaymentID)
+	require.True(t, ok)
+	require.True(t, payment.Captured)
+
+	res = sendRequest(s, "POST", "/bookings/"+bookingResponse.ID+"/confirm", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
 
 	var bookings []*models.Booking
 	res = sendRequest(s, "GET", "/bookings", nil, setBasicAuth)
@@ -170,8 +205,8 @@ func TestCreateInvalidBooking(t *testing.T) {
 	bookingRequest := &models.Booking{
 		FlightID: "123",
 		Passengers: []models.Passenger{
-			{Name: "John", Seat: "A1"},
-			{Name: "Jane", Seat: "B1"},
+			testPassenger("John", "Doe", "A1"),
+			testPassenger("Jane", "Doe", "B1"),
 		},
 	}
 	buf := &bytes.Buffer{}
@@ -180,6 +215,592 @@ func TestCreateInvalidBooking(t *testing.T) {
 	require.Equal(t, http.StatusBadRequest, res.Code)
 }
 
+func TestCreateBookingInvalidPassengers(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	infant := testPassenger("Baby", "Doe", "C1")
+	infant.DateOfBirth = models.NewDate(time.Now().Year(), time.January, 1)
+	infant.Type = models.PassengerTypeInfant
+	infant.Email = ""
+	adult := testPassenger("", "Doe", "B1")
+	adult.Email = "john.doe"
+	adult.Document = &models.TravelDocument{
+		Type: models.DocumentTypePassport, Number: "C01X00T47", Country: "DEU",
+		Expiry: models.NewDate(2000, time.January, 1),
+	}
+	adult.Type = models.PassengerTypeChild
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{adult, infant},
+		PaymentToken: "tok_visa",
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	var body struct {
+		Error  string              `json:"error"`
+		Fields []models.FieldError `json:"fields"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	require.Equal(t, "validation failed", body.Error)
+	fields := make(map[string]string)
+	for _, field := range body.Fields {
+		fields[field.Field] = field.Message
+	}
+	require.Equal(t, "required", fields["passengers[0].firstName"])
+	require.Equal(t, "invalid email address", fields["passengers[0].email"])
+	require.Equal(t, "does not match date of birth, expected adult", fields["passengers[0].type"])
+	require.Equal(t, "must be after the travel date", fields["passengers[0].document.expiry"])
+	require.Equal(t, "every infant must travel with an adult", fields["passengers"])
+
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+}
+
+func TestCreateBookingDuplicateSeat(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1"), testPassenger("Jane", "Doe", "B1")},
+		PaymentToken: "tok_visa",
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	var body struct {
+		Fields []models.FieldError `json:"fields"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	require.Len(t, body.Fields, 1)
+	require.Equal(t, "passengers[1].seat", body.Fields[0].Field)
+	require.Equal(t, "already taken by another passenger", body.Fields[0].Message)
+
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+}
+
+func TestCreateBookingWithPromoCode(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	promoCode := &models.PromoCode{Type: models.DiscountPercentage, Value: 25, MaxUsesPerUser: 1, Routes: []string{"AAA-BBB"}}
+	res := sendRequest(s, "PUT", "/admin/promocodes/spring25", jsonBody(t, promoCode))
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	res = sendRequest(s, "PUT", "/admin/promocodes/spring25", jsonBody(t, promoCode), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		PromoCode:    "Spring25",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1")},
+		PaymentToken: "tok_visa",
+	}
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, money.New(1000, "EUR"), booking.BasePrice)
+	require.Equal(t, money.New(250, "EUR"), booking.Discount)
+	require.Equal(t, money.New(750, "EUR"), booking.Price)
+	require.Equal(t, "SPRING25", booking.PromoCode)
+
+	// the per user limit is reached and the seat must stay available
+	bookingRequest.Passengers[0].Seat = "C1"
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	seat, err := database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	res = sendRequest(s, "GET", "/admin/promocodes/SPRING25", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var storedPromoCode models.PromoCode
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &storedPromoCode))
+	require.Equal(t, 1, storedPromoCode.Uses)
+}
+
+func TestQuoteBookingInCurrency(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	s.Rates = mustParseRates(t, `{"base": "EUR", "rates": {"USD": "1.5"}}`)
+
+	// a valid ISO currency without an exchange rate cannot be converted
+	promoCode := &models.PromoCode{Type: models.DiscountFixed, Value: 300, Currency: "gbp"}
+	res := sendRequest(s, "PUT", "/admin/promocodes/FIXED", jsonBody(t, promoCode), setAdminAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	promoCode.Currency = "usd"
+	res = sendRequest(s, "PUT", "/admin/promocodes/FIXED", jsonBody(t, promoCode), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		PromoCode:    "FIXED",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1"), testPassenger("Jane", "Doe", "C1")},
+		PaymentToken: "tok_visa",
+	}
+	res = sendRequest(s, "POST", "/bookings/quote?currency=usd", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var quote map[string]money.Money
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &quote))
+	require.Equal(t, money.New(3000, "USD"), quote["basePrice"])
+	require.Equal(t, money.New(300, "USD"), quote["discount"])
+	require.Equal(t, money.New(2700, "USD"), quote["price"])
+
+	res = sendRequest(s, "GET", "/flights/123/seats?currency=USD", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var seats []*models.Seat
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &seats))
+	require.Equal(t, money.New(1500, "USD"), seats[0].Price)
+
+	res = sendRequest(s, "GET", "/flights/123/seats?currency=XXX", nil)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	// the booking is stored in the currency of the flight
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, money.New(1800, "EUR"), booking.Price)
+}
+
+func mustParseRates(t *testing.T, data string) *money.Rates {
+	rates, err := money.ParseRates([]byte(data))
+	require.NoError(t, err)
+	return rates
+}
+
+func TestCreateBookingPaymentDeclined(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1")},
+		PaymentToken: payment.FakeTokenDecline,
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusPaymentRequired, res.Code)
+
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+	bookings, err := database.Values[*models.Booking](s.db, testUser[0])
+	require.NoError(t, err)
+	require.Len(t, bookings, 1)
+	require.Equal(t, models.BookingStatusPaymentFailed, bookings[0].Status)
+}
+
+func TestReleaseExpiredBookings(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1")},
+		PaymentToken: "tok_visa",
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+
+	require.NoError(t, s.releaseExpiredBookings(time.Now()))
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+
+	require.NoError(t, s.releaseExpiredBookings(time.Now().Add(s.PaymentTimeout+time.Second)))
+	seat, err = database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+	// the authorization of the expired booking is voided
+	fakePayment, ok := s.Payments.(*payment.Fake).Payment(booking.PaymentID)
+	require.True(t, ok)
+	require.True(t, fakePayment.Voided)
+
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/confirm", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestCancelPendingBooking(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{testPassenger("John", "Doe", "B1")},
+		PaymentToken: "tok_visa",
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.NotEmpty(t, booking.PaymentID)
+
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	fakePayment, ok := s.Payments.(*payment.Fake).Payment(booking.PaymentID)
+	require.True(t, ok)
+	require.True(t, fakePayment.Voided)
+	require.False(t, fakePayment.Captured)
+}
+
+// createConfirmedBooking books and pays the seats of flight 123 for the test user.
+func testPassenger(firstName, lastName, seat string) models.Passenger {
+	return models.Passenger{
+		FirstName:   firstName,
+		LastName:    lastName,
+		DateOfBirth: models.NewDate(1990, time.January, 1),
+		Type:        models.PassengerTypeAdult,
+		Email:       strings.ToLower(firstName) + "@example.com",
+		Seat:        seat,
+	}
+}
+
+func createConfirmedBooking(t *testing.T, s *Service, seats ...string) models.Booking {
+	bookingRequest := &models.Booking{FlightID: "123", PaymentToken: "tok_visa"}
+	for _, seat := range seats {
+		bookingRequest.Passengers = append(bookingRequest.Passengers, testPassenger("John", "Doe", seat))
+	}
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var booking models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/confirm", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	return booking
+}
+
+func TestCancelBookingWithRefund(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	require.NoError(t, s.db.Put(&models.Seat{
+		FlightID: "123", Seat: "D1", Row: 1, Price: money.New(3000, "EUR"), Available: true,
+		Fare: &models.FareRules{Name: "basic", ChangeFee: money.New(500, "EUR")},
+	}))
+
+	booking := createConfirmedBooking(t, s, "B1", "D1")
+	require.Equal(t, "standard", booking.Passengers[0].Fare.Name)
+	require.Equal(t, money.New(3000, "EUR"), booking.Passengers[1].Price)
+
+	res := sendRequest(s, "GET", "/bookings/"+booking.ID+"/refund-quote", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var quote models.RefundQuote
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &quote))
+	require.Equal(t, money.New(1000, "EUR"), quote.Amount)
+	require.Equal(t, money.New(0, "EUR"), quote.Passengers[1].Amount)
+	require.Equal(t, "basic fare is non-refundable", quote.Passengers[1].Reason)
+
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, models.BookingStatusCancelled, booking.Status)
+	require.Equal(t, money.New(1000, "EUR"), *booking.RefundAmount)
+	require.Equal(t, quote.Reason, booking.RefundReason)
+
+	payment, _ := s.Payments.(*payment.Fake).Payment(booking.PaymentID)
+	require.Equal(t, money.New(1000, "EUR"), payment.Refunded)
+	seat, err := database.Get[*models.Seat](s.db, "123/D1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+}
+
+// failingProvider is a payment provider whose captures and refunds fail while captureErr and refundErr are set.
+// It records the ids of all authorizations.
+type failingProvider struct {
+	payment.Provider
+	captureErr error
+	refundErr  error
+	authorized []string
+}
+
+func (f *failingProvider) Authorize(ctx context.Context, amount money.Money, token, reference string) (string, error) {
+	id, err := f.Provider.Authorize(ctx, amount, token, reference)
+	if err == nil {
+		f.authorized = append(f.authorized, id)
+	}
+	return id, err
+}
+
+func (f *failingProvider) Capture(ctx context.Context, authorizationID string) error {
+	if f.captureErr != nil {
+		return f.captureErr
+	}
+	return f.Provider.Capture(ctx, authorizationID)
+}
+
+func (f *failingProvider) Refund(ctx context.Context, authorizationID string, amount money.Money) error {
+	if f.refundErr != nil {
+		return f.refundErr
+	}
+	return f.Provider.Refund(ctx, authorizationID, amount)
+}
+
+func TestCancelBookingRefundRetry(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	fake := s.Payments.(*payment.Fake)
+	booking := createConfirmedBooking(t, s, "B1")
+	provider := &failingProvider{Provider: fake, refundErr: payment.ErrProviderFailure}
+	s.Payments = provider
+
+	// the committed cancellation is not reported as a payment error
+	res := sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, models.BookingStatusCancelled, booking.Status)
+	require.Equal(t, models.RefundStatusFailed, booking.Payments[0].RefundStatus)
+	require.Equal(t, *booking.RefundAmount, *booking.Payments[0].PendingRefund)
+	fakePayment, _ := fake.Payment(booking.PaymentID)
+	require.True(t, fakePayment.Refunded.IsZero())
+
+	// failed refunds are retried after a delay
+	now := time.Now()
+	require.NoError(t, s.retryRefunds(now))
+	fakePayment, _ = fake.Payment(booking.PaymentID)
+	require.True(t, fakePayment.Refunded.IsZero())
+
+	provider.refundErr = nil
+	require.NoError(t, s.retryRefunds(now.Add(refundRetryDelay)))
+	fakePayment, _ = fake.Payment(booking.PaymentID)
+	require.Equal(t, *booking.RefundAmount, fakePayment.Refunded)
+	updated, err := database.Get[*models.Booking](s.db, booking.Key())
+	require.NoError(t, err)
+	require.Equal(t, models.RefundStatusRefunded, updated.Payments[0].RefundStatus)
+	require.Nil(t, updated.Payments[0].PendingRefund)
+
+	// refunds are not paid back twice
+	require.NoError(t, s.retryRefunds(now.Add(2*refundRetryDelay)))
+	fakePayment, _ = fake.Payment(booking.PaymentID)
+	require.Equal(t, *booking.RefundAmount, fakePayment.Refunded)
+}
+
+func TestModifyBooking(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	require.NoError(t, s.db.Put(&models.Seat{
+		FlightID: "123", Seat: "D1", Row: 1, Price: money.New(3000, "EUR"), Available: true,
+		Fare: &models.FareRules{Name: "basic", ChangeFee: money.New(500, "EUR")},
+	}))
+	booking := createConfirmedBooking(t, s, "B1")
+
+	changes := map[string]any{
+		"changes": []map[string]any{
+			{"type": "changeSeat", "passenger": 0, "seat": "D1"},
+			{"type": "rename", "passenger": 0, "firstName": "Jane", "lastName": "Doe"},
+		},
+	}
+	res := sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	seat, err := database.Get[*models.Seat](s.db, "123/D1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	changes["paymentToken"] = "tok_visa"
+	res = sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, "D1", booking.Passengers[0].Seat)
+	require.Equal(t, "Jane Doe", booking.Passengers[0].FullName())
+	require.Equal(t, money.New(3000, "EUR"), booking.Price)
+	require.Len(t, booking.Payments, 2)
+	require.Len(t, booking.Modifications, 2)
+	require.Equal(t, money.New(2000, "EUR"), booking.Modifications[0].PriceDelta)
+	seat, err = database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	// the basic fare is non-refundable, so removing the passenger keeps the price as fee
+	changes = map[string]any{
+		"changes": []map[string]any{
+			{"type": "addPassenger", "details": testPassenger("John", "Doe", "C1")},
+			{"type": "removePassenger", "passenger": 0},
+		},
+		"paymentToken": "tok_visa",
+	}
+	res = sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Len(t, booking.Passengers, 1)
+	require.Equal(t, "C1", booking.Passengers[0].Seat)
+	require.Equal(t, money.New(1000, "EUR"), booking.BasePrice)
+	require.Equal(t, money.New(3000, "EUR"), booking.Fees)
+	require.Equal(t, money.New(4000, "EUR"), booking.Price)
+	require.Len(t, booking.Modifications, 4)
+}
+
+func TestModifyBookingCaptureFailed(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	require.NoError(t, s.db.Put(&models.Seat{FlightID: "123", Seat: "D1", Row: 1, Price: money.New(3000, "EUR"), Available: true}))
+	booking := createConfirmedBooking(t, s, "B1")
+	fake := s.Payments.(*payment.Fake)
+	provider := &failingProvider{Provider: fake, captureErr: payment.ErrProviderFailure}
+	s.Payments = provider
+
+	changes := map[string]any{
+		"changes":      []map[string]any{{"type": "changeSeat", "passenger": 0, "seat": "D1"}},
+		"paymentToken": "tok_visa",
+	}
+	res := sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusBadGateway, res.Code)
+	// the authorization is released and the booking is unchanged
+	require.Len(t, provider.authorized, 1)
+	authorization, _ := fake.Payment(provider.authorized[0])
+	require.True(t, authorization.Voided)
+	updated, err := database.Get[*models.Booking](s.db, booking.Key())
+	require.NoError(t, err)
+	require.Equal(t, "B1", updated.Passengers[0].Seat)
+	require.Len(t, updated.Payments, 1)
+	seat, err := database.Get[*models.Seat](s.db, "123/D1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+
+	provider.captureErr = nil
+	res = sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	updated, err = database.Get[*models.Booking](s.db, booking.Key())
+	require.NoError(t, err)
+	require.Equal(t, "D1", updated.Passengers[0].Seat)
+	require.Equal(t, money.New(2000, "EUR"), updated.Payments[1].Amount)
+}
+
+func TestGetBookingByReference(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	booking := createConfirmedBooking(t, s, "B1")
+	require.Len(t, booking.Reference, 6)
+
+	res := sendRequest(s, "GET", "/bookings/by-reference/"+strings.ToLower(booking.Reference)+"?lastName=doe", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var found models.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &found))
+	require.Equal(t, booking.ID, found.ID)
+	require.Empty(t, found.Payments)
+
+	res = sendRequest(s, "GET", "/bookings/by-reference/"+booking.Reference+"?lastName=Smith", nil)
+	require.Equal(t, http.StatusNotFound, res.Code)
+	res = sendRequest(s, "GET", "/bookings/by-reference/AAAAAA?lastName=Doe", nil)
+	require.Equal(t, http.StatusNotFound, res.Code)
+	res = sendRequest(s, "GET", "/bookings/by-reference/"+booking.Reference, nil)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestCheckinAndBoardingPass(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	booking := createConfirmedBooking(t, s, "B1", "C1")
+
+	res := sendRequest(s, "POST", "/bookings/"+booking.ID+"/checkin", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+	res = sendRequest(s, "GET", "/bookings/"+booking.ID+"/boarding-pass", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	s.CheckinOpens = 96 * time.Hour
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/checkin", jsonBody(t, checkinRequest{Passengers: []int{1}}), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/checkin", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Equal(t, 1, booking.Passengers[1].BoardingSequence)
+	require.Equal(t, 2, booking.Passengers[0].BoardingSequence)
+	require.Equal(t, "1", booking.Passengers[0].BoardingGroup)
+
+	res = sendRequest(s, "GET", "/bookings/"+booking.ID+"/boarding-pass?passenger=1", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var bp map[string]any
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bp))
+	require.Len(t, bp["barcode"], 60)
+	require.True(t, strings.HasPrefix(bp["barcode"].(string), "M1DOE/JOHN"))
+
+	res = sendRequest(s, "GET", "/bookings/"+booking.ID+"/boarding-pass?format=html", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "text/html; charset=utf-8", res.Header().Get("Content-Type"))
+	require.Contains(t, res.Body.String(), booking.Reference)
+}
+
+func TestBoardingPassAfterCancellation(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	s.CheckinOpens = 96 * time.Hour
+	booking := createConfirmedBooking(t, s, "B1")
+	res := sendRequest(s, "POST", "/bookings/"+booking.ID+"/checkin", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	res = sendRequest(s, "GET", "/bookings/"+booking.ID+"/boarding-pass", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	booking = models.Booking{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &booking))
+	require.Nil(t, booking.Passengers[0].CheckedInAt)
+	require.Empty(t, booking.Passengers[0].BoardingGroup)
+
+	// the seat is available again, so the cancelled booking has no boarding pass
+	res = sendRequest(s, "GET", "/bookings/"+booking.ID+"/boarding-pass", nil, setBasicAuth)
+	require.Equal(t, http.StatusConflict, res.Code)
+	require.Contains(t, res.Body.String(), "booking is not confirmed")
+	seat, err := database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+}
+
 func TestGetDestinations(t *testing.T) {
 	s := initService(t)
 	defer func() {
@@ -306,13 +927,15 @@ func BenchmarkRequestCreateBooking(b *testing.B) {
 	s.Auth["test"] = "test"
 
 	amountOfBookingRequests := 90000
+	departure := time.Now().Add(72 * time.Hour)
 	bookingRequests := make([]io.ReadCloser, amountOfBookingRequests)
 	for i := 0; i < amountOfBookingRequests; i++ {
 		flightID := strconv.Itoa(i)
-		_ = db.Put(&models.Flight{ID: flightID}, &models.Seat{FlightID: flightID, Seat: "A1", Available: true})
+		_ = db.Put(&models.Flight{ID: flightID, Departure: departure}, &models.Seat{FlightID: flightID, Seat: "A1", Available: true})
 		bookingRequest := &models.Booking{
-			FlightID:   flightID,
-			Passengers: []models.Passenger{{Name: "user", Seat: "A1"}},
+			FlightID:     flightID,
+			Passengers:   []models.Passenger{testPassenger("user", "test", "A1")},
+			PaymentToken: "tok_visa",
 		}
 		payload, _ := json.Marshal(bookingRequest)
 		bookingRequests[i] = io.NopCloser(bytes.NewReader(payload))
@@ -330,3 +953,26 @@ func BenchmarkRequestCreateBooking(b *testing.B) {
 		s.ServeHTTP(responseRecorder, req)
 	}
 }
+
+func TestLogLevel(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	res := sendRequest(s, "GET", "/admin/loglevel", nil)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	res = sendRequest(s, "GET", "/admin/loglevel", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"level":"info"}`, res.Body.String())
+
+	res = sendRequest(s, "PUT", "/admin/loglevel", strings.NewReader(`{"level":"debug"}`), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"level":"debug"}`, res.Body.String())
+	require.Equal(t, logger.DebugLevel, s.log.Level())
+
+	res = sendRequest(s, "PUT", "/admin/loglevel", strings.NewReader(`{"level":"verbose"}`), setAdminAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	res = sendRequest(s, "PUT", "/admin/loglevel", strings.NewReader(`{}`), setAdminAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	require.Equal(t, logger.DebugLevel, s.log.Level())
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// tracingMiddleware starts a server span for every request that continues the trace of the traceparent
+// header. The span is named by the route pattern once the request was routed.
+func (s *Service) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := s.Tracer.Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		if reqID := middleware.GetReqID(ctx); reqID != "" {
+			span.SetAttribute("http.request_id", reqID)
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(ww, r)
+
+		route := routePattern(r)
+		status := responseStatus(ww)
+		span.SetName(r.Method + " " + route)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracing(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+	var buf bytes.Buffer
+	s.Tracer = tracing.NewTracer(tracing.NewFileExporter(&buf))
+
+	bookingRequest := &models.Booking{FlightID: "123", PaymentToken: "tok_visa"}
+	bookingRequest.Passengers = append(bookingRequest.Passengers, testPassenger("John", "Doe", "B1"))
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	res := sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth, func(req *http.Request) {
+		req.Header.Set(tracing.HeaderTraceparent, traceparent)
+	})
+	require.Equal(t, http.StatusOK, res.Code)
+
+	spans, err := tracing.ReadSpans(&buf)
+	require.NoError(t, err)
+	spansByID := make(map[string]*tracing.SpanData)
+	var server *tracing.SpanData
+	for _, span := range spans {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		spansByID[span.SpanID] = span
+		if span.Kind == tracing.SpanKindServer {
+			server = span
+		}
+	}
+	require.NotNil(t, server)
+	require.Equal(t, "POST /bookings/", server.Name)
+	require.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+	require.Equal(t, "/bookings/", server.Attributes["http.route"])
+	require.Equal(t, float64(http.StatusOK), server.Attributes["http.status_code"])
+
+	// the transactions of the booking are traced inside the request
+	updates := 0
+	for _, span := range spans {
+		if span.Name != "badger.txn" {
+			continue
+		}
+		parent := spansByID[span.ParentSpanID]
+		require.NotNil(t, parent)
+		require.Equal(t, server.SpanID, parent.ParentSpanID)
+		if parent.Name == "database.update" {
+			updates++
+		}
+	}
+	require.Equal(t, 2, updates)
+
+	// requests without a traceparent start a new trace
+	buf.Reset()
+	res = sendRequest(s, "GET", "/unknown", nil)
+	require.Equal(t, http.StatusNotFound, res.Code)
+	spans, err = tracing.ReadSpans(&buf)
+	require.NoError(t, err)
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET unmatched", spans[0].Name)
+	require.Empty(t, spans[0].ParentSpanID)
+	require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+}
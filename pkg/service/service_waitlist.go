@@ -0,0 +1,264 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var cabins = []string{models.CabinBusiness, models.CabinEconomy}
+
+type waitlistRequest struct {
+	Cabin string `json:"cabin"`
+}
+
+type waitlistEntryResponse struct {
+	*models.WaitlistEntry
+	FlightID string `json:"flightId"`
+	Cabin    string `json:"cabin"`
+	Position int    `json:"position,omitempty"`
+}
+
+func newWaitlistEntryResponse(waitlist *models.Waitlist, entry *models.WaitlistEntry) *waitlistEntryResponse {
+	return &waitlistEntryResponse{
+		WaitlistEntry: entry,
+		FlightID:      waitlist.FlightID,
+		Cabin:         waitlist.Cabin,
+		Position:      waitlist.Position(entry.ID),
+	}
+}
+
+// getWaitlist loads the waitlist of the flight cabin. A missing waitlist is returned empty.
+func getWaitlist(txn *database.Txn, flightID, cabin string) (*models.Waitlist, error) {
+	waitlist := &models.Waitlist{FlightID: flightID, Cabin: cabin}
+	if err := txn.Get(waitlist.Key(), waitlist); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, err
+	}
+	return waitlist, nil
+}
+
+// offerSeat holds the seat for the next waiting user of its cabin. The seat stays available if nobody
+// is waiting or the flight cannot be booked anymore. The caller has to store the seat.
+func (s *Service) offerSeat(txn *database.Txn, seat *models.Seat, now time.Time) error {
+	waitlist, err := getWaitlist(txn, seat.FlightID, seat.Cabin())
+	if err != nil {
+		return err
+	}
+	entry := waitlist.Next()
+	if entry == nil {
+		return nil
+	}
+	var flight models.Flight
+	if err := txn.Get(seat.FlightID, &flight); err != nil {
+		return err
+	}
+	// passengers of an overbooked flight have already paid and get released seats at check-in
+	if flight.Status == models.FlightStatusCancelled || !now.Before(flight.Departure) || flight.Oversold > 0 {
+		return nil
+	}
+	expiresAt := now.Add(s.WaitlistClaimWindow)
+	entry.Status = models.WaitlistStatusOffered
+	entry.Seat = seat.Seat
+	entry.OfferExpiresAt = &expiresAt
+	seat.Hold(entry.UserID, expiresAt)
+	s.logger(txn.Context()).Infof("offered seat %s of flight %s to waitlist entry %s until %s", seat.Seat, seat.FlightID, entry.ID, expiresAt)
+	return txn.Put(waitlist)
+}
+
+// claimWaitlistOffer marks the waitlist offer of the user for the held seat as claimed.
+func claimWaitlistOffer(txn *database.Txn, userID string, seat *models.Seat) error {
+	waitlist, err := getWaitlist(txn, seat.FlightID, seat.Cabin())
+	if err != nil {
+		return err
+	}
+	entry := waitlist.OfferFor(userID, seat.Seat)
+	if entry == nil {
+		return nil
+	}
+	entry.Status = models.WaitlistStatusClaimed
+	entry.OfferExpiresAt = nil
+	return txn.Put(waitlist)
+}
+
+// closeWaitlistEntry sets the final status of a pending entry and passes an offered seat on to the next user.
+func (s *Service) closeWaitlistEntry(txn *database.Txn, waitlist *models.Waitlist, entry *models.WaitlistEntry, status string, now time.Time) error {
+	offered := entry.Status == models.WaitlistStatusOffered
+	entry.Status = status
+	entry.OfferExpiresAt = nil
+	if err := txn.Put(waitlist); err != nil {
+		return err
+	}
+	if !offered {
+		return nil
+	}
+	return s.releaseSeat(txn, waitlist.FlightID, entry.Seat, now)
+}
+
+// releaseExpiredOffers passes all waitlist offers that were not claimed before now on to the next user.
+func (s *Service) releaseExpiredOffers(now time.Time) error {
+	waitlists, err := database.Values[*models.Waitlist](s.db)
+	if err != nil {
+		return err
+	}
+	for _, waitlist := range waitlists {
+		for _, entry := range waitlist.Entries {
+			if entry.Status != models.WaitlistStatusOffered || entry.OfferExpiresAt == nil || entry.OfferExpiresAt.After(now) {
+				continue
+			}
+			s.log.Infof("waitlist offer %s for seat %s of flight %s expired", entry.ID, entry.Seat, waitlist.FlightID)
+			err := s.db.Update(func(txn *database.Txn) error {
+				current, err := getWaitlist(txn, waitlist.FlightID, waitlist.Cabin)
+				if err != nil {
+					return err
+				}
+				offer := current.Entry(entry.ID)
+				if offer == nil || offer.Status != models.WaitlistStatusOffered {
+					return nil
+				}
+				return s.closeWaitlistEntry(txn, current, offer, models.WaitlistStatusExpired, now)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) handlerJoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	flightID := chi.URLParam(r, "id")
+	var req waitlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.ValidCabin(req.Cabin) {
+		s.sendError(w, r, fmt.Sprintf("invalid cabin: %q", req.Cabin), http.StatusBadRequest)
+		return
+	}
+
+	var res *waitlistEntryResponse
+	err := db.Update(func(txn *database.Txn) error {
+		var flight models.Flight
+		if err := txn.Get(flightID, &flight); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return newRequestError(http.StatusNotFound, "flight not found")
+			}
+			return err
+		}
+		now := time.Now()
+		if flight.Status == models.FlightStatusCancelled || !now.Before(flight.Departure) {
+			return newRequestError(http.StatusConflict, "flight cannot be booked anymore")
+		}
+
+		// the empty prefix restricts the seats to the exact flight ID, reading the seats inside the transaction
+		// makes joining conflict with a seat being released concurrently
+		seats, err := database.TxnValues[*models.Seat](txn, flightID, "")
+		if err != nil {
+			return err
+		}
+		cabinSeats := 0
+		for _, seat := range seats {
+			if seat.Cabin() != req.Cabin {
+				continue
+			}
+			cabinSeats++
+			if seat.Available {
+				return newRequestError(http.StatusConflict, "seats available")
+			}
+		}
+		if cabinSeats == 0 {
+			return newRequestError(http.StatusBadRequest, "flight has no seats in this cabin")
+		}
+
+		waitlist, err := getWaitlist(txn, flightID, req.Cabin)
+		if err != nil {
+			return err
+		}
+		if waitlist.PendingEntry(userID) != nil {
+			return newRequestError(http.StatusConflict, "already on the waitlist")
+		}
+		entry := &models.WaitlistEntry{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			CreatedAt: now,
+			Status:    models.WaitlistStatusWaiting,
+		}
+		waitlist.Entries = append(waitlist.Entries, entry)
+		res = newWaitlistEntryResponse(waitlist, entry)
+		return txn.Put(waitlist)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, res)
+}
+
+func (s *Service) handlerGetWaitlist(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	flightID := chi.URLParam(r, "id")
+	entries := make([]*waitlistEntryResponse, 0)
+	err := db.View(func(txn *database.Txn) error {
+		for _, cabin := range cabins {
+			waitlist, err := getWaitlist(txn, flightID, cabin)
+			if err != nil {
+				return err
+			}
+			for _, entry := range waitlist.Entries {
+				if entry.UserID == userID {
+					entries = append(entries, newWaitlistEntryResponse(waitlist, entry))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, entries)
+}
+
+func (s *Service) handlerLeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	userID, _, _ := r.BasicAuth()
+	flightID := chi.URLParam(r, "id")
+	entryID := chi.URLParam(r, "entryId")
+
+	var res *waitlistEntryResponse
+	err := db.Update(func(txn *database.Txn) error {
+		for _, cabin := range cabins {
+			waitlist, err := getWaitlist(txn, flightID, cabin)
+			if err != nil {
+				return err
+			}
+			entry := waitlist.Entry(entryID)
+			if entry == nil || entry.UserID != userID {
+				continue
+			}
+			if !entry.Pending() {
+				return newRequestError(http.StatusConflict, "waitlist entry is not pending")
+			}
+			res = newWaitlistEntryResponse(waitlist, entry)
+			return s.closeWaitlistEntry(txn, waitlist, entry, models.WaitlistStatusLeft, time.Now())
+		}
+		return newRequestError(http.StatusNotFound, "waitlist entry not found")
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, res)
+}
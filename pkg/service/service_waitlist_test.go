@@ -0,0 +1,147 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func setUserAuth(user string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.SetBasicAuth(user, "pw")
+	}
+}
+
+func TestWaitlist(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.Auth["alice"] = "pw"
+	s.Auth["bob"] = "pw"
+	require.NoError(t, putBookingRequestData(s))
+
+	res := sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinBusiness}), setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code, "seats are still available")
+
+	booking := createConfirmedBooking(t, s, "B1", "C1")
+
+	res = sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinEconomy}), setUserAuth("alice"))
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	var aliceEntry, bobEntry waitlistEntryResponse
+	res = sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinBusiness}), setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &aliceEntry))
+	require.Equal(t, 1, aliceEntry.Position)
+	require.Equal(t, models.WaitlistStatusWaiting, aliceEntry.Status)
+
+	res = sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinBusiness}), setUserAuth("alice"))
+	require.Equal(t, http.StatusConflict, res.Code)
+
+	res = sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinBusiness}), setUserAuth("bob"))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bobEntry))
+	require.Equal(t, 2, bobEntry.Position)
+
+	// the released seats are offered in the order of the waitlist
+	res = sendRequest(s, "POST", "/bookings/"+booking.ID+"/cancel", nil, setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var entries []waitlistEntryResponse
+	res = sendRequest(s, "GET", "/flights/123/waitlist", nil, setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, models.WaitlistStatusOffered, entries[0].Status)
+	require.Equal(t, "B1", entries[0].Seat)
+	require.NotNil(t, entries[0].OfferExpiresAt)
+
+	seat, err := database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+	require.Equal(t, "bob", seat.HeldBy)
+
+	// held seats can only be booked by the user they are offered to
+	bookingRequest := &models.Booking{
+		FlightID:     "123",
+		Passengers:   []models.Passenger{testPassenger("Alice", "Doe", "B1")},
+		PaymentToken: "tok_visa",
+	}
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setBasicAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	res = sendRequest(s, "POST", "/bookings", jsonBody(t, bookingRequest), setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	seat, err = database.Get[*models.Seat](s.db, "123/B1")
+	require.NoError(t, err)
+	require.False(t, seat.Available)
+	require.Empty(t, seat.HeldBy)
+
+	res = sendRequest(s, "GET", "/flights/123/waitlist", nil, setUserAuth("alice"))
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entries))
+	require.Equal(t, models.WaitlistStatusClaimed, entries[0].Status)
+
+	// unclaimed offers expire and the seat becomes available again
+	require.NoError(t, s.releaseExpiredOffers(time.Now()))
+	res = sendRequest(s, "GET", "/flights/123/waitlist", nil, setUserAuth("bob"))
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entries))
+	require.Equal(t, models.WaitlistStatusOffered, entries[0].Status)
+
+	require.NoError(t, s.releaseExpiredOffers(time.Now().Add(s.WaitlistClaimWindow+time.Second)))
+	res = sendRequest(s, "GET", "/flights/123/waitlist", nil, setUserAuth("bob"))
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entries))
+	require.Equal(t, models.WaitlistStatusExpired, entries[0].Status)
+
+	seat, err = database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.True(t, seat.Available)
+	require.Empty(t, seat.HeldBy)
+
+	res = sendRequest(s, "DELETE", "/flights/123/waitlist/"+bobEntry.ID, nil, setUserAuth("bob"))
+	require.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestLeaveWaitlistPassesOfferOn(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	s.Auth["alice"] = "pw"
+	s.Auth["bob"] = "pw"
+	require.NoError(t, putBookingRequestData(s))
+	booking := createConfirmedBooking(t, s, "B1", "C1")
+
+	join := func(user string) waitlistEntryResponse {
+		res := sendRequest(s, "POST", "/flights/123/waitlist", jsonBody(t, map[string]string{"cabin": models.CabinBusiness}), setUserAuth(user))
+		require.Equal(t, http.StatusOK, res.Code)
+		var entry waitlistEntryResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &entry))
+		return entry
+	}
+	aliceEntry := join("alice")
+	join("bob")
+
+	changes := map[string]any{
+		"changes": []map[string]any{{"type": "removePassenger", "passenger": 1}},
+	}
+	res := sendRequest(s, "PATCH", "/bookings/"+booking.ID, jsonBody(t, changes), setBasicAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	seat, err := database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.Equal(t, "alice", seat.HeldBy)
+
+	res = sendRequest(s, "DELETE", "/flights/123/waitlist/"+aliceEntry.ID, nil, setUserAuth("bob"))
+	require.Equal(t, http.StatusNotFound, res.Code)
+	res = sendRequest(s, "DELETE", "/flights/123/waitlist/"+aliceEntry.ID, nil, setUserAuth("alice"))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	seat, err = database.Get[*models.Seat](s.db, "123/C1")
+	require.NoError(t, err)
+	require.Equal(t, "bob", seat.HeldBy)
+}
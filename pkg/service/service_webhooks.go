@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/webhook"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// emitEvent writes the event to the outbox in the transaction of the change, the webhook worker
+// delivers it after the commit.
+func emitEvent(txn *database.Txn, eventType string, data any) error {
+	event, err := models.NewEvent(eventType, data, time.Now())
+	if err != nil {
+		return err
+	}
+	return txn.Put(event)
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func validateWebhookSubscription(subscription *models.WebhookSubscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if len(subscription.Events) == 0 {
+		return errors.New("missing events")
+	}
+	for _, event := range subscription.Events {
+		if event != models.EventAll && !contains(models.EventTypes, event) {
+			return fmt.Errorf("unknown event: %q", event)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) handlerCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	var subscription models.WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateWebhookSubscription(&subscription); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subscription.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subscription.Secret = secret
+	}
+	subscription.ID = uuid.NewString()
+	subscription.CreatedAt = time.Now()
+	if err := db.Put(&subscription); err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, subscription)
+}
+
+func (s *Service) handlerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	subscriptions, err := database.Values[*models.WebhookSubscription](db)
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, subscriptions)
+}
+
+func (s *Service) getWebhook(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	subscription, err := database.Get[*models.WebhookSubscription](s.db.WithContext(r.Context()), chi.URLParam(r, "id"))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		s.sendError(w, r, "webhook not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (s *Service) handlerGetWebhook(w http.ResponseWriter, r *http.Request) {
+	if subscription, ok := s.getWebhook(w, r); ok {
+		s.writeJSON(w, r, subscription)
+	}
+}
+
+func (s *Service) handlerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	subscription, ok := s.getWebhook(w, r)
+	if !ok {
+		return
+	}
+	err := db.Update(func(txn *database.Txn) error {
+		// deliveries that are created concurrently for the webhook are removed by the worker
+		deliveries, err := database.TxnValues[*models.WebhookDelivery](txn, subscription.ID, "")
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if err := webhook.DeleteDelivery(txn, delivery); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(subscription)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerGetWebhookDeliveries lists the deliveries of the webhook with all attempts, optionally filtered by status.
+func (s *Service) handlerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	subscription, ok := s.getWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := database.Values[*models.WebhookDelivery](db, subscription.ID, "")
+	if err != nil {
+		s.sendError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	filtered := make([]*models.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if status == "" || delivery.Status == status {
+			filtered = append(filtered, delivery)
+		}
+	}
+	s.writeJSON(w, r, filtered)
+}
+
+// handlerRetryWebhookDelivery schedules a dead or failing delivery for an immediate attempt.
+func (s *Service) handlerRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	db := s.db.WithContext(r.Context())
+	var delivery models.WebhookDelivery
+	err := db.Update(func(txn *database.Txn) error {
+		key := fmt.Sprintf("%s/%s", chi.URLParam(r, "id"), chi.URLParam(r, "deliveryId"))
+		if err := txn.Get(key, &delivery); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return newRequestError(http.StatusNotFound, "delivery not found")
+			}
+			return err
+		}
+		if delivery.Status == models.DeliveryStatusDelivered {
+			return newRequestError(http.StatusConflict, "delivery already delivered")
+		}
+		previous := delivery
+		now := time.Now()
+		delivery.Status = models.DeliveryStatusPending
+		delivery.NextAttempt = &now
+		delivery.ExpiresAt = nil
+		return webhook.PutDelivery(txn, &previous, &delivery)
+	})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, delivery)
+}
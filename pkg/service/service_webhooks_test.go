@@ -0,0 +1,60 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	s := initService(t)
+	defer func() {
+		require.NoError(t, s.db.Close())
+	}()
+	require.NoError(t, putBookingRequestData(s))
+
+	res := sendRequest(s, "POST", "/admin/webhooks", jsonBody(t, map[string]any{"url": "http://example.com", "events": []string{"booking.created"}}))
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	res = sendRequest(s, "POST", "/admin/webhooks", jsonBody(t, map[string]any{"url": "example.com", "events": []string{"booking.created"}}), setAdminAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+	res = sendRequest(s, "POST", "/admin/webhooks", jsonBody(t, map[string]any{"url": "http://example.com", "events": []string{"booking.unknown"}}), setAdminAuth)
+	require.Equal(t, http.StatusBadRequest, res.Code)
+
+	res = sendRequest(s, "POST", "/admin/webhooks", jsonBody(t, map[string]any{"url": "http://example.com", "events": []string{"booking.created", "booking.cancelled"}}), setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	var subscription models.WebhookSubscription
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &subscription))
+	require.NotEmpty(t, subscription.ID)
+	require.Len(t, subscription.Secret, 64)
+
+	res = sendRequest(s, "GET", "/admin/webhooks/"+subscription.ID, nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// the event is written to the outbox together with the booking
+	booking := createConfirmedBooking(t, s, "B1")
+	events, err := database.Values[*models.Event](s.db)
+	require.NoError(t, err)
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	require.Equal(t, []string{models.EventBookingCreated, models.EventBookingConfirmed}, types)
+	var created models.Booking
+	require.NoError(t, json.Unmarshal(events[0].Data, &created))
+	require.Equal(t, booking.ID, created.ID)
+
+	res = sendRequest(s, "GET", "/admin/webhooks/"+subscription.ID+"/deliveries", nil, setAdminAuth)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `[]`, res.Body.String())
+	res = sendRequest(s, "POST", "/admin/webhooks/"+subscription.ID+"/deliveries/unknown/retry", nil, setAdminAuth)
+	require.Equal(t, http.StatusNotFound, res.Code)
+
+	res = sendRequest(s, "DELETE", "/admin/webhooks/"+subscription.ID, nil, setAdminAuth)
+	require.Equal(t, http.StatusNoContent, res.Code)
+	res = sendRequest(s, "GET", "/admin/webhooks/"+subscription.ID, nil, setAdminAuth)
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,157 @@
+package simulator
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+)
+
+// Simulator moves flights through scheduled → boarding → departed → landed based on the time of its clock.
+// Before departure every flight may be delayed or cancelled once. The decision only depends on the seed
+// and the flight ID, so a simulation with the same seed is reproducible regardless of the step interval.
+type Simulator struct {
+	log   *logger.Logger
+	db    *database.Database
+	clock clock.Clock
+	seed  int64
+
+	// DisruptionLead is the time before departure when the delay or cancellation of a flight is decided.
+	DisruptionLead time.Duration
+	// BoardingLead is the time before departure when boarding starts.
+	BoardingLead time.Duration
+	// CancelRate and DelayRate are the probabilities of a cancellation or delay per flight.
+	CancelRate float64
+	DelayRate  float64
+	// MaxDelay is the upper bound of a random delay.
+	MaxDelay time.Duration
+}
+
+func New(log *logger.Logger, db *database.Database, c clock.Clock, seed int64) *Simulator {
+	return &Simulator{
+		log:            log,
+		db:             db,
+		clock:          c,
+		seed:           seed,
+		DisruptionLead: 3 * time.Hour,
+		BoardingLead:   40 * time.Minute,
+		CancelRate:     0.02,
+		DelayRate:      0.15,
+		MaxDelay:       3 * time.Hour,
+	}
+}
+
+// rng returns the random source of a flight.
+func (s *Simulator) rng(flightID string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(flightID))
+	return rand.New(rand.NewSource(s.seed ^ int64(h.Sum64())))
+}
+
+// disrupt decides whether the flight is cancelled or delayed.
+func (s *Simulator) disrupt(flight *models.Flight) {
+	rng := s.rng(flight.ID)
+	switch p := rng.Float64(); {
+	case p < s.CancelRate:
+		flight.Status = models.FlightStatusCancelled
+	case p < s.CancelRate+s.DelayRate:
+		delay := time.Duration(rng.Int63n(int64(s.MaxDelay/time.Minute))+1) * time.Minute
+		flight.Departure = flight.Departure.Add(delay)
+		flight.Arrival = flight.Arrival.Add(delay)
+		flight.Delay += models.Duration(delay)
+		flight.Status = models.FlightStatusDelayed
+	}
+}
+
+// advance applies all status transitions of the flight that are due at now and reports whether the
+// flight changed.
+func (s *Simulator) advance(flight *models.Flight, now time.Time) bool {
+	status, departure := flight.Status, flight.Departure
+	for {
+		prev := flight.Status
+		switch flight.Status {
+		case models.FlightStatusScheduled:
+			if !now.Before(flight.Departure.Add(-s.DisruptionLead)) {
+				s.disrupt(flight)
+			}
+			if flight.Status == models.FlightStatusScheduled && !now.Before(flight.Departure.Add(-s.BoardingLead)) {
+				flight.Status = models.FlightStatusBoarding
+			}
+		case models.FlightStatusDelayed:
+			if !now.Before(flight.Departure.Add(-s.BoardingLead)) {
+				flight.Status = models.FlightStatusBoarding
+			}
+		case models.FlightStatusBoarding:
+			if !now.Before(flight.Departure) {
+				flight.Status = models.FlightStatusDeparted
+			}
+		case models.FlightStatusDeparted:
+			if !now.Before(flight.Arrival) {
+				flight.Status = models.FlightStatusLanded
+			}
+		}
+		if flight.Status == prev {
+			break
+		}
+	}
+	return flight.Status != status || !flight.Departure.Equal(departure)
+}
+
+// Step applies the status transitions of all flights that are due at the current time of the clock.
+func (s *Simulator) Step() error {
+	now := s.clock.Now()
+	flights, err := database.Values[*models.Flight](s.db)
+	if err != nil {
+		return err
+	}
+	for _, flight := range flights {
+		if !s.advance(flight, now) {
+			continue
+		}
+		err := s.db.Update(func(txn *database.Txn) error {
+			// the flight is reloaded to keep concurrent changes, e.g. of the oversold counter
+			var current models.Flight
+			if err := txn.Get(flight.ID, &current); err != nil {
+				return err
+			}
+			previousStatus := current.Status
+			if !s.advance(&current, now) {
+				return nil
+			}
+			s.log.Infof("flight %s is %s (departure %s)", current.ID, current.Status, current.Departure.Format(time.RFC3339))
+			event, err := models.NewEvent(models.EventFlightStatusChanged, &models.FlightStatusChange{
+				Flight:         &current,
+				PreviousStatus: previousStatus,
+			}, now)
+			if err != nil {
+				return err
+			}
+			return txn.Put(&current, event)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run executes a step every interval until the context is cancelled.
+func (s *Simulator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Step(); err != nil {
+				s.log.Errorf("could not simulate flight status: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package simulator
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2022, time.July, 5, 12, 0, 0, 0, time.UTC)
+
+func initSimulator(t *testing.T, flights int) (*Simulator, *clock.Fake) {
+	db, err := database.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+	for i := 0; i < flights; i++ {
+		require.NoError(t, db.Put(&models.Flight{
+			ID:        strconv.Itoa(i),
+			Departure: start.Add(4 * time.Hour),
+			Arrival:   start.Add(6 * time.Hour),
+			Status:    models.FlightStatusScheduled,
+		}))
+	}
+	c := clock.NewFake(start)
+	return New(logger.NewNop(), db, c, 42), c
+}
+
+func getFlight(t *testing.T, s *Simulator, id string) *models.Flight {
+	flight, err := database.Get[*models.Flight](s.db, id)
+	require.NoError(t, err)
+	return flight
+}
+
+func TestStatusTransitions(t *testing.T) {
+	s, c := initSimulator(t, 1)
+	s.CancelRate = 0
+	s.DelayRate = 0
+
+	steps := []struct {
+		at     time.Duration
+		status string
+	}{
+		{0, models.FlightStatusScheduled},
+		{3*time.Hour + 20*time.Minute, models.FlightStatusBoarding},
+		{4 * time.Hour, models.FlightStatusDeparted},
+		{5 * time.Hour, models.FlightStatusDeparted},
+		{6 * time.Hour, models.FlightStatusLanded},
+	}
+	for _, step := range steps {
+		c.Set(start.Add(step.at))
+		require.NoError(t, s.Step())
+		require.Equal(t, step.status, getFlight(t, s, "0").Status, step.at)
+	}
+}
+
+func TestStepCatchesUp(t *testing.T) {
+	s, c := initSimulator(t, 1)
+	s.CancelRate = 0
+	s.DelayRate = 0
+	c.Advance(24 * time.Hour)
+	require.NoError(t, s.Step())
+	require.Equal(t, models.FlightStatusLanded, getFlight(t, s, "0").Status)
+}
+
+func TestDelay(t *testing.T) {
+	s, c := initSimulator(t, 1)
+	s.CancelRate = 0
+	s.DelayRate = 1
+
+	c.Advance(time.Hour)
+	require.NoError(t, s.Step())
+	flight := getFlight(t, s, "0")
+	require.Equal(t, models.FlightStatusDelayed, flight.Status)
+	require.Positive(t, flight.Delay)
+	require.LessOrEqual(t, time.Duration(flight.Delay), s.MaxDelay)
+	require.Equal(t, start.Add(4*time.Hour).Add(time.Duration(flight.Delay)), flight.Departure)
+	require.Equal(t, start.Add(6*time.Hour).Add(time.Duration(flight.Delay)), flight.Arrival)
+
+	// a flight is only delayed once
+	c.Set(flight.Departure.Add(-s.BoardingLead))
+	require.NoError(t, s.Step())
+	delayed := getFlight(t, s, "0")
+	require.Equal(t, models.FlightStatusBoarding, delayed.Status)
+	require.Equal(t, flight.Departure, delayed.Departure)
+}
+
+func TestCancellation(t *testing.T) {
+	s, c := initSimulator(t, 1)
+	s.CancelRate = 1
+	c.Advance(24 * time.Hour)
+	require.NoError(t, s.Step())
+	require.Equal(t, models.FlightStatusCancelled, getFlight(t, s, "0").Status)
+}
+
+func TestSeededDisruptions(t *testing.T) {
+	run := func(steps ...time.Duration) []*models.Flight {
+		s, c := initSimulator(t, 200)
+		s.CancelRate = 0.1
+		s.DelayRate = 0.3
+		for _, step := range steps {
+			c.Advance(step)
+			require.NoError(t, s.Step())
+		}
+		flights, err := database.Values[*models.Flight](s.db)
+		require.NoError(t, err)
+		return flights
+	}
+	// different step intervals lead to the same result
+	first := run(time.Hour, 10*time.Minute)
+	second := run(70 * time.Minute)
+	require.Equal(t, first, second)
+
+	statuses := make(map[string]int)
+	for _, flight := range first {
+		statuses[flight.Status]++
+	}
+	require.Positive(t, statuses[models.FlightStatusCancelled])
+	require.Positive(t, statuses[models.FlightStatusDelayed])
+	require.Positive(t, statuses[models.FlightStatusScheduled])
+}
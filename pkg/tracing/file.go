@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// FileExporter writes every span as a JSON line, e.g. to a file or a buffer in tests.
+type FileExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+	err error
+}
+
+func NewFileExporter(w io.Writer) *FileExporter {
+	return &FileExporter{w: w, enc: json.NewEncoder(w)}
+}
+
+func (e *FileExporter) ExportSpan(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = e.enc.Encode(span)
+	}
+}
+
+// Shutdown returns the first write error.
+func (e *FileExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// ReadSpans reads the spans that were written by a FileExporter.
+func ReadSpans(r io.Reader) ([]*SpanData, error) {
+	spans := make([]*SpanData, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var span SpanData
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			return nil, err
+		}
+		spans = append(spans, &span)
+	}
+	return spans, scanner.Err()
+}
@@ -0,0 +1,276 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpQueueSize     = 2048
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends the spans in batches to an OpenTelemetry collector with the OTLP/HTTP JSON encoding.
+// Spans are dropped if the queue is full.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	Client      *http.Client
+
+	queue    chan *SpanData
+	flush    chan chan error
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu      sync.Mutex
+	dropped int
+	err     error
+}
+
+// NewOTLPExporter starts an exporter for the collector URL, e.g. http://localhost:4318. The spans are
+// posted to the /v1/traces path.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *SpanData, otlpQueueSize),
+		flush:       make(chan chan error),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) ExportSpan(span *SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// Flush sends the queued spans.
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	result := make(chan error, 1)
+	select {
+	case e.flush <- result:
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown sends the queued spans and stops the exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		close(e.done)
+	})
+	select {
+	case <-e.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.dropped > 0 && e.err == nil {
+		return fmt.Errorf("%d spans dropped", e.dropped)
+	}
+	return e.err
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*SpanData, 0, otlpBatchSize)
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.send(batch)
+		batch = batch[:0]
+		if err != nil {
+			e.mu.Lock()
+			e.err = err
+			e.mu.Unlock()
+		}
+		return err
+	}
+	add := func(span *SpanData) {
+		batch = append(batch, span)
+		if len(batch) == otlpBatchSize {
+			_ = send()
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-e.queue:
+				add(span)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			add(span)
+		case <-ticker.C:
+			_ = send()
+		case result := <-e.flush:
+			drain()
+			result <- send()
+		case <-e.done:
+			drain()
+			_ = send()
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// The types follow the JSON mapping of the OTLP protobuf messages.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpStatusError is the status code of failed spans.
+const otlpStatusError = 2
+
+func otlpAttribute(key string, value any) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+func (e *OTLPExporter) request(spans []*SpanData) *otlpRequest {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: e.serviceName}, Spans: make([]otlpSpan, 0, len(spans))}
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, otlpAttribute(key, span.Attributes[key]))
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", e.serviceName)}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
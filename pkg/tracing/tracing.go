@@ -0,0 +1,330 @@
+// Package tracing records spans of requests and propagates them with the W3C trace context headers.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderTraceparent is the W3C trace context header.
+const HeaderTraceparent = "traceparent"
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the value of the traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTraceparent parses the value of a traceparent header. Unknown versions are parsed as version 00
+// as long as the known fields are valid.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	version := parts[0]
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	for _, part := range parts[1:4] {
+		if !isLowerHex(part) {
+			return sc, ErrInvalidTraceparent
+		}
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	flags, _ := hex.DecodeString(parts[3])
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+type SpanKind int
+
+// The span kinds use the values of OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+func (k SpanKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *SpanKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "server":
+		*k = SpanKindServer
+	case "client":
+		*k = SpanKindClient
+	default:
+		*k = SpanKindInternal
+	}
+	return nil
+}
+
+// SpanData is a finished span as it is passed to the exporter.
+type SpanData struct {
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (d *SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Exporter receives the finished spans that are sampled.
+type Exporter interface {
+	ExportSpan(span *SpanData)
+	// Shutdown exports the remaining spans.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts spans and passes them to the exporter when they end. A nil tracer starts no spans.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer for the exporter. Without an exporter spans are only propagated, e.g. to
+// include trace IDs in logs.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Shutdown exports the remaining spans of the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// Start starts a span that is a child of the span or remote parent in ctx, otherwise a new trace is started.
+// The returned context contains the span. The span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(remoteParentKey{}).(SpanContext)
+	}
+	if parent.IsValid() {
+		span.ctx.TraceID = parent.TraceID
+		span.ctx.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(span.ctx.TraceID[:])
+		span.ctx.Sampled = true
+	}
+	_, _ = rand.Read(span.ctx.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartChild starts an internal span if ctx already contains a span, otherwise it returns ctx and a nil span.
+// It is used by packages that only want to contribute to existing traces.
+func StartChild(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, SpanKindInternal)
+}
+
+// Span is a timed operation of a trace. All methods can be called on a nil span.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   SpanKind
+	ctx    SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu         sync.Mutex
+	attributes map[string]any
+	err        string
+	ended      bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetName renames the span, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute sets an attribute, the value should be a string, bool, integer or float. Attributes of ended
+// spans are ignored.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = make(map[string]any)
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and exports it if it is sampled. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := &SpanData{
+		Name:       s.name,
+		Kind:       s.kind,
+		TraceID:    s.ctx.TraceID.String(),
+		SpanID:     s.ctx.SpanID.String(),
+		Start:      s.start,
+		End:        end,
+		Attributes: s.attributes,
+		Error:      s.err,
+	}
+	s.mu.Unlock()
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	if s.ctx.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+// SpanFromContext returns the span of the context or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// TraceIDFromContext returns the trace ID of the span in the context or an empty string.
+func TraceIDFromContext(ctx context.Context) string {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.ctx.TraceID.String()
+	}
+	return ""
+}
+
+// Extract returns a context with the remote parent of the traceparent header. Invalid headers are ignored.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(HeaderTraceparent))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+// Inject sets the traceparent header to the span in the context.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		header.Set(HeaderTraceparent, sc.Traceparent())
+	}
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.True(t, sc.Sampled)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// future versions can have additional fields
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	require.False(t, sc.Sampled)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(value)
+		require.ErrorIs(t, err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewFileExporter(&buf))
+
+	// spans are only started as children of existing spans
+	ctx, span := StartChild(context.Background(), "child")
+	require.Nil(t, span)
+	span.SetAttribute("ignored", true)
+	span.End()
+
+	header := http.Header{}
+	header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(Extract(ctx, header), "GET /flights", SpanKindServer)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceIDFromContext(ctx))
+	root.SetAttribute("http.status_code", 200)
+	childCtx, child := StartChild(ctx, "database.get")
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	out := http.Header{}
+	Inject(childCtx, out)
+	require.Equal(t, child.SpanContext().Traceparent(), out.Get(HeaderTraceparent))
+	root.End()
+
+	// a new trace is started without a parent
+	_, other := tracer.Start(context.Background(), "job", SpanKindInternal)
+	other.End()
+	require.NotEqual(t, root.SpanContext().TraceID, other.SpanContext().TraceID)
+
+	// spans of traces that are not sampled are not exported
+	header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, unsampled := tracer.Start(Extract(context.Background(), header), "unsampled", SpanKindServer)
+	unsampled.End()
+
+	spans, err := ReadSpans(&buf)
+	require.NoError(t, err)
+	require.Len(t, spans, 3)
+	require.Equal(t, "database.get", spans[0].Name)
+	require.Equal(t, SpanKindInternal, spans[0].Kind)
+	require.Equal(t, "failed", spans[0].Error)
+	require.Equal(t, root.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	require.Equal(t, "GET /flights", spans[1].Name)
+	require.Equal(t, SpanKindServer, spans[1].Kind)
+	require.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	require.Equal(t, float64(200), spans[1].Attributes["http.status_code"])
+	require.Empty(t, spans[2].ParentSpanID)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req otlpRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests <- req
+	}))
+	defer srv.Close()
+
+	exporter := NewOTLPExporter(srv.URL, "test-service")
+	tracer := NewTracer(exporter)
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	_, child := StartChild(ctx, "child")
+	child.SetAttribute("db.operation", "get")
+	child.SetAttribute("db.changes", 2)
+	child.SetError(errors.New("failed"))
+	child.End()
+	root.End()
+	require.NoError(t, exporter.Flush(context.Background()))
+
+	req := <-requests
+	require.Len(t, req.ResourceSpans, 1)
+	require.Equal(t, "test-service", *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, root.SpanContext().TraceID.String(), spans[0].TraceID)
+	require.Equal(t, root.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	require.Equal(t, "db.changes", spans[0].Attributes[0].Key)
+	require.Equal(t, "2", *spans[0].Attributes[0].Value.IntValue)
+	require.Equal(t, &otlpStatus{Code: otlpStatusError, Message: "failed"}, spans[0].Status)
+	require.Equal(t, int(SpanKindServer), spans[1].Kind)
+
+	require.NoError(t, exporter.Shutdown(context.Background()))
+	require.NoError(t, exporter.Flush(context.Background()))
+}
@@ -0,0 +1,366 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderEvent     = "Webhook-Event"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+)
+
+// Sign returns the signature of a payload: the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with
+// the secret of the subscription, prefixed with "sha256=".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a received webhook request against the body.
+func Verify(secret string, header http.Header, body []byte) bool {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(header.Get(HeaderSignature)))
+}
+
+// PutDelivery stores the delivery and replaces the timer of its previous state, which is nil for new deliveries.
+func PutDelivery(txn *database.Txn, previous, delivery *models.WebhookDelivery) error {
+	if previous != nil {
+		if timer := previous.Timer(); timer != nil {
+			if err := txn.Delete(timer); err != nil {
+				return err
+			}
+		}
+	}
+	updates := []database.Model{delivery}
+	if timer := delivery.Timer(); timer != nil {
+		updates = append(updates, timer)
+	}
+	return txn.Put(updates...)
+}
+
+// DeleteDelivery removes the delivery and its timer.
+func DeleteDelivery(txn *database.Txn, delivery *models.WebhookDelivery) error {
+	if timer := delivery.Timer(); timer != nil {
+		if err := txn.Delete(timer); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(delivery)
+}
+
+// Worker turns the events of the outbox into deliveries for the subscribed webhooks and sends them.
+// Failed deliveries are retried with exponential backoff until MaxAttempts is reached, then they are
+// marked as dead. Delivered and dead deliveries are removed after the Retention.
+type Worker struct {
+	log    *logger.Logger
+	db     *database.Database
+	clock  clock.Clock
+	Client *http.Client
+	// Tracer traces the deliveries and propagates them in the traceparent header, it is optional.
+	Tracer *tracing.Tracer
+
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed attempt, it doubles with every further attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Retention  time.Duration
+	// Concurrency is the number of webhooks that are sent to at the same time, the deliveries of a webhook
+	// are sent one after another.
+	Concurrency int
+}
+
+func NewWorker(log *logger.Logger, db *database.Database, c clock.Clock) *Worker {
+	return &Worker{
+		log:         log,
+		db:          db,
+		clock:       c,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		MinBackoff:  10 * time.Second,
+		MaxBackoff:  time.Hour,
+		Retention:   24 * time.Hour,
+		Concurrency: 8,
+	}
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts.
+func (w *Worker) Backoff(attempts int) time.Duration {
+	backoff := w.MinBackoff
+	for i := 1; i < attempts && backoff < w.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > w.MaxBackoff {
+		return w.MaxBackoff
+	}
+	return backoff
+}
+
+// Dispatch creates a delivery for every subscription of each outbox event and removes the event.
+func (w *Worker) Dispatch() error {
+	events, err := database.Values[*models.Event](w.db)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	now := w.clock.Now()
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		err = w.db.Update(func(txn *database.Txn) error {
+			// the subscriptions are read inside the transaction, so that no delivery is created for a webhook
+			// that is deleted concurrently
+			subscriptions, err := database.TxnValues[*models.WebhookSubscription](txn)
+			if err != nil {
+				return err
+			}
+			for _, subscription := range subscriptions {
+				if !subscription.Subscribed(event.Type) {
+					continue
+				}
+				delivery := &models.WebhookDelivery{
+					ID:             uuid.NewString(),
+					SubscriptionID: subscription.ID,
+					EventID:        event.ID,
+					EventType:      event.Type,
+					Payload:        payload,
+					Status:         models.DeliveryStatusPending,
+					NextAttempt:    &now,
+					CreatedAt:      now,
+				}
+				if err := PutDelivery(txn, nil, delivery); err != nil {
+					return err
+				}
+			}
+			return txn.Delete(event)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts the payload of the delivery to the webhook and returns the attempt.
+func (w *Worker) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery, now time.Time) models.DeliveryAttempt {
+	attempt := models.DeliveryAttempt{Time: now}
+	ctx, span := w.Tracer.Start(ctx, "webhook.deliver", tracing.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("webhook.id", subscription.ID)
+	span.SetAttribute("webhook.delivery_id", delivery.ID)
+	span.SetAttribute("webhook.event", delivery.EventType)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		span.SetError(err)
+		return attempt
+	}
+	tracing.Inject(ctx, req.Header)
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, delivery.ID)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, delivery.Payload))
+
+	start := time.Now()
+	res, err := w.Client.Do(req)
+	attempt.Duration = models.Duration(time.Since(start))
+	if err != nil {
+		attempt.Error = err.Error()
+		span.SetError(err)
+		return attempt
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	attempt.StatusCode = res.StatusCode
+	span.SetAttribute("http.status_code", res.StatusCode)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status code: %d", res.StatusCode)
+		span.SetError(errors.New(attempt.Error))
+	}
+	return attempt
+}
+
+// Deliver processes the deliveries that are due: pending deliveries are sent and expired deliveries are
+// removed. The webhooks are processed concurrently, up to Concurrency at a time.
+func (w *Worker) Deliver(ctx context.Context) error {
+	timers, err := database.Values[*models.DeliveryTimer](w.db)
+	if err != nil {
+		return err
+	}
+	now := w.clock.Now()
+	due := make(map[string][]*models.DeliveryTimer)
+	subscriptionIDs := make([]string, 0)
+	for _, timer := range timers {
+		if timer.Due.After(now) {
+			// the timers are ordered by the due time
+			break
+		}
+		if _, ok := due[timer.SubscriptionID]; !ok {
+			subscriptionIDs = append(subscriptionIDs, timer.SubscriptionID)
+		}
+		due[timer.SubscriptionID] = append(due[timer.SubscriptionID], timer)
+	}
+
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, subscriptionID := range subscriptionIDs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(subscriptionID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := w.deliverSubscription(ctx, subscriptionID, due[subscriptionID]); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(subscriptionID)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// deliverSubscription processes the due deliveries of a webhook in order. After a failed attempt the
+// remaining deliveries are left for the next step, as the webhook is likely unavailable.
+func (w *Worker) deliverSubscription(ctx context.Context, subscriptionID string, timers []*models.DeliveryTimer) error {
+	subscription, err := database.Get[*models.WebhookSubscription](w.db, subscriptionID)
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	for _, timer := range timers {
+		delivery, err := database.Get[*models.WebhookDelivery](w.db, timer.DeliveryKey())
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		switch {
+		case err != nil || delivery.Timer() == nil || delivery.Timer().Key() != timer.Key():
+			// the timer is stale
+			if err := w.db.Update(func(txn *database.Txn) error { return txn.Delete(timer) }); err != nil {
+				return err
+			}
+			continue
+		case delivery.Status != models.DeliveryStatusPending || subscription == nil:
+			// expired deliveries and the deliveries of deleted webhooks are removed
+			err := w.db.Update(func(txn *database.Txn) error {
+				var current models.WebhookDelivery
+				if err := txn.Get(delivery.Key(), &current); errors.Is(err, badger.ErrKeyNotFound) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if current.Timer() == nil || current.Timer().Key() != timer.Key() {
+					// the delivery was retried in the meantime
+					return nil
+				}
+				return DeleteDelivery(txn, &current)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		now := w.clock.Now()
+		attempt := w.send(ctx, subscription, delivery, now)
+		if err := w.recordAttempt(subscription, delivery.Key(), attempt, now); err != nil {
+			return err
+		}
+		if attempt.Error != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+// recordAttempt adds the attempt to the delivery and schedules the next attempt or its removal.
+func (w *Worker) recordAttempt(subscription *models.WebhookSubscription, key string, attempt models.DeliveryAttempt, now time.Time) error {
+	return w.db.Update(func(txn *database.Txn) error {
+		var previous models.WebhookDelivery
+		if err := txn.Get(key, &previous); errors.Is(err, badger.ErrKeyNotFound) {
+			// the webhook was deleted during the attempt
+			return nil
+		} else if err != nil {
+			return err
+		}
+		current := previous
+		current.Attempts = append(append([]models.DeliveryAttempt{}, previous.Attempts...), attempt)
+		current.NextAttempt = nil
+		switch {
+		case attempt.Error == "":
+			current.Status = models.DeliveryStatusDelivered
+		case len(current.Attempts) >= w.MaxAttempts:
+			w.log.Warnf("webhook delivery %s to %s failed %d times: %s", current.ID, subscription.URL, len(current.Attempts), attempt.Error)
+			current.Status = models.DeliveryStatusDead
+		default:
+			next := now.Add(w.Backoff(len(current.Attempts)))
+			current.NextAttempt = &next
+		}
+		if current.Status != models.DeliveryStatusPending {
+			expires := now.Add(w.Retention)
+			current.ExpiresAt = &expires
+		}
+		return PutDelivery(txn, &previous, &current)
+	})
+}
+
+// Step dispatches the outbox and sends the due deliveries.
+func (w *Worker) Step(ctx context.Context) error {
+	if err := w.Dispatch(); err != nil {
+		return err
+	}
+	return w.Deliver(ctx)
+}
+
+// Run executes a step every interval until the context is cancelled.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Step(ctx); err != nil {
+				w.log.Errorf("could not deliver webhooks: %v", err)
+			}
+		}
+	}
+}
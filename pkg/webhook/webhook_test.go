@@ -0,0 +1,245 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/christophwitzko/flight-booking-service/pkg/clock"
+	"github.com/christophwitzko/flight-booking-service/pkg/database"
+	"github.com/christophwitzko/flight-booking-service/pkg/database/models"
+	"github.com/christophwitzko/flight-booking-service/pkg/logger"
+	"github.com/christophwitzko/flight-booking-service/pkg/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func initWorker(t *testing.T, rc *receiver, events ...string) (*Worker, *clock.Fake, *models.WebhookSubscription) {
+	db, err := database.New()
+	require.NoError(t, err)
+	srv := httptest.NewServer(rc)
+	t.Cleanup(func() {
+		srv.Close()
+		require.NoError(t, db.Close())
+	})
+	subscription := &models.WebhookSubscription{ID: "hook", URL: srv.URL, Secret: "secret", Events: events}
+	require.NoError(t, db.Put(subscription))
+	c := clock.NewFake(time.Date(2022, time.July, 5, 12, 0, 0, 0, time.UTC))
+	return NewWorker(logger.NewNop(), db, c), c, subscription
+}
+
+func putEvent(t *testing.T, w *Worker, eventType string) *models.Event {
+	event, err := models.NewEvent(eventType, map[string]string{"id": "123"}, w.clock.Now())
+	require.NoError(t, err)
+	require.NoError(t, w.db.Update(func(txn *database.Txn) error {
+		return txn.Put(event)
+	}))
+	return event
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"booking.created"}`)
+	header := http.Header{}
+	header.Set(HeaderTimestamp, "1657022400")
+	header.Set(HeaderSignature, Sign("secret", 1657022400, body))
+	require.True(t, Verify("secret", header, body))
+	require.False(t, Verify("other", header, body))
+	require.False(t, Verify("secret", header, []byte(`{}`)))
+	header.Set(HeaderTimestamp, "1657022401")
+	require.False(t, Verify("secret", header, body))
+}
+
+func TestBackoff(t *testing.T) {
+	w := &Worker{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	require.Equal(t, time.Second, w.Backoff(1))
+	require.Equal(t, 2*time.Second, w.Backoff(2))
+	require.Equal(t, 8*time.Second, w.Backoff(4))
+	require.Equal(t, 10*time.Second, w.Backoff(5))
+	require.Equal(t, 10*time.Second, w.Backoff(100))
+}
+
+func TestDeliver(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	w, _, subscription := initWorker(t, rc, models.EventBookingCreated)
+	var spans bytes.Buffer
+	w.Tracer = tracing.NewTracer(tracing.NewFileExporter(&spans))
+	event := putEvent(t, w, models.EventBookingCreated)
+	putEvent(t, w, models.EventFlightStatusChanged)
+
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 1)
+	req := rc.requests[0]
+	require.Equal(t, models.EventBookingCreated, req.Header.Get(HeaderEvent))
+	// the delivery is traced and propagated to the receiver
+	exported, err := tracing.ReadSpans(&spans)
+	require.NoError(t, err)
+	require.Len(t, exported, 1)
+	require.Equal(t, "webhook.deliver", exported[0].Name)
+	sc, err := tracing.ParseTraceparent(req.Header.Get(tracing.HeaderTraceparent))
+	require.NoError(t, err)
+	require.Equal(t, exported[0].SpanID, sc.SpanID.String())
+	require.True(t, Verify(subscription.Secret, req.Header, rc.bodies[0]))
+	var payload models.Event
+	require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+	require.Equal(t, event.ID, payload.ID)
+	require.JSONEq(t, `{"id":"123"}`, string(payload.Data))
+
+	// the outbox is empty and delivered webhooks are not sent again
+	events, err := database.Values[*models.Event](w.db)
+	require.NoError(t, err)
+	require.Empty(t, events)
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 1)
+
+	deliveries, err := database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, models.DeliveryStatusDelivered, deliveries[0].Status)
+	require.Len(t, deliveries[0].Attempts, 1)
+	require.Equal(t, http.StatusNoContent, deliveries[0].Attempts[0].StatusCode)
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	w, c, _ := initWorker(t, rc, models.EventAll)
+	w.MaxAttempts = 3
+	w.MinBackoff = time.Minute
+	putEvent(t, w, models.EventBookingCancelled)
+
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 1)
+	deliveries, err := database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Equal(t, models.DeliveryStatusPending, deliveries[0].Status)
+	require.Equal(t, c.Now().Add(time.Minute), *deliveries[0].NextAttempt)
+	require.Equal(t, "unexpected status code: 500", deliveries[0].Attempts[0].Error)
+
+	// the delivery is not retried before the backoff elapsed
+	c.Advance(30 * time.Second)
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 1)
+
+	c.Advance(30 * time.Second)
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 2)
+	deliveries, err = database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Equal(t, c.Now().Add(2*time.Minute), *deliveries[0].NextAttempt)
+
+	c.Advance(2 * time.Minute)
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 3)
+	deliveries, err = database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Equal(t, models.DeliveryStatusDead, deliveries[0].Status)
+	require.Nil(t, deliveries[0].NextAttempt)
+	require.Len(t, deliveries[0].Attempts, 3)
+
+	c.Advance(time.Hour)
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 3)
+}
+
+func TestRetention(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	w, c, _ := initWorker(t, rc, models.EventAll)
+	w.Retention = time.Hour
+	putEvent(t, w, models.EventBookingCreated)
+
+	require.NoError(t, w.Step(context.Background()))
+	require.Len(t, rc.requests, 1)
+	deliveries, err := database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, c.Now().Add(time.Hour), *deliveries[0].ExpiresAt)
+
+	c.Advance(59 * time.Minute)
+	require.NoError(t, w.Step(context.Background()))
+	deliveries, err = database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	c.Advance(time.Minute)
+	require.NoError(t, w.Step(context.Background()))
+	deliveries, err = database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	timers, err := database.Values[*models.DeliveryTimer](w.db)
+	require.NoError(t, err)
+	require.Empty(t, timers)
+	require.Len(t, rc.requests, 1)
+}
+
+func TestDeletedSubscription(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	w, _, subscription := initWorker(t, rc, models.EventAll)
+	putEvent(t, w, models.EventBookingCreated)
+	require.NoError(t, w.Dispatch())
+	require.NoError(t, w.db.Delete(subscription))
+
+	// the delivery of the deleted webhook is removed instead of being skipped forever
+	require.NoError(t, w.Deliver(context.Background()))
+	require.Empty(t, rc.requests)
+	deliveries, err := database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	timers, err := database.Values[*models.DeliveryTimer](w.db)
+	require.NoError(t, err)
+	require.Empty(t, timers)
+}
+
+func TestConcurrentDelivery(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	w, _, _ := initWorker(t, rc, models.EventAll)
+	w.Client.Timeout = 5 * time.Second
+	// the slow webhook only answers once the other webhook received its delivery
+	received := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-received:
+			rw.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(received)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer fast.Close()
+	require.NoError(t, w.db.Delete(&models.WebhookSubscription{ID: "hook"}))
+	require.NoError(t, w.db.Put(
+		&models.WebhookSubscription{ID: "a-slow", URL: slow.URL, Events: []string{models.EventAll}},
+		&models.WebhookSubscription{ID: "b-fast", URL: fast.URL, Events: []string{models.EventAll}},
+	))
+	putEvent(t, w, models.EventBookingCreated)
+
+	require.NoError(t, w.Step(context.Background()))
+	deliveries, err := database.Values[*models.WebhookDelivery](w.db)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		require.Equal(t, models.DeliveryStatusDelivered, delivery.Status, delivery.SubscriptionID)
+	}
+}
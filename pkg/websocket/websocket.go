@@ -0,0 +1,441 @@
+// Package websocket implements the subset of RFC 6455 that is needed by the service: the opening
+// handshake on the server and the client side, unfragmented writes, fragmented reads, ping/pong and
+// the closing handshake. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcodes of the frames.
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Status codes of close frames.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+	CloseTryAgainLater    = 1013
+)
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxControlPayload is the maximum payload of ping, pong and close frames.
+	maxControlPayload = 125
+	// closeTimeout is the time to write a close frame.
+	closeTimeout = time.Second
+)
+
+var (
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	ErrBadHandshake  = errors.New("websocket: bad handshake")
+)
+
+// CloseError is returned by ReadMessage when the peer closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with status %d %s", e.Code, e.Text)
+}
+
+// IsClose reports whether err is a CloseError with one of the codes.
+func IsClose(err error, codes ...int) bool {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	for _, code := range codes {
+		if closeErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a WebSocket connection. ReadMessage must only be called from one goroutine, all write
+// methods are safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// ReadLimit is the maximum size of a message, larger messages close the connection.
+	ReadLimit int64
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, client: client, ReadLimit: 32 << 10}
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	_, _ = io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether a browser request comes from the host it is sent to. Requests without
+// an Origin header are not sent by browsers and accepted.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade performs the opening handshake and takes over the connection of the request. If the handshake
+// fails an error response is sent.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(code int, msg string) (*Conn, error) {
+		http.Error(w, msg, code)
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid websocket key")
+	}
+	if !sameOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	// the deadlines of the server timeouts still apply to the hijacked connection
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial opens a client connection to a ws:// URL.
+func Dial(rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme: %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	u.Scheme = "http"
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: make(http.Header)}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		_ = conn.Close()
+		return nil, res, ErrBadHandshake
+	}
+	return newConn(conn, br, true), res, nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(fin bool, opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	header := make([]byte, 2, 14)
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if c.client {
+		// frames of the client are masked
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		copy(masked, payload)
+		maskBytes(mask, masked)
+		payload = masked
+	}
+	if opcode == CloseMessage {
+		c.closed = true
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	if opcode != TextMessage && opcode != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type: %d", opcode)
+	}
+	return c.writeFrame(true, opcode, data)
+}
+
+// WritePing sends a ping, the peer answers with a pong.
+func (c *Conn) WritePing(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ErrMessageTooBig
+	}
+	return c.writeFrame(true, PingMessage, data)
+}
+
+// WriteClose starts the closing handshake. No messages can be written afterwards.
+func (c *Conn) WriteClose(code int, text string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, text...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	return c.writeFrame(true, CloseMessage, payload)
+}
+
+func maskBytes(mask, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// readFrame reads the next frame, limit is the maximum payload size.
+func (c *Conn) readFrame(limit int64) (*frame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return nil, err
+	}
+	f := &frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0f)}
+	if header[0]&0x70 != 0 {
+		return nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return nil, c.fail(CloseProtocolError, "invalid masking")
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext))
+		if length < 0 {
+			return nil, c.fail(CloseProtocolError, "invalid length")
+		}
+	}
+	if f.opcode >= CloseMessage {
+		if !f.fin || length > maxControlPayload {
+			return nil, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	} else if f.opcode > BinaryMessage {
+		return nil, c.fail(CloseProtocolError, "unknown opcode")
+	} else if length > limit {
+		_ = c.fail(CloseMessageTooBig, "message too big")
+		return nil, ErrMessageTooBig
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(c.br, mask); err != nil {
+			return nil, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+// fail closes the connection with the status code and returns the error.
+func (c *Conn) fail(code int, text string) error {
+	_ = c.WriteClose(code, text)
+	return &CloseError{Code: code, Text: text}
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and pongs are skipped.
+// When the peer closes the connection, the close is confirmed and a CloseError is returned.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	opcode := -1
+	var message []byte
+	for {
+		f, err := c.readFrame(c.ReadLimit - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(true, PongMessage, f.payload); err != nil && !errors.Is(err, net.ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatusReceived}
+			if len(f.payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(f.payload))
+				closeErr.Text = string(f.payload[2:])
+			}
+			reply := closeErr.Code
+			if reply == CloseNoStatusReceived {
+				reply = CloseNormalClosure
+			}
+			_ = c.WriteClose(reply, "")
+			return 0, nil, closeErr
+		case continuationFrame:
+			if opcode == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			if opcode != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			opcode = f.opcode
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if opcode == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8")
+		}
+		return opcode, message, nil
+	}
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func echoServer(t *testing.T, readLimit int64) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadLimit = readLimit
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(opcode, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestAcceptKey(t *testing.T) {
+	// example of RFC 6455 section 1.3
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t, 1<<20)
+	conn, res, err := Dial(wsURL(srv), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	conn.ReadLimit = 1 << 20
+
+	for _, size := range []int{0, 5, 125, 126, 1000, 70000} {
+		msg := strings.Repeat("a", size)
+		require.NoError(t, conn.WriteMessage(TextMessage, []byte(msg)))
+		opcode, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, TextMessage, opcode)
+		require.Equal(t, msg, string(data))
+	}
+
+	// pings are answered while reading
+	require.NoError(t, conn.WritePing([]byte("ping")))
+	require.NoError(t, conn.WriteMessage(BinaryMessage, []byte{1, 2, 3}))
+	opcode, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, BinaryMessage, opcode)
+	require.Equal(t, []byte{1, 2, 3}, data)
+
+	require.NoError(t, conn.WriteClose(CloseNormalClosure, "bye"))
+	_, _, err = conn.ReadMessage()
+	require.True(t, IsClose(err, CloseNormalClosure))
+	require.ErrorIs(t, conn.WriteMessage(TextMessage, []byte("a")), net.ErrClosed)
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	srv := echoServer(t, 1<<20)
+	conn, _, err := Dial(wsURL(srv), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// a text message in two fragments with a ping in between
+	require.NoError(t, conn.writeFrame(false, TextMessage, []byte("hello ")))
+	require.NoError(t, conn.writeFrame(true, PingMessage, nil))
+	require.NoError(t, conn.writeFrame(true, continuationFrame, []byte("world")))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+}
+
+func TestReadLimit(t *testing.T) {
+	srv := echoServer(t, 10)
+	conn, _, err := Dial(wsURL(srv), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("0123456789a")))
+	_, _, err = conn.ReadMessage()
+	require.True(t, IsClose(err, CloseMessageTooBig))
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	srv := echoServer(t, 1<<20)
+	conn, _, err := Dial(wsURL(srv), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	_, _, err = conn.ReadMessage()
+	require.True(t, IsClose(err, CloseInvalidPayload))
+}
+
+func TestBadHandshake(t *testing.T) {
+	srv := echoServer(t, 1<<20)
+	res, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// cross-origin requests of browsers are rejected
+	_, res, err = Dial(wsURL(srv), http.Header{"Origin": []string{"http://example.com"}})
+	require.ErrorIs(t, err, ErrBadHandshake)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+}